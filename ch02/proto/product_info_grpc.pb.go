@@ -20,6 +20,7 @@ const _ = grpc.SupportPackageIsVersion7
 type ProductInfoClient interface {
 	AddProduct(ctx context.Context, in *Product, opts ...grpc.CallOption) (*ProductID, error)
 	GetProduct(ctx context.Context, in *ProductID, opts ...grpc.CallOption) (*Product, error)
+	ListProducts(ctx context.Context, in *ProductFilter, opts ...grpc.CallOption) (ProductInfo_ListProductsClient, error)
 }
 
 type productInfoClient struct {
@@ -48,12 +49,45 @@ func (c *productInfoClient) GetProduct(ctx context.Context, in *ProductID, opts
 	return out, nil
 }
 
+func (c *productInfoClient) ListProducts(ctx context.Context, in *ProductFilter, opts ...grpc.CallOption) (ProductInfo_ListProductsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ProductInfo_ServiceDesc.Streams[0], "/ecommerce.ProductInfo/listProducts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &productInfoListProductsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ProductInfo_ListProductsClient interface {
+	Recv() (*Product, error)
+	grpc.ClientStream
+}
+
+type productInfoListProductsClient struct {
+	grpc.ClientStream
+}
+
+func (x *productInfoListProductsClient) Recv() (*Product, error) {
+	m := new(Product)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ProductInfoServer is the server API for ProductInfo service.
 // All implementations must embed UnimplementedProductInfoServer
 // for forward compatibility
 type ProductInfoServer interface {
 	AddProduct(context.Context, *Product) (*ProductID, error)
 	GetProduct(context.Context, *ProductID) (*Product, error)
+	ListProducts(*ProductFilter, ProductInfo_ListProductsServer) error
 	mustEmbedUnimplementedProductInfoServer()
 }
 
@@ -67,6 +101,9 @@ func (UnimplementedProductInfoServer) AddProduct(context.Context, *Product) (*Pr
 func (UnimplementedProductInfoServer) GetProduct(context.Context, *ProductID) (*Product, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetProduct not implemented")
 }
+func (UnimplementedProductInfoServer) ListProducts(*ProductFilter, ProductInfo_ListProductsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListProducts not implemented")
+}
 func (UnimplementedProductInfoServer) mustEmbedUnimplementedProductInfoServer() {}
 
 // UnsafeProductInfoServer may be embedded to opt out of forward compatibility for this service.
@@ -116,6 +153,27 @@ func _ProductInfo_GetProduct_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProductInfo_ListProducts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ProductFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProductInfoServer).ListProducts(m, &productInfoListProductsServer{stream})
+}
+
+type ProductInfo_ListProductsServer interface {
+	Send(*Product) error
+	grpc.ServerStream
+}
+
+type productInfoListProductsServer struct {
+	grpc.ServerStream
+}
+
+func (x *productInfoListProductsServer) Send(m *Product) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // ProductInfo_ServiceDesc is the grpc.ServiceDesc for ProductInfo service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -132,6 +190,12 @@ var ProductInfo_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _ProductInfo_GetProduct_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "listProducts",
+			Handler:       _ProductInfo_ListProducts_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "product_info.proto",
 }
@@ -2,61 +2,187 @@ package main
 
 import (
 	"context"
+	"flag"
 	pb "github.com/eadydb/grpc-samples/ch02/proto"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/loadshed"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
 	"github.com/gofrs/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	"log"
 	"net"
+	"strings"
+	"sync"
 )
 
-const (
-	port = ":50051"
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
 )
 
 // server is used to implements ecommerce/product_info
 type server struct {
+	mu         sync.Mutex
 	productMap map[string]*pb.Product
 	pb.UnimplementedProductInfoServer
 }
 
+// stats reports the size of the store for the admin endpoint.
+func (s *server) stats() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]int{"products": len(s.productMap)}
+}
+
 func (s *server) AddProduct(ctx context.Context, in *pb.Product) (*pb.ProductID, error) {
 	out, err := uuid.NewV4()
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Error while generating Product ID", err)
+		return nil, status.Errorf(codes.Internal, "Error while generating Product ID: %v", err)
 	}
 	in.Id = out.String()
 
+	s.mu.Lock()
 	if s.productMap == nil {
 		s.productMap = make(map[string]*pb.Product)
 	}
 	s.productMap[in.Id] = in
+	s.mu.Unlock()
 
 	return &pb.ProductID{Value: in.Id}, status.New(codes.OK, "").Err()
 }
 
 func (s *server) GetProduct(ctx context.Context, in *pb.ProductID) (*pb.Product, error) {
+	s.mu.Lock()
 	value, exists := s.productMap[in.Value]
+	s.mu.Unlock()
 
 	if exists {
 		return value, status.New(codes.OK, "").Err()
 	}
-	return nil, status.Errorf(codes.NotFound, "Product does not exist.", in.Value)
+	return nil, status.Errorf(codes.NotFound, "Product does not exist. : %s", in.Value)
+}
+
+// Server-side Streaming RPC
+func (s *server) ListProducts(filter *pb.ProductFilter, stream pb.ProductInfo_ListProductsServer) error {
+	// Collect the matches under the lock and send them without it, so that
+	// a slow client does not hold up the other calls.
+	var products []*pb.Product
+	s.mu.Lock()
+	for _, product := range s.productMap {
+		if strings.Contains(product.Name, filter.Name) {
+			products = append(products, product)
+		}
+	}
+	s.mu.Unlock()
+	for _, product := range products {
+		if err := stream.Send(product); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func main() {
-	list, err := net.Listen("tcp", port)
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50051")
+	var authFlags auth.ServerFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ServerFlags
+	rateFlags.Register(flag.CommandLine)
+	var shedFlags loadshed.ServerFlags
+	shedFlags.Register(flag.CommandLine)
+	var deadlineFlags deadline.ServerFlags
+	deadlineFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("product-server", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	authOpts, err := authFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	rateOpts, err := rateFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure rate limiting: %v", err)
+	}
+	cfg.Reloadable("rate_limit_config", rateFlags.Reload)
+	shedOpts, err := shedFlags.ServerOptions("product-server")
+	if err != nil {
+		log.Fatalf("failed to configure load shedding: %v", err)
+	}
+	deadlineOpts, err := deadlineFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure deadline admission: %v", err)
+	}
+	healthDial, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	healthDeps, err := healthFlags.Dependencies(healthDial)
+	if err != nil {
+		log.Fatalf("failed to configure health checks: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+
+	list, err := net.Listen("tcp", serverCfg.Listen)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterProductInfoServer(s, &server{})
+	opts := append(tracing.ServerOptions(tracer), authOpts...)
+	opts = append(opts, tlsOpts...)
+	opts = append(opts, deadlineOpts...)
+	opts = append(opts, rateOpts...)
+	opts = append(opts, shedOpts...)
+	run := runFlags.Runner()
+	opts = append(opts, run.ServerOptions()...)
+	s := grpc.NewServer(opts...)
+	ser := &server{}
+	pb.RegisterProductInfoServer(s, ser)
+	reflection.Register(s)
+
+	health := healthcheck.New(healthFlags.Interval)
+	health.AddService(pb.ProductInfo_ServiceDesc.ServiceName, healthDeps...)
+	health.Register(s)
+	health.Start()
+	run.OnNotReady(health.Shutdown)
+	run.OnStopped(health.Stop)
+
+	adm.AddServer("product-server", s)
+	adm.AddStats("product_store", ser.stats)
+	run.OnStopped(adm.Stop)
 
-	log.Printf("Starting gRPC listener on port %s", port)
+	log.Printf("Starting gRPC listener on port %s", serverCfg.Listen)
 
-	if err := s.Serve(list); err != nil {
+	if err := run.Run(s, list); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
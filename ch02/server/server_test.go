@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	pb "github.com/eadydb/grpc-samples/ch02/proto"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func startServer(t *testing.T) pb.ProductInfoClient {
+	ser := &server{}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterProductInfoServer(s, ser) })
+	return pb.NewProductInfoClient(conn)
+}
+
+// addProducts adds the fixture products and returns their IDs by name.
+func addProducts(t *testing.T, client pb.ProductInfoClient) map[string]string {
+	ids := make(map[string]string)
+	for _, fp := range grpctest.Products {
+		p := &pb.Product{Name: fp.Name, Description: fp.Description, Price: fp.Price}
+		id, err := client.AddProduct(grpctest.Context(t), p)
+		if err != nil {
+			t.Fatalf("AddProduct %s: %v", p.Name, err)
+		}
+		ids[p.Name] = id.Value
+	}
+	return ids
+}
+
+func TestAddAndGetProduct(t *testing.T) {
+	client := startServer(t)
+	ids := addProducts(t, client)
+	got, err := client.GetProduct(grpctest.Context(t), &pb.ProductID{Value: ids["Kindle"]})
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Id != ids["Kindle"] || got.Name != "Kindle" {
+		t.Errorf("GetProduct returned %v, want Kindle with ID %s", got, ids["Kindle"])
+	}
+}
+
+func TestGetProductNotFound(t *testing.T) {
+	client := startServer(t)
+	_, err := client.GetProduct(grpctest.Context(t), &pb.ProductID{Value: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetProduct of a missing product returned %v, want NotFound", err)
+	}
+}
+
+func TestListProducts(t *testing.T) {
+	client := startServer(t)
+	addProducts(t, client)
+	stream, err := client.ListProducts(grpctest.Context(t), &pb.ProductFilter{Name: "Pixel"})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	var names []string
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"Pixel 7", "Pixel Buds"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %q, want %q", names, want)
+	}
+}
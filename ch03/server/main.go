@@ -2,11 +2,23 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	pb "github.com/eadydb/grpc-samples/ch03/proto"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/loadshed"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	"io"
 	"log"
@@ -15,28 +27,44 @@ import (
 	"sync"
 )
 
-const (
-	port           = ":50051"
-	orderBatchSize = 3
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
 )
 
 type server struct {
 	orderMap map[string]*pb.Order
-	mu       sync.Mutex
+	batch    *config.Batch
+	mu       sync.RWMutex
 	pb.UnimplementedOrderManagementServer
 }
 
+// stats reports the size of the store for the admin endpoint.
+func (s *server) stats() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]int{"orders": len(s.orderMap)}
+}
+
 func (s *server) GetOrder(_ context.Context, orderId *wrappers.StringValue) (*pb.Order, error) {
+	s.mu.RLock()
 	ord, exists := s.orderMap[orderId.Value]
+	s.mu.RUnlock()
 	if exists {
 		return ord, status.New(codes.OK, "").Err()
 	}
-	return nil, status.Errorf(codes.NotFound, "order dos not exist. :", orderId)
+	return nil, status.Errorf(codes.NotFound, "order does not exist. : %s", orderId.GetValue())
 }
 
 // Server-side Streaming RPC
 func (s *server) SearchOrders(searchQuery *wrappers.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
+	// Take a snapshot so that no lock is held while sending.
+	s.mu.RLock()
+	orders := make(map[string]*pb.Order, len(s.orderMap))
 	for key, order := range s.orderMap {
+		orders[key] = order
+	}
+	s.mu.RUnlock()
+	for key, order := range orders {
 		log.Print(key, order)
 		for _, itemStr := range order.Items {
 			log.Print(itemStr)
@@ -66,7 +94,9 @@ func (s *server) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) erro
 		if err != nil {
 			return err
 		}
+		s.mu.Lock()
 		s.orderMap[order.Id] = order
+		s.mu.Unlock()
 		log.Printf("Order ID : %s - %s", order.Id, "Updated")
 		orderStr += order.Id + ", "
 	}
@@ -76,8 +106,21 @@ func (s *server) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) erro
 func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
 	batchMarker := 1
 	var combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+	processed := 0
+	recv := runner.NewReceiver(stream.Context(), func() (interface{}, error) { return stream.Recv() })
+	defer recv.Stop()
 	for {
-		orderId, err := stream.Recv()
+		msg, err := recv.Recv()
+		if err == runner.ErrDraining {
+			// Ship what has been combined so far before the server goes away.
+			for _, shipment := range combinedShipmentMap {
+				if err := stream.Send(shipment); err != nil {
+					return err
+				}
+			}
+			return runner.Drained(processed)
+		}
+		orderId, _ := msg.(*wrappers.StringValue)
 		log.Printf("Reading Proc order: %s", orderId)
 		if err == io.EOF {
 			log.Printf("EOF: %s", orderId)
@@ -86,6 +129,7 @@ func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) er
 					return err
 				}
 			}
+			return nil
 		}
 
 		if err != nil {
@@ -93,22 +137,24 @@ func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) er
 			return err
 		}
 
-		destination := s.orderMap[orderId.GetValue()].Destination
+		s.mu.RLock()
+		ord := s.orderMap[orderId.GetValue()]
+		s.mu.RUnlock()
+		processed++
+		destination := ord.Destination
 		shipment, found := combinedShipmentMap[destination]
 
 		if found {
-			ord := s.orderMap[orderId.GetValue()]
 			shipment.OrderList = append(shipment.OrderList, ord)
 		} else {
 			shipment := &pb.CombinedShipment{}
-			comShip := &pb.CombinedShipment{Id: "cmb-" + (s.orderMap[orderId.GetValue()].Destination), Status: "Processed!"}
-			ord := s.orderMap[orderId.GetValue()]
+			comShip := &pb.CombinedShipment{Id: "cmb-" + destination, Status: "Processed!"}
 			comShip.OrderList = append(shipment.OrderList, ord)
 			combinedShipmentMap[destination] = comShip
 			log.Print(len(comShip.OrderList), comShip.GetId())
 		}
 
-		if batchMarker == orderBatchSize {
+		if batchMarker >= s.batch.Size() {
 			for _, comb := range combinedShipmentMap {
 				log.Printf("Shipping : %v -> %v", comb.Id, len(comb.OrderList))
 				if err := stream.Send(comb); err != nil {
@@ -139,21 +185,102 @@ func (s *server) initSampleData() {
 }
 
 func main() {
-	ser := &server{}
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50051")
+	var batch config.Batch
+	batch.Register(cfg, 3)
+	var authFlags auth.ServerFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ServerFlags
+	rateFlags.Register(flag.CommandLine)
+	var shedFlags loadshed.ServerFlags
+	shedFlags.Register(flag.CommandLine)
+	var deadlineFlags deadline.ServerFlags
+	deadlineFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("order-server", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	authOpts, err := authFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	rateOpts, err := rateFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure rate limiting: %v", err)
+	}
+	cfg.Reloadable("rate_limit_config", rateFlags.Reload)
+	shedOpts, err := shedFlags.ServerOptions("order-server")
+	if err != nil {
+		log.Fatalf("failed to configure load shedding: %v", err)
+	}
+	deadlineOpts, err := deadlineFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure deadline admission: %v", err)
+	}
+	healthDial, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	healthDeps, err := healthFlags.Dependencies(healthDial)
+	if err != nil {
+		log.Fatalf("failed to configure health checks: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+
+	ser := &server{batch: &batch}
 	ser.initSampleData()
 
-	list, err := net.Listen("tcp", port)
+	list, err := net.Listen("tcp", serverCfg.Listen)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	opts := append(tracing.ServerOptions(tracer), authOpts...)
+	opts = append(opts, tlsOpts...)
+	opts = append(opts, deadlineOpts...)
+	opts = append(opts, rateOpts...)
+	opts = append(opts, shedOpts...)
+	run := runFlags.Runner()
+	opts = append(opts, run.ServerOptions()...)
+	s := grpc.NewServer(opts...)
 
 	pb.RegisterOrderManagementServer(s, ser)
+	reflection.Register(s)
+
+	health := healthcheck.New(healthFlags.Interval)
+	health.AddService(pb.OrderManagement_ServiceDesc.ServiceName, healthDeps...)
+	health.Register(s)
+	health.Start()
+	run.OnNotReady(health.Shutdown)
+	run.OnStopped(health.Stop)
+
+	adm.AddServer("order-server", s)
+	adm.AddStats("order_store", ser.stats)
+	run.OnStopped(adm.Stop)
 
-	log.Printf("Starting gRPC listener on port %s", port)
+	log.Printf("Starting gRPC listener on port %s", serverCfg.Listen)
 
-	if err := s.Serve(list); err != nil {
+	if err := run.Run(s, list); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
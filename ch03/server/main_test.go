@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	pb "github.com/eadydb/grpc-samples/ch03/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+// startServer serves the fixture orders, shipping batch orders together.
+func startServer(t *testing.T, batch int) (pb.OrderManagementClient, *server) {
+	ser := &server{orderMap: sampleOrders(), batch: config.NewBatch(batch)}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) })
+	return pb.NewOrderManagementClient(conn), ser
+}
+
+func TestGetOrder(t *testing.T) {
+	client, ser := startServer(t, 1)
+	ctx := grpctest.Context(t)
+	got, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if want := ser.orderMap["201"]; !proto.Equal(got, want) {
+		t.Errorf("GetOrder returned %v, want %v", got, want)
+	}
+	_, err = client.GetOrder(ctx, &wrappers.StringValue{Value: "999"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetOrder of a missing order returned %v, want NotFound", err)
+	}
+}
+
+func TestSearchOrders(t *testing.T) {
+	client, _ := startServer(t, 1)
+	stream, err := client.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatalf("SearchOrders: %v", err)
+	}
+	var ids []string
+	for {
+		o, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		ids = append(ids, o.Id)
+	}
+	sort.Strings(ids)
+	if want := []string{"202", "204"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("found orders %q, want %q", ids, want)
+	}
+}
+
+func TestUpdateOrders(t *testing.T) {
+	client, ser := startServer(t, 1)
+	stream, err := client.UpdateOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("UpdateOrders: %v", err)
+	}
+	for _, id := range []string{"201", "202"} {
+		if err := stream.Send(&pb.Order{Id: id, Items: []string{"Updated"}, Destination: "Madrid"}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if want := "Orders processed Updated Order IDs: 201, 202, "; res.Value != want {
+		t.Errorf("UpdateOrders returned %q, want %q", res.Value, want)
+	}
+	if d := ser.orderMap["202"].Destination; d != "Madrid" {
+		t.Errorf("order 202 goes to %q after the update, want Madrid", d)
+	}
+}
+
+func TestProcessOrders(t *testing.T) {
+	client, _ := startServer(t, 2)
+	stream, err := client.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"201", "202", "203", "204"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	stream.CloseSend()
+	shipped := make(map[string][]string)
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		for _, o := range s.OrderList {
+			shipped[s.Id] = append(shipped[s.Id], o.Id)
+		}
+	}
+	for _, ids := range shipped {
+		sort.Strings(ids)
+	}
+	if want := map[string][]string{"cmb-Berlin": {"201", "203"}, "cmb-Paris": {"202", "204"}}; !reflect.DeepEqual(shipped, want) {
+		t.Errorf("shipped %v, want %v", shipped, want)
+	}
+}
@@ -2,12 +2,26 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/loadshed"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"log"
 	"net"
@@ -17,45 +31,74 @@ import (
 )
 
 const (
-	port           = ":50051"
-	orderBatchSize = 3
+	responseMargin = 50 * time.Millisecond
+)
+
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
 )
 
 type server struct {
-	orderMap map[string]*pb.Order
-	mu       sync.Mutex
+	orderMap  map[string]*pb.Order
+	batch     *config.Batch
+	mu        sync.RWMutex
+	abandoner *cancellation.Abandoner
 	pb.UnimplementedOrderManagementServer
 }
 
+// stats reports the size of the store for the admin endpoint.
+func (s *server) stats() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]int{"orders": len(s.orderMap)}
+}
+
 func (s *server) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.StringValue, error) {
-	s.orderMap[orderReq.Id] = orderReq
+	// Stop the work a little before the caller's deadline so that the
+	// answer still reaches it.
+	workCtx, cancel := deadline.Downstream(ctx, responseMargin)
+	defer cancel()
 
 	sleepDuration := 5
 	log.Println("Sleeping for :", sleepDuration, "s")
 
-	time.Sleep(time.Duration(sleepDuration) * time.Second)
-
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Printf("RPC has reached deadline exceeded state : %s", ctx.Err())
-		return nil, ctx.Err()
+	if err := deadline.Sleep(workCtx, time.Duration(sleepDuration)*time.Second); err != nil {
+		log.Printf("RPC has reached deadline exceeded state : %s", err)
+		return nil, err
 	}
 
+	s.mu.Lock()
+	s.orderMap[orderReq.Id] = orderReq
+	s.mu.Unlock()
 	log.Println("Order : ", orderReq.Id, " -> Added")
 	return &wrappers.StringValue{Value: "Order Added: " + orderReq.Id}, nil
 
 }
 
 func (s *server) GetOrder(_ context.Context, orderId *wrappers.StringValue) (*pb.Order, error) {
+	s.mu.RLock()
 	ord, exists := s.orderMap[orderId.Value]
+	s.mu.RUnlock()
 	if exists {
 		return ord, status.New(codes.OK, "").Err()
 	}
-	return nil, status.Errorf(codes.NotFound, "order dos not exist. :", orderId)
+	return nil, status.Errorf(codes.NotFound, "order does not exist. : %s", orderId.GetValue())
 }
 
 // Server-side Streaming RPC
 func (s *server) SearchOrders(searchQuery *wrappers.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
+	// Take a snapshot so that no lock is held while sending.
+	s.mu.RLock()
+	orders := make(map[string]*pb.Order, len(s.orderMap))
 	for key, order := range s.orderMap {
+		orders[key] = order
+	}
+	s.mu.RUnlock()
+	for key, order := range orders {
+		// Stop searching as soon as nobody waits for the results.
+		if err := deadline.Err(stream.Context()); err != nil {
+			return err
+		}
 		log.Print(key, order)
 		for _, itemStr := range order.Items {
 			log.Print(itemStr)
@@ -75,59 +118,134 @@ func (s *server) SearchOrders(searchQuery *wrappers.StringValue, stream pb.Order
 
 // Client-side streaming RPC
 func (s *server) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) error {
+	// The updates are applied together once the client has sent them all,
+	// so a cancelled stream leaves the orders untouched.
+	var updates []*pb.Order
+	cancellation.FromContext(stream.Context()).OnCancel(func(cause error) {
+		msgs := make([]proto.Message, len(updates))
+		for i, u := range updates {
+			msgs[i] = u
+		}
+		s.abandoner.Abandon("updateOrders", cause, msgs...)
+	})
+
 	orderStr := "Updated Order IDs: "
 	for {
 		order, err := stream.Recv()
 		if err == io.EOF {
+			s.mu.Lock()
+			for _, u := range updates {
+				s.orderMap[u.Id] = u
+				log.Printf("Order ID : %s - %s", u.Id, "Updated")
+			}
+			s.mu.Unlock()
 			return stream.SendAndClose(&wrappers.StringValue{Value: "Orders processed " + orderStr})
 		}
 
 		if err != nil {
 			return err
 		}
-		s.orderMap[order.Id] = order
-		log.Printf("Order ID : %s - %s", order.Id, "Updated")
+		updates = append(updates, order)
 		orderStr += order.Id + ", "
 	}
 }
 
+// replay finishes a batch persisted by a cancelled call before a restart:
+// pending updates are applied, while orders whose shipment never reached the
+// client are only logged.
+func (s *server) replay(b *cancellation.Batch) error {
+	for i := range b.Items {
+		switch b.Method {
+		case "updateOrders":
+			u := new(pb.Order)
+			if err := b.Unmarshal(i, u); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			s.orderMap[u.Id] = u
+			s.mu.Unlock()
+			log.Printf("Order ID : %s - %s", u.Id, "Updated from pending batch")
+		case "processOrders":
+			var orderId wrappers.StringValue
+			if err := b.Unmarshal(i, &orderId); err != nil {
+				return err
+			}
+			log.Printf("Order ID : %s - %s", orderId.Value, "Never shipped")
+		default:
+			return fmt.Errorf("unknown method %q", b.Method)
+		}
+	}
+	return nil
+}
+
 // Bi-Directional Streaming RPC
 func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
 	batchMarker := 1
 	var combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+	processed := 0
+	// The order IDs of the batch not shipped yet.
+	var batchIds []proto.Message
+	cancellation.FromContext(stream.Context()).OnCancel(func(cause error) {
+		s.abandoner.Abandon("processOrders", cause, batchIds...)
+	})
+
+	recv := runner.NewReceiver(stream.Context(), func() (interface{}, error) { return stream.Recv() })
+	defer recv.Stop()
 	for {
-		orderId, err := stream.Recv()
-		log.Printf("Reading Proc order: %s", orderId)
+		msg, err := recv.Recv()
+		if err == runner.ErrDraining {
+			// Ship what has been combined so far before the server goes away.
+			for _, shipment := range combinedShipmentMap {
+				if err := stream.Send(shipment); err != nil {
+					return err
+				}
+			}
+			return runner.Drained(processed)
+		}
 		if err == io.EOF {
-			log.Printf("EOF: %s", orderId)
+			log.Print("EOF")
 			for _, shipment := range combinedShipmentMap {
 				if err := stream.Send(shipment); err != nil {
 					return err
 				}
 			}
+			return nil
 		}
 
 		if err != nil {
 			log.Println(err)
 			return err
 		}
+		orderId := msg.(*wrappers.StringValue)
+		log.Printf("Reading Proc order: %s", orderId)
+		// Messages may still arrive after the client cancelled; don't
+		// start on them.
+		if err := deadline.Err(stream.Context()); err != nil {
+			return err
+		}
+		s.mu.RLock()
+		ord, exists := s.orderMap[orderId.GetValue()]
+		s.mu.RUnlock()
+		if !exists {
+			return status.Errorf(codes.NotFound, "order does not exist. : %s", orderId.GetValue())
+		}
+		batchIds = append(batchIds, orderId)
 
-		destination := s.orderMap[orderId.GetValue()].Destination
+		processed++
+		destination := ord.Destination
 		shipment, found := combinedShipmentMap[destination]
 
 		if found {
-			ord := s.orderMap[orderId.GetValue()]
 			shipment.OrderList = append(shipment.OrderList, ord)
 		} else {
 			shipment := &pb.CombinedShipment{}
-			comShip := &pb.CombinedShipment{Id: "cmb-" + (s.orderMap[orderId.GetValue()].Destination), Status: "Processed!"}
-			ord := s.orderMap[orderId.GetValue()]
+			comShip := &pb.CombinedShipment{Id: "cmb-" + destination, Status: "Processed!"}
 			comShip.OrderList = append(shipment.OrderList, ord)
 			combinedShipmentMap[destination] = comShip
 			log.Print(len(comShip.OrderList), comShip.GetId())
 		}
 
-		if batchMarker == orderBatchSize {
+		if batchMarker >= s.batch.Size() {
 			for _, comb := range combinedShipmentMap {
 				log.Printf("Shipping : %v -> %v", comb.Id, len(comb.OrderList))
 				if err := stream.Send(comb); err != nil {
@@ -136,6 +254,7 @@ func (s *server) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) er
 			}
 			batchMarker = 0
 			combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+			batchIds = nil
 		} else {
 			batchMarker++
 		}
@@ -158,21 +277,126 @@ func (s *server) initSampleData() {
 }
 
 func main() {
-	ser := &server{}
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50051")
+	var batch config.Batch
+	batch.Register(cfg, 3)
+	var authFlags auth.ServerFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ServerFlags
+	rateFlags.Register(flag.CommandLine)
+	var shedFlags loadshed.ServerFlags
+	shedFlags.Register(flag.CommandLine)
+	var deadlineFlags deadline.ServerFlags
+	deadlineFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	var cancelFlags cancellation.ServerFlags
+	cancelFlags.Register(flag.CommandLine)
+	cfg.Check(cancelFlags.Validate)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("order-server", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	authOpts, err := authFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	rateOpts, err := rateFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure rate limiting: %v", err)
+	}
+	cfg.Reloadable("rate_limit_config", rateFlags.Reload)
+	shedOpts, err := shedFlags.ServerOptions("order-server")
+	if err != nil {
+		log.Fatalf("failed to configure load shedding: %v", err)
+	}
+	deadlineOpts, err := deadlineFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure deadline admission: %v", err)
+	}
+	healthDial, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	healthDeps, err := healthFlags.Dependencies(healthDial)
+	if err != nil {
+		log.Fatalf("failed to configure health checks: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	ser := &server{batch: &batch}
 	ser.initSampleData()
+	// Finish the work cancelled calls left pending, before the pending store
+	// is opened for new batches.
+	replayed, err := cancellation.Replay(cancelFlags.PendingFile, ser.replay)
+	if err != nil {
+		log.Printf("failed to replay pending work: %v", err)
+	}
+	if replayed > 0 {
+		log.Printf("replayed %d pending batches from %s", replayed, cancelFlags.PendingFile)
+	}
+	abandoner, err := cancelFlags.Abandoner()
+	if err != nil {
+		log.Fatalf("failed to configure cancellation policy: %v", err)
+	}
+	cfg.Reloadable("cancel_policy", func() error { return cancelFlags.Reload(abandoner) })
+	ser.abandoner = abandoner
 
-	list, err := net.Listen("tcp", port)
+	list, err := net.Listen("tcp", serverCfg.Listen)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	opts := append(tracing.ServerOptions(tracer), authOpts...)
+	opts = append(opts, tlsOpts...)
+	opts = append(opts, deadlineOpts...)
+	opts = append(opts, rateOpts...)
+	opts = append(opts, shedOpts...)
+	opts = append(opts, cancellation.ServerOptions()...)
+	run := runFlags.Runner()
+	run.OnStopped(func() {
+		if err := abandoner.Close(); err != nil {
+			log.Printf("failed to close pending store: %v", err)
+		}
+	})
+	opts = append(opts, run.ServerOptions()...)
+	s := grpc.NewServer(opts...)
 
 	pb.RegisterOrderManagementServer(s, ser)
+	reflection.Register(s)
+
+	health := healthcheck.New(healthFlags.Interval)
+	health.AddService(pb.OrderManagement_ServiceDesc.ServiceName, append(healthDeps, healthcheck.Dependency{Name: "pending store", Check: abandoner.Check})...)
+	health.Register(s)
+	health.Start()
+	run.OnNotReady(health.Shutdown)
+	run.OnStopped(health.Stop)
+
+	adm.AddServer("order-server", s)
+	adm.AddStats("order_store", ser.stats)
+	adm.AddStats("cancellation", abandoner.Stats)
+	run.OnStopped(adm.Stop)
 
-	log.Printf("Starting gRPC listener on port %s", port)
+	log.Printf("Starting gRPC listener on port %s", serverCfg.Listen)
 
-	if err := s.Serve(list); err != nil {
+	if err := run.Run(s, list); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+// startServer serves the fixture orders with the cancellation interceptors,
+// persisting abandoned work to the returned store. Every RecvMsg call of a
+// stream handler is reported on calls: the handlers read one message at a
+// time, so a call means the previous message has been dealt with.
+func startServer(t *testing.T, batch int, calls chan<- struct{}) (pb.OrderManagementClient, *server, *cancellation.MemoryStore) {
+	pending := new(cancellation.MemoryStore)
+	ser := &server{
+		orderMap:  sampleOrders(),
+		batch:     config.NewBatch(batch),
+		abandoner: &cancellation.Abandoner{Policy: cancellation.Persist, Store: pending},
+	}
+	notify := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &notifyingStream{ServerStream: ss, calls: calls})
+	}
+	opts := append(cancellation.ServerOptions(), grpc.ChainStreamInterceptor(notify))
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) }, opts...)
+	return pb.NewOrderManagementClient(conn), ser, pending
+}
+
+type notifyingStream struct {
+	grpc.ServerStream
+	calls chan<- struct{}
+}
+
+func (s *notifyingStream) RecvMsg(m interface{}) error {
+	s.calls <- struct{}{}
+	return s.ServerStream.RecvMsg(m)
+}
+
+// waitForBatch waits for the cleanup hooks, which run after the call ended.
+func waitForBatch(t *testing.T, pending *cancellation.MemoryStore) *cancellation.Batch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pending.Batches()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no batch was persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return pending.Batches()[0]
+}
+
+func items(b *cancellation.Batch) []string {
+	var items []string
+	for _, item := range b.Items {
+		items = append(items, string(item))
+	}
+	return items
+}
+
+func TestCancelledUpdateOrdersLeavesOrders(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	client, ser, pending := startServer(t, 1, calls)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.UpdateOrders(ctx)
+	if err != nil {
+		t.Fatalf("UpdateOrders: %v", err)
+	}
+	<-calls
+	if err := stream.Send(&pb.Order{Id: "201", Destination: "Madrid"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-calls
+	cancel()
+	// Not CloseAndRecv: the half close could reach the server first and
+	// complete the update.
+	if err := stream.RecvMsg(new(wrappers.StringValue)); status.Code(err) != codes.Canceled {
+		t.Fatalf("RecvMsg returned %v, want Canceled", err)
+	}
+
+	b := waitForBatch(t, pending)
+	if b.Method != "updateOrders" || len(b.Items) != 1 {
+		t.Errorf("persisted %s with %q, want updateOrders with order 201", b.Method, items(b))
+	}
+	if d := ser.orderMap["201"].Destination; d != "Berlin" {
+		t.Errorf("order 201 goes to %q after the cancelled update, want Berlin", d)
+	}
+}
+
+func TestCancelledProcessOrdersPersistsBatch(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	client, _, pending := startServer(t, 3, calls)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"202", "204"} {
+		<-calls
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	<-calls
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("Recv returned %v, want Canceled", err)
+	}
+
+	b := waitForBatch(t, pending)
+	if want := []string{`"202"`, `"204"`}; b.Method != "processOrders" || !reflect.DeepEqual(items(b), want) {
+		t.Errorf("persisted %s with %q, want processOrders with %q", b.Method, items(b), want)
+	}
+}
+
+func TestCancelledAddOrderReturnsEarly(t *testing.T) {
+	client, ser, _ := startServer(t, 1, make(chan struct{}, 10))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.AddOrder(ctx, &pb.Order{Id: "301"})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("AddOrder returned %v, want Canceled", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("AddOrder took %v", d)
+	}
+	// The handler stops sleeping at the cancellation too.
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := ser.orderMap["301"]; ok {
+		t.Error("the order was stored although the call was cancelled")
+	}
+}
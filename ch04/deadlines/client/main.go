@@ -2,24 +2,84 @@ package main
 
 import (
 	"context"
+	"flag"
 	pb "github.com/eadydb/grpc-samples/ch04/deadlines/proto"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/breaker"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/retry"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
 	"log"
 	"time"
 )
 
-const (
-	address = "localhost:50051"
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
 )
 
 func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var clientCfg config.Client
+	clientCfg.Register(cfg, "localhost:50051")
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ClientFlags
+	rateFlags.Register(flag.CommandLine)
+	var breakerFlags breaker.ClientFlags
+	breakerFlags.Register(flag.CommandLine)
+	var retryFlags retry.ClientFlags
+	retryFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("order-client", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	authOpts, err := authFlags.DialOptions(tlsOpt)
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	retryOpts, err := retryFlags.DialOptions()
+	if err != nil {
+		log.Fatalf("failed to configure retries: %v", err)
+	}
+	breakerOpts, err := breakerFlags.DialOptions()
+	if err != nil {
+		log.Fatalf("failed to configure circuit breakers: %v", err)
+	}
+
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	defer adm.Stop()
 
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	opts := append([]grpc.DialOption{tlsOpt}, tracing.DialOptions(tracer)...)
+	opts = append(opts, authOpts...)
+	opts = append(opts, rateFlags.DialOptions()...)
+	opts = append(opts, retryOpts...)
+	opts = append(opts, breakerOpts...)
+	conn, err := grpc.Dial(clientCfg.Address, opts...)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
+	adm.AddConn(clientCfg.Address, conn)
+	defer adm.Linger()
 	c := pb.NewOrderManagementClient(conn)
 
 	// Deadlines and timeouts are two commonly used patterns in distributed computing.
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/eadydb/grpc-samples/ch04/deadlines/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+func startServer(t *testing.T) (pb.OrderManagementClient, *server) {
+	ser := &server{orderMap: sampleOrders(), batch: config.NewBatch(1)}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) })
+	return pb.NewOrderManagementClient(conn), ser
+}
+
+func TestAddOrderDeadlineExceeded(t *testing.T) {
+	client, ser := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.AddOrder(ctx, &pb.Order{Id: "301", Destination: "Rome"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("AddOrder returned %v, want DeadlineExceeded", err)
+	}
+	// The server gives up at the deadline instead of sleeping its 5s.
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("AddOrder took %v", d)
+	}
+	if _, ok := ser.orderMap["301"]; ok {
+		t.Error("the order was stored although the call timed out")
+	}
+}
+
+func TestGetOrderWithinDeadline(t *testing.T) {
+	client, _ := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	order, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "203"})
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if order.Destination != "Berlin" {
+		t.Errorf("GetOrder returned %v", order)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	pb "github.com/eadydb/grpc-samples/ch04/error-handlding/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+func startServer(t *testing.T) (pb.OrderManagementClient, *server) {
+	ser := &server{orderMap: sampleOrders(), batch: config.NewBatch(1)}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) })
+	return pb.NewOrderManagementClient(conn), ser
+}
+
+func TestAddOrder(t *testing.T) {
+	client, ser := startServer(t)
+	res, err := client.AddOrder(grpctest.Context(t), &pb.Order{Id: "301", Items: []string{"Chromecast"}, Destination: "Rome"})
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if want := "Order Added: 301"; res.Value != want {
+		t.Errorf("AddOrder returned %q, want %q", res.Value, want)
+	}
+	if _, ok := ser.orderMap["301"]; !ok {
+		t.Error("order 301 was not stored")
+	}
+}
+
+func TestAddOrderInvalidIDHasDetails(t *testing.T) {
+	client, _ := startServer(t)
+	_, err := client.AddOrder(grpctest.Context(t), &pb.Order{Id: "-1", Description: "bad"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("AddOrder returned %v, want InvalidArgument", err)
+	}
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("got details %v, want one field violation", details)
+	}
+	v, ok := details[0].(*epb.BadRequest_FieldViolation)
+	if !ok {
+		t.Fatalf("got detail %T, want *errdetails.BadRequest_FieldViolation", details[0])
+	}
+	if want := "Order ID received is not valid -1 : bad"; v.Field != "ID" || v.Description != want {
+		t.Errorf("got violation of %q: %q, want ID: %q", v.Field, v.Description, want)
+	}
+}
+
+func TestGetOrderNotFound(t *testing.T) {
+	client, _ := startServer(t)
+	_, err := client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "999"})
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "order does not exist. : 999" {
+		t.Errorf("GetOrder of a missing order returned %v, want NotFound", err)
+	}
+}
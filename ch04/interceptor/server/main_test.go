@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	pb "github.com/eadydb/grpc-samples/ch03/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+// startServer serves the fixture orders behind the chapter's interceptors.
+func startServer(t *testing.T, batch int) pb.OrderManagementClient {
+	ser := &server{orderMap: sampleOrders(), batch: config.NewBatch(batch)}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) },
+		grpc.UnaryInterceptor(orderUnaryServerInterceptor),
+		grpc.StreamInterceptor(orderServerStreamInterceptor))
+	return pb.NewOrderManagementClient(conn)
+}
+
+func TestGetOrderThroughInterceptor(t *testing.T) {
+	client := startServer(t, 1)
+	order, err := client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "202"})
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if order.Id != "202" || order.Destination != "Paris" {
+		t.Errorf("GetOrder returned %v", order)
+	}
+}
+
+func TestProcessOrdersThroughInterceptor(t *testing.T) {
+	client := startServer(t, 2)
+	stream, err := client.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"201", "203"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	stream.CloseSend()
+	var shipments []*pb.CombinedShipment
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		shipments = append(shipments, s)
+	}
+	if len(shipments) != 1 || shipments[0].Id != "cmb-Berlin" || len(shipments[0].OrderList) != 2 {
+		t.Errorf("shipped %v, want both orders in cmb-Berlin", shipments)
+	}
+}
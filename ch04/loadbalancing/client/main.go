@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
 	"google.golang.org/grpc"
 	ecpb "google.golang.org/grpc/examples/features/proto/echo"
+	_ "google.golang.org/grpc/health"
 	"google.golang.org/grpc/resolver"
 	"log"
 	"time"
@@ -15,7 +21,16 @@ const (
 	exampleServiceName = "lb.example.grpc.io"
 )
 
-var addrs = []string{"localhost:50051", "localhost:50052"}
+// backends are the addresses the example resolver hands out.
+var backends config.Backends
+
+// roundRobinServiceConfig balances over the backends whose health service
+// reports the echo service as SERVING, so calls skip backends that are down
+// or shutting down.
+const roundRobinServiceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": "grpc.examples.echo.Echo"}
+}`
 
 func callUnaryEcho(c ecpb.EchoClient, message string) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -37,27 +52,55 @@ func makeRPCs(cc *grpc.ClientConn, n int) {
 }
 
 func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	backends.Register(cfg, "localhost:50051", "localhost:50052")
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ClientFlags
+	rateFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+
+	// The backends are addressed through the example resolver, so with TLS
+	// the certificate has to be verified with -tls_server_name localhost.
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	defer adm.Stop()
+
+	opts := append([]grpc.DialOption{tlsOpt}, rateFlags.DialOptions()...)
+
 	pickFirstConn, err := grpc.Dial(
 		fmt.Sprintf("%s:///%s", exampleScheme, exampleServiceName),
-		grpc.WithInsecure(),
+		opts...,
 	)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer pickFirstConn.Close()
+	adm.AddConn("pick_first", pickFirstConn)
 
 	log.Println("==== calling helloworld.Greeter/SayHello with pick_first =====")
 	makeRPCs(pickFirstConn, 10)
 
 	roundrobinConn, err := grpc.Dial(
 		fmt.Sprintf("%s:///%s", exampleScheme, exampleServiceName),
-		grpc.WithBalancerName("round_robin"),
-		grpc.WithInsecure(),
+		append([]grpc.DialOption{grpc.WithDefaultServiceConfig(roundRobinServiceConfig)}, opts...)...,
 	)
 
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
+	defer roundrobinConn.Close()
+	adm.AddConn("round_robin", roundrobinConn)
+	defer adm.Linger()
 	log.Println("=== calling helloworld.Greeter/SayHello with round_robin ===")
 	makeRPCs(roundrobinConn, 10)
 }
@@ -70,7 +113,7 @@ func (*exampleResolverBuilder) Build(target resolver.Target, conn resolver.Clien
 		target: target,
 		cc:     conn,
 		addressStore: map[string][]string{
-			exampleServiceName: addrs,
+			exampleServiceName: backends.Addrs,
 		},
 	}
 	r.start()
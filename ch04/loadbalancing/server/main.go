@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/loadshed"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	ecpb "google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	"log"
 	"net"
 	"sync"
 )
 
-var (
-	addrs = []string{":50051", ":50052"}
-)
-
 type ecServer struct {
 	addr string
 	ecpb.UnimplementedEchoServer
@@ -36,27 +42,94 @@ func (s *ecServer) BidirectionalStreamingEcho(ecpb.Echo_BidirectionalStreamingEc
 	return status.Errorf(codes.Unimplemented, "not implemented")
 }
 
-func startServer(addr string) {
+func startServer(addr string, run *runner.Runner, health *healthcheck.Server, adm *admin.Admin, opts ...grpc.ServerOption) {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(append(opts, run.ServerOptions()...)...)
 	ecpb.RegisterEchoServer(s, &ecServer{addr: addr})
+	reflection.Register(s)
+	health.Register(s)
+	health.Start()
+	run.OnNotReady(health.Shutdown)
+	run.OnStopped(health.Stop)
+	adm.AddServer(addr, s)
 	log.Printf("serving on %s\n", addr)
-	if err := s.Serve(lis); err != nil {
+	if err := run.Run(s, lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
 
 func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var backends config.Backends
+	backends.Register(cfg, ":50051", ":50052")
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ServerFlags
+	rateFlags.Register(flag.CommandLine)
+	var shedFlags loadshed.ServerFlags
+	shedFlags.Register(flag.CommandLine)
+	var deadlineFlags deadline.ServerFlags
+	deadlineFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+
+	// Both backends share one TLS configuration, so they also reload the
+	// rotated certificate together.
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	deadlineOpts, err := deadlineFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure deadline admission: %v", err)
+	}
+	healthDial, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	healthDeps, err := healthFlags.Dependencies(healthDial)
+	if err != nil {
+		log.Fatalf("failed to configure health checks: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	cfg.Reloadable("rate_limit_config", rateFlags.Reload)
+
 	var wg sync.WaitGroup
-	for _, addr := range addrs {
+	for _, addr := range backends.Addrs {
+		// Every backend gets its own limiters, like separate processes would.
+		rateOpts, err := rateFlags.ServerOptions()
+		if err != nil {
+			log.Fatalf("failed to configure rate limiting: %v", err)
+		}
+		shedOpts, err := shedFlags.ServerOptions(addr)
+		if err != nil {
+			log.Fatalf("failed to configure load shedding: %v", err)
+		}
+		var opts []grpc.ServerOption
+		opts = append(opts, deadlineOpts...)
+		opts = append(opts, rateOpts...)
+		opts = append(opts, shedOpts...)
+		opts = append(opts, tlsOpts...)
+		health := healthcheck.New(healthFlags.Interval)
+		health.AddService(ecpb.Echo_ServiceDesc.ServiceName, healthDeps...)
+
 		wg.Add(1)
 		go func(addr string) {
 			defer wg.Done()
-			startServer(addr)
+			startServer(addr, runFlags.Runner(), health, adm, opts...)
 		}(addr)
 	}
 	wg.Wait()
+	adm.Stop()
 }
\ No newline at end of file
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	ecpb "google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/status"
+)
+
+// startBackends starts an echo backend for each address.
+func startBackends(t *testing.T, addrs ...string) map[string]*grpctest.Server {
+	servers := make(map[string]*grpctest.Server)
+	for _, addr := range addrs {
+		srv := &ecServer{addr: addr}
+		servers[addr] = grpctest.NewServer(t, func(s *grpc.Server) { ecpb.RegisterEchoServer(s, srv) })
+	}
+	return servers
+}
+
+func TestUnaryEcho(t *testing.T) {
+	servers := startBackends(t, ":50051")
+	client := ecpb.NewEchoClient(servers[":50051"].Dial(t))
+	ctx := grpctest.Context(t)
+
+	res, err := client.UnaryEcho(ctx, &ecpb.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatalf("UnaryEcho: %v", err)
+	}
+	if want := "hello (from :50051)"; res.Message != want {
+		t.Errorf("UnaryEcho returned %q, want %q", res.Message, want)
+	}
+
+	stream, err := client.BidirectionalStreamingEcho(ctx)
+	if err != nil {
+		t.Fatalf("BidirectionalStreamingEcho: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Errorf("BidirectionalStreamingEcho returned %v, want Unimplemented", err)
+	}
+}
+
+func TestRoundRobinOverBackends(t *testing.T) {
+	servers := startBackends(t, ":50051", ":50052")
+	client := ecpb.NewEchoClient(grpctest.DialBalanced(t, servers))
+	ctx := grpctest.Context(t)
+
+	// Wait for both backends to be picked, then check that they take turns.
+	seen := make(map[string]bool)
+	var last string
+	for i := 0; len(seen) < 2; i++ {
+		if i == 100 {
+			t.Fatalf("only reached %v in 100 calls", seen)
+		}
+		res, err := client.UnaryEcho(ctx, &ecpb.EchoRequest{Message: "hi"}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("UnaryEcho: %v", err)
+		}
+		seen[res.Message] = true
+		last = res.Message
+	}
+	for i := 0; i < 4; i++ {
+		res, err := client.UnaryEcho(ctx, &ecpb.EchoRequest{Message: "hi"})
+		if err != nil {
+			t.Fatalf("UnaryEcho: %v", err)
+		}
+		if res.Message == last {
+			t.Fatalf("%q answered twice in a row", last)
+		}
+		last = res.Message
+	}
+}
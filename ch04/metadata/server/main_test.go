@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/eadydb/grpc-samples/ch04/metadata/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// sampleOrders returns the fixture orders by ID.
+func sampleOrders() map[string]*pb.Order {
+	orders := make(map[string]*pb.Order)
+	for _, o := range grpctest.Orders {
+		orders[o.ID] = &pb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination}
+	}
+	return orders
+}
+
+// startServer serves the fixture orders and reports the metadata each call
+// arrives with on incoming.
+func startServer(t *testing.T, incoming chan<- metadata.MD) pb.OrderManagementClient {
+	ser := &server{orderMap: sampleOrders(), batch: config.NewBatch(1)}
+	record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		incoming <- md
+		return handler(ctx, req)
+	}
+	conn := grpctest.Start(t, func(s *grpc.Server) { pb.RegisterOrderManagementServer(s, ser) }, grpc.UnaryInterceptor(record))
+	return pb.NewOrderManagementClient(conn)
+}
+
+func TestAddOrderMetadata(t *testing.T) {
+	incoming := make(chan metadata.MD, 1)
+	client := startServer(t, incoming)
+	ctx := grpctest.Context(t)
+
+	sent := time.Now().Format(time.StampNano)
+	ctx = metadata.AppendToOutgoingContext(ctx, "timestamp", sent, "kn", "vn")
+	var header metadata.MD
+	if _, err := client.AddOrder(ctx, &pb.Order{Id: "301", Destination: "Rome"}, grpc.Header(&header)); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+
+	md := <-incoming
+	if got := md.Get("timestamp"); !reflect.DeepEqual(got, []string{sent}) {
+		t.Errorf("server got timestamp %q, want %q", got, sent)
+	}
+	if got := md.Get("kn"); !reflect.DeepEqual(got, []string{"vn"}) {
+		t.Errorf("server got kn %q, want vn", got)
+	}
+	if got := header.Get("location"); !reflect.DeepEqual(got, []string{"guangzhou"}) {
+		t.Errorf("header location is %q, want guangzhou", got)
+	}
+	if got := header.Get("timestamp"); len(got) != 1 {
+		t.Errorf("header timestamp is %q, want one value", got)
+	} else if _, err := time.Parse(time.StampNano, got[0]); err != nil {
+		t.Errorf("header timestamp %q: %v", got[0], err)
+	}
+}
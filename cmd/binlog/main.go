@@ -0,0 +1,359 @@
+// binlog prints the binary logs the servers and clients write with
+// -binlog_file as a timeline per call:
+//
+//	binlog calls.binlog calls.binlog.1
+//	binlog -method '/ecommerce.OrderManagement/*' calls.binlog
+//	binlog -call 42 -logger server calls.binlog
+//
+// Each call starts with its method, the side that logged it and the peer,
+// followed by its headers, messages, half close and trailer or cancellation,
+// each with the time since the call started. Messages are decoded to JSON
+// with the descriptors of the ecommerce services linked in or, for other
+// services, those of -protoset files such as descdump -o writes.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/eadydb/grpc-samples/ch02/proto"
+	_ "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/binlog"
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	method   = flag.String("method", "*", "print the calls of these methods: /package.Service/Method, /package.Service/* or *, comma separated, excluded with a leading -")
+	callID   = flag.Uint64("call", 0, "print only the call with this id; 0 prints every call")
+	side     = flag.String("logger", "", "print only the calls logged by the client or the server")
+	protoset = flag.String("protoset", "", "comma separated descriptor set files to decode the messages of other services with")
+	payloads = flag.Bool("payloads", true, "decode and print the messages")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: binlog [flags] file...\n\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	filter, err := binlog.ParseFilter(*method)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logger pb.GrpcLogEntry_Logger
+	switch *side {
+	case "":
+	case "client":
+		logger = pb.GrpcLogEntry_LOGGER_CLIENT
+	case "server":
+		logger = pb.GrpcLogEntry_LOGGER_SERVER
+	default:
+		log.Fatalf("-logger %q: want client or server", *side)
+	}
+	var d decoder
+	if *protoset != "" {
+		if d.src, err = descsource.FromFiles(strings.Split(*protoset, ",")...); err != nil {
+			log.Fatalf("failed to load descriptors: %v", err)
+		}
+	}
+
+	var entries []*pb.GrpcLogEntry
+	for _, path := range flag.Args() {
+		e, err := readFile(path)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", path, err)
+		}
+		entries = append(entries, e...)
+	}
+	for _, c := range group(entries) {
+		if !filter.Match(c.method) || (*callID != 0 && c.id != *callID) || (logger != 0 && c.logger != logger) {
+			continue
+		}
+		c.print(os.Stdout, &d)
+	}
+}
+
+func readFile(path string) ([]*pb.GrpcLogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*pb.GrpcLogEntry
+	r := binlog.NewReader(f)
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// call is the entries logged for one call.
+type call struct {
+	logger  pb.GrpcLogEntry_Logger
+	id      uint64
+	method  string
+	entries []*pb.GrpcLogEntry
+}
+
+// group sorts the entries into calls, ordered by their start. Call ids
+// start again at 1 when a process restarts, so a client header starts a new
+// call even under an id seen before.
+func group(entries []*pb.GrpcLogEntry) []*call {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.AsTime().Before(entries[j].Timestamp.AsTime())
+	})
+	type key struct {
+		logger pb.GrpcLogEntry_Logger
+		id     uint64
+	}
+	var calls []*call
+	current := make(map[key]*call)
+	for _, e := range entries {
+		k := key{e.Logger, e.CallId}
+		c := current[k]
+		if c == nil || (e.Type == pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER && len(c.entries) > 0) {
+			c = &call{logger: e.Logger, id: e.CallId}
+			current[k] = c
+			calls = append(calls, c)
+		}
+		if h := e.GetClientHeader(); h != nil {
+			c.method = h.MethodName
+		}
+		c.entries = append(c.entries, e)
+	}
+	for _, c := range calls {
+		sort.SliceStable(c.entries, func(i, j int) bool {
+			return c.entries[i].SequenceIdWithinCall < c.entries[j].SequenceIdWithinCall
+		})
+	}
+	return calls
+}
+
+func (c *call) print(w io.Writer, d *decoder) {
+	side := strings.ToLower(strings.TrimPrefix(c.logger.String(), "LOGGER_"))
+	method := c.method
+	if method == "" {
+		method = "(method not logged)"
+	}
+	start := c.entries[0].Timestamp.AsTime()
+	fmt.Fprintf(w, "call %d %s %s", c.id, side, method)
+	for _, e := range c.entries {
+		if e.Peer != nil {
+			fmt.Fprintf(w, " peer %s", peer(e.Peer))
+			break
+		}
+	}
+	fmt.Fprintf(w, " at %s\n", start.Format(time.RFC3339Nano))
+
+	for _, e := range c.entries {
+		offset := e.Timestamp.AsTime().Sub(start).Round(time.Microsecond)
+		event := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(e.Type.String(), "EVENT_TYPE_"), "_", " "))
+		line := fmt.Sprintf("  %-10s %-15s", "+"+offset.String(), event)
+		var md *pb.Metadata
+		var details []byte
+		switch e.Type {
+		case pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER:
+			h := e.GetClientHeader()
+			if h.Authority != "" {
+				line += " authority " + h.Authority
+			}
+			if h.Timeout != nil {
+				line += fmt.Sprintf(" timeout %v", h.Timeout.AsDuration().Round(time.Millisecond))
+			}
+			md = h.Metadata
+		case pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER:
+			md = e.GetServerHeader().Metadata
+		case pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE:
+			line += " " + d.message(c.method, e.Type == pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, e.GetMessage(), e.PayloadTruncated)
+		case pb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER:
+			t := e.GetTrailer()
+			line += " " + codes.Code(t.StatusCode).String()
+			if t.StatusMessage != "" {
+				line += fmt.Sprintf(" %q", t.StatusMessage)
+			}
+			md, details = t.Metadata, t.StatusDetails
+		}
+		if e.PayloadTruncated && md != nil {
+			line += " (metadata truncated)"
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		printMetadata(w, md)
+		d.details(w, details)
+	}
+	fmt.Fprintln(w)
+}
+
+func peer(a *pb.Address) string {
+	if a.Type == pb.Address_TYPE_IPV6 {
+		return fmt.Sprintf("[%s]:%d", a.Address, a.IpPort)
+	}
+	if a.IpPort != 0 {
+		return fmt.Sprintf("%s:%d", a.Address, a.IpPort)
+	}
+	return a.Address
+}
+
+func printMetadata(w io.Writer, md *pb.Metadata) {
+	entries := md.GetEntry()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	for _, e := range entries {
+		v := string(e.Value)
+		if strings.HasSuffix(e.Key, "-bin") {
+			v = base64.StdEncoding.EncodeToString(e.Value)
+		}
+		fmt.Fprintf(w, "  %-26s %s: %s\n", "", e.Key, v)
+	}
+}
+
+// decoder decodes messages with the descriptors of -protoset or, failing
+// that, those linked in.
+type decoder struct {
+	src *descsource.Source
+}
+
+func (d *decoder) method(fullMethod string) protoreflect.MethodDescriptor {
+	if d.src != nil {
+		if md, err := d.src.Method(fullMethod); err == nil {
+			return md
+		}
+	}
+	name := strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return nil
+	}
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return nil
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	return sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+}
+
+// resolver resolves the types of Any fields.
+func (d *decoder) resolver() interface {
+	protoregistry.ExtensionTypeResolver
+	protoregistry.MessageTypeResolver
+} {
+	if d.src != nil {
+		return resolvers{d.src.Types, protoregistry.GlobalTypes}
+	}
+	return protoregistry.GlobalTypes
+}
+
+func (d *decoder) message(fullMethod string, fromClient bool, m *pb.Message, truncated bool) string {
+	size := fmt.Sprintf("%d bytes", m.Length)
+	if truncated {
+		return fmt.Sprintf("%s, %d logged", size, len(m.Data))
+	}
+	if !*payloads {
+		return size
+	}
+	md := d.method(fullMethod)
+	if md == nil {
+		return size
+	}
+	desc := md.Output()
+	if fromClient {
+		desc = md.Input()
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := (proto.UnmarshalOptions{Resolver: d.resolver()}).Unmarshal(m.Data, msg); err != nil {
+		return fmt.Sprintf("%s, not a %s: %v", size, desc.FullName(), err)
+	}
+	b, err := protojson.MarshalOptions{Resolver: d.resolver()}.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%s, %v", size, err)
+	}
+	return fmt.Sprintf("%s %s", size, b)
+}
+
+// details prints the details of the status in a trailer.
+func (d *decoder) details(w io.Writer, status []byte) {
+	if len(status) == 0 {
+		return
+	}
+	st := new(spb.Status)
+	if err := proto.Unmarshal(status, st); err != nil {
+		fmt.Fprintf(w, "  %-26s invalid status details: %v\n", "", err)
+		return
+	}
+	for _, a := range st.Details {
+		b, err := protojson.MarshalOptions{Resolver: d.resolver()}.Marshal(a)
+		if err != nil {
+			fmt.Fprintf(w, "  %-26s detail %s\n", "", a.TypeUrl)
+			continue
+		}
+		fmt.Fprintf(w, "  %-26s detail %s\n", "", b)
+	}
+}
+
+// resolvers resolves types with the first resolver that knows them.
+type resolvers []*protoregistry.Types
+
+func (rs resolvers) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	for _, r := range rs {
+		if mt, err := r.FindMessageByName(name); err == nil {
+			return mt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (rs resolvers) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	for _, r := range rs {
+		if mt, err := r.FindMessageByURL(url); err == nil {
+			return mt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (rs resolvers) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, r := range rs {
+		if xt, err := r.FindExtensionByName(name); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (rs resolvers) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	for _, r := range rs {
+		if xt, err := r.FindExtensionByNumber(message, field); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
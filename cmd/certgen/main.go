@@ -0,0 +1,232 @@
+// certgen creates a local CA together with a server and a client certificate
+// signed by it, ready for the -tls_* flags of the ecommerce servers and
+// clients:
+//
+//	certgen -out certs
+//	server -tls_cert certs/server.pem -tls_key certs/server-key.pem -tls_client_ca certs/ca.pem
+//	client -tls_ca certs/ca.pem -tls_cert certs/client.pem -tls_key certs/client-key.pem
+//
+// An existing CA in the output directory is reused, so running certgen again
+// rotates the leaf certificates without invalidating the trust anchors
+// already handed out.
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/config"
+)
+
+var (
+	outDir     = flag.String("out", "certs", "directory the PEM files are written to")
+	hosts      = flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated DNS names and IP addresses of the server certificate")
+	serverCN   = flag.String("server_cn", "ecommerce-server", "common name of the server certificate")
+	clientCN   = flag.String("client_cn", "ecommerce-client", "common name of the client certificate")
+	validFor   = flag.Duration("valid_for", 365*24*time.Hour, "validity of the leaf certificates")
+	caValidFor = flag.Duration("ca_valid_for", 10*365*24*time.Hour, "validity of a newly created CA")
+	newCA      = flag.Bool("new_ca", false, "create a new CA even if one exists in -out")
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func main() {
+	config.NewLoader(flag.CommandLine).Parse()
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("failed to create output directory: %v", err)
+	}
+
+	ca, err := loadCA()
+	if err != nil {
+		log.Fatalf("failed to load CA: %v", err)
+	}
+	if ca == nil {
+		if ca, err = createCA(); err != nil {
+			log.Fatalf("failed to create CA: %v", err)
+		}
+		log.Printf("created CA %s", filepath.Join(*outDir, "ca.pem"))
+	} else {
+		log.Printf("reusing CA %s", filepath.Join(*outDir, "ca.pem"))
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: *serverCN, Organization: []string{"ecommerce"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range strings.Split(*hosts, ",") {
+		h = strings.TrimSpace(h)
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else if h != "" {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+	if err := issue(ca, server, "server"); err != nil {
+		log.Fatalf("failed to create server certificate: %v", err)
+	}
+
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: *clientCN, Organization: []string{"ecommerce"}},
+		DNSNames:    []string{*clientCN},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err := issue(ca, client, "client"); err != nil {
+		log.Fatalf("failed to create client certificate: %v", err)
+	}
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func newKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func loadCA() (*keyPair, error) {
+	if *newCA {
+		return nil, nil
+	}
+	certPEM, err := ioutil.ReadFile(filepath.Join(*outDir, "ca.pem"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(*outDir, "ca-key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, fmt.Errorf("bad PEM data in %s", *outDir)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key is not a signing key")
+	}
+	return &keyPair{cert: cert, key: signer}, nil
+}
+
+func createCA() (*keyPair, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ecommerce local CA", Organization: []string{"ecommerce"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(*caValidFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePair("ca", der, key); err != nil {
+		return nil, err
+	}
+	return &keyPair{cert: cert, key: key}, nil
+}
+
+func issue(ca *keyPair, tmpl *x509.Certificate, name string) error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	if tmpl.SerialNumber, err = serialNumber(); err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl.NotBefore = now.Add(-time.Hour)
+	tmpl.NotAfter = now.Add(*validFor)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return err
+	}
+	if err := writePair(name, der, key); err != nil {
+		return err
+	}
+	log.Printf("wrote %s certificate %s", name, filepath.Join(*outDir, name+".pem"))
+	return nil
+}
+
+// writePair writes <name>.pem and <name>-key.pem. The files are replaced
+// atomically so that a server reloading them never reads a half written
+// pair.
+func writePair(name string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(name+"-key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return writeAtomic(name+".pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeAtomic(name string, data []byte, perm os.FileMode) error {
+	path := filepath.Join(*outDir, name)
+	tmp, err := ioutil.TempFile(*outDir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
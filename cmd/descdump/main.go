@@ -0,0 +1,86 @@
+// descdump asks a running server for its services through the reflection
+// service and prints or exports the FileDescriptorSet describing them,
+// without needing the .proto files.
+//
+//	descdump -addr localhost:50051                  # services and methods
+//	descdump -addr localhost:50051 -format text     # the set as protobuf text
+//	descdump -addr localhost:50051 -o ecommerce.pb  # the set as a binary file
+//
+// The binary file works wherever protoc's --descriptor_set_out does, e.g.
+// with protoc --decode or as input to other tools.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	addr    = flag.String("addr", "localhost:50051", "address of the server")
+	format  = flag.String("format", "summary", "what to print: summary (services and methods) or text (the FileDescriptorSet as protobuf text)")
+	out     = flag.String("o", "", "write the FileDescriptorSet in binary form to this file instead of printing it")
+	timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for the server")
+)
+
+func main() {
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	config.NewLoader(flag.CommandLine).Parse()
+
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	authOpts, err := authFlags.DialOptions(tlsOpt)
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	conn, err := grpc.Dial(*addr, append([]grpc.DialOption{tlsOpt}, authOpts...)...)
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	src, err := descsource.FromReflection(ctx, conn)
+	if err != nil {
+		log.Fatalf("failed to fetch descriptors: %v", err)
+	}
+	set := src.Set
+
+	if *out != "" {
+		b, err := proto.Marshal(set)
+		if err != nil {
+			log.Fatalf("failed to encode descriptors: %v", err)
+		}
+		if err := ioutil.WriteFile(*out, b, 0644); err != nil {
+			log.Fatalf("failed to write descriptors: %v", err)
+		}
+		log.Printf("wrote %d files describing %d services to %s", len(set.File), len(src.Services), *out)
+		return
+	}
+	switch *format {
+	case "summary":
+		src.WriteSummary(os.Stdout)
+	case "text":
+		fmt.Print(prototext.MarshalOptions{Multiline: true, Indent: "  "}.Format(set))
+	default:
+		log.Fatalf("unknown format %q, want summary or text", *format)
+	}
+}
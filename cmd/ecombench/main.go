@@ -0,0 +1,173 @@
+// ecombench drives a workload against an OrderManagement server and reports
+// the latency percentiles, throughput, errors by code and a latency
+// histogram of every operation:
+//
+//	ecombench -duration 30s -concurrency 20 -mix add=1,get=4,search=1
+//	ecombench -qps 500 -mix get=1
+//	ecombench -mix "" -streams 10 -stream_rate 50
+//	ecombench -json run.json -baseline previous.json
+//
+// AddOrder, GetOrder and SearchOrders calls are picked by the weights of
+// -mix and made by -concurrency workers, as fast as the server answers or
+// at -qps calls per second in total. Each of the -streams ProcessOrders
+// streams sends -stream_rate order IDs per second; besides the streams,
+// ProcessOrders/shipment reports how long after its first order each
+// shipment arrived. -json writes the results for comparing runs; -baseline
+// prints how they changed from an earlier one.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"google.golang.org/grpc"
+)
+
+var (
+	duration    = flag.Duration("duration", 10*time.Second, "how long to run the workload")
+	concurrency = flag.Int("concurrency", 10, "workers making the AddOrder, GetOrder and SearchOrders calls")
+	qps         = flag.Float64("qps", 0, "calls per second of all workers together; as fast as the server answers when 0")
+	mixFlag     = flag.String("mix", "add=1,get=4,search=1", "weights of the calls made by the workers as op=weight, ops add, get and search")
+	streams     = flag.Int("streams", 0, "concurrent ProcessOrders streams")
+	streamRate  = flag.Float64("stream_rate", 10, "order IDs sent per second on each ProcessOrders stream")
+	streamMsgs  = flag.Int("stream_messages", 0, "order IDs sent before a ProcessOrders stream is closed and a new one opened; the streams last the whole run when 0")
+	timeout     = flag.Duration("timeout", 5*time.Second, "deadline of each call")
+	ids         = flag.String("ids", "102,103,104,105,106", "comma separated IDs of existing orders to get and process")
+	queries     = flag.String("queries", "Google,Apple,Mac", "comma separated items to search for")
+	jsonOut     = flag.String("json", "", "write the results as JSON to this file")
+	baseline    = flag.String("baseline", "", "JSON results of an earlier run to compare with")
+)
+
+// settings are the flags recorded with the results.
+var settings = []string{"duration", "concurrency", "qps", "mix", "streams", "stream_rate", "stream_messages", "timeout"}
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var clientCfg config.Client
+	clientCfg.Register(cfg, "localhost:50051")
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	cfg.Parse()
+
+	m, err := parseMix(*mixFlag)
+	if err != nil {
+		log.Fatalf("invalid -mix: %v", err)
+	}
+	if m.total == 0 && *streams == 0 {
+		log.Fatalf("nothing to run: -mix has no weights and -streams is 0")
+	}
+	if *concurrency < 1 || *streamRate <= 0 {
+		log.Fatalf("-concurrency and -stream_rate must be positive")
+	}
+	b := &bench{
+		rec:     newRecorder(),
+		timeout: *timeout,
+		ids:     splitList(*ids),
+		queries: splitList(*queries),
+		run:     time.Now().Unix(),
+	}
+	if len(b.ids) == 0 || len(b.queries) == 0 {
+		log.Fatalf("-ids and -queries must not be empty")
+	}
+	var base *Report
+	if *baseline != "" {
+		if base, err = readReport(*baseline); err != nil {
+			log.Fatalf("failed to read the baseline: %v", err)
+		}
+	}
+
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	authOpts, err := authFlags.DialOptions(tlsOpt)
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	conn, err := grpc.Dial(clientCfg.Address, append([]grpc.DialOption{tlsOpt}, authOpts...)...)
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	b.client = pb.NewOrderManagementClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	if m.total > 0 {
+		pace := pacer(ctx, *qps)
+		for i := 0; i < *concurrency; i++ {
+			wg.Add(1)
+			go func(rnd *rand.Rand) {
+				defer wg.Done()
+				for pace() {
+					b.call(m.pick(rnd), rnd)
+				}
+			}(rand.New(rand.NewSource(start.UnixNano() + int64(i))))
+		}
+	}
+	for i := 0; i < *streams; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			b.processOrders(ctx, rnd, *streamRate, *streamMsgs)
+		}(rand.New(rand.NewSource(start.UnixNano() - int64(i) - 1)))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	rep := &Report{
+		Address:  clientCfg.Address,
+		Start:    start,
+		Seconds:  elapsed.Seconds(),
+		Settings: make(map[string]string),
+		Ops:      b.rec.report(elapsed),
+	}
+	for _, name := range settings {
+		rep.Settings[name] = flag.Lookup(name).Value.String()
+	}
+	printReport(os.Stdout, rep)
+	if *qps > 0 {
+		var calls float64
+		for _, op := range rep.Ops {
+			if op.Name == "AddOrder" || op.Name == "GetOrder" || op.Name == "SearchOrders" {
+				calls += float64(op.Count)
+			}
+		}
+		if got := calls / elapsed.Seconds(); got < *qps*0.9 {
+			fmt.Printf("\nmade %.1f of the %g calls per second asked for; raise -concurrency\n", got, *qps)
+		}
+	}
+	if base != nil {
+		printComparison(os.Stdout, base, rep)
+	}
+	if *jsonOut != "" {
+		if err := writeReport(*jsonOut, rep); err != nil {
+			log.Fatalf("failed to write the results: %v", err)
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
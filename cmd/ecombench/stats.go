@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recorder collects the outcome of every call, by operation.
+type recorder struct {
+	mu  sync.Mutex
+	ops map[string]*opStats
+}
+
+type opStats struct {
+	latencies []time.Duration
+	errors    map[codes.Code]int
+	messages  int
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: make(map[string]*opStats)}
+}
+
+func (r *recorder) op(name string) *opStats {
+	s, ok := r.ops[name]
+	if !ok {
+		s = &opStats{errors: make(map[codes.Code]int)}
+		r.ops[name] = s
+	}
+	return s
+}
+
+// record adds a call that took d; the latencies of failed calls are left out.
+func (r *recorder) record(name string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.op(name)
+	if err != nil {
+		s.errors[status.Code(err)]++
+		return
+	}
+	s.latencies = append(s.latencies, d)
+}
+
+// addMessages counts stream messages of the operation.
+func (r *recorder) addMessages(name string, n int) {
+	r.mu.Lock()
+	r.op(name).messages += n
+	r.mu.Unlock()
+}
+
+// Report is the result of a run, as written by -json.
+type Report struct {
+	Address  string            `json:"address"`
+	Start    time.Time         `json:"start"`
+	Seconds  float64           `json:"duration_s"`
+	Settings map[string]string `json:"settings"`
+	Ops      []OpReport        `json:"ops"`
+}
+
+// OpReport sums up the calls of one operation.
+type OpReport struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Errors counts the failed calls by status code.
+	Errors map[string]int `json:"errors,omitempty"`
+	// Messages counts the messages streamed by the calls.
+	Messages int `json:"messages,omitempty"`
+	// Throughput is the successful calls per second.
+	Throughput float64  `json:"throughput"`
+	Latency    Latency  `json:"latency_ms"`
+	Histogram  []Bucket `json:"histogram,omitempty"`
+}
+
+// Latency holds the latency distribution of the successful calls, in
+// milliseconds.
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99.9"`
+	Max  float64 `json:"max"`
+}
+
+// Bucket counts the calls that took longer than the previous bucket's
+// bound and at most UpperMs.
+type Bucket struct {
+	UpperMs float64 `json:"le_ms"`
+	Count   int     `json:"count"`
+}
+
+func (r *recorder) report(elapsed time.Duration) []OpReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for name := range r.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var reports []OpReport
+	for _, name := range names {
+		s := r.ops[name]
+		op := OpReport{Name: name, Count: len(s.latencies), Messages: s.messages}
+		for code, n := range s.errors {
+			if op.Errors == nil {
+				op.Errors = make(map[string]int)
+			}
+			op.Errors[code.String()] = n
+			op.Count += n
+		}
+		op.Throughput = float64(len(s.latencies)) / elapsed.Seconds()
+		if len(s.latencies) > 0 {
+			sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+			op.Latency = latency(s.latencies)
+			op.Histogram = histogram(s.latencies)
+		}
+		reports = append(reports, op)
+	}
+	return reports
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// latency sums up the sorted latencies.
+func latency(sorted []time.Duration) Latency {
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	pct := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(sorted[i])
+	}
+	return Latency{
+		Min:  ms(sorted[0]),
+		Mean: ms(sum) / float64(len(sorted)),
+		P50:  pct(0.5),
+		P90:  pct(0.9),
+		P99:  pct(0.99),
+		P999: pct(0.999),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+// histogram counts the sorted latencies in buckets bounded by 1, 2 and 5
+// times the powers of ten, from the first bucket in use to the last.
+func histogram(sorted []time.Duration) []Bucket {
+	var buckets []Bucket
+	bound := 0.01
+	for step := 0; len(sorted) > 0; step++ {
+		upper := bound * []float64{1, 2, 5}[step%3]
+		if step%3 == 2 {
+			bound *= 10
+		}
+		n := sort.Search(len(sorted), func(i int) bool { return ms(sorted[i]) > upper })
+		if n == 0 && len(buckets) == 0 {
+			continue
+		}
+		buckets = append(buckets, Bucket{UpperMs: upper, Count: n})
+		sorted = sorted[n:]
+	}
+	return buckets
+}
+
+func printReport(w io.Writer, rep *Report) {
+	fmt.Fprintf(w, "%s against %s for %.1fs\n", settingsLine(rep.Settings), rep.Address, rep.Seconds)
+	for _, op := range rep.Ops {
+		fmt.Fprintf(w, "\n%s: %d calls, %.1f/s", op.Name, op.Count, op.Throughput)
+		if op.Messages > 0 {
+			fmt.Fprintf(w, ", %d messages", op.Messages)
+		}
+		fmt.Fprintln(w)
+		if len(op.Errors) > 0 {
+			var codes []string
+			for code := range op.Errors {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+			fmt.Fprintf(w, "  errors:")
+			for _, code := range codes {
+				fmt.Fprintf(w, " %s %d", code, op.Errors[code])
+			}
+			fmt.Fprintln(w)
+		}
+		if len(op.Histogram) == 0 {
+			continue
+		}
+		l := op.Latency
+		fmt.Fprintf(w, "  latency ms: min %.3f  mean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  p99.9 %.3f  max %.3f\n",
+			l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+		most := 0
+		for _, b := range op.Histogram {
+			if b.Count > most {
+				most = b.Count
+			}
+		}
+		for _, b := range op.Histogram {
+			fmt.Fprintf(w, "  <= %8g ms %8d %s\n", b.UpperMs, b.Count, strings.Repeat("#", (b.Count*40+most-1)/most))
+		}
+	}
+}
+
+func settingsLine(settings map[string]string) string {
+	var keys []string
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + settings[k]
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeReport(path string, rep *Report) error {
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func readReport(path string) (*Report, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rep := new(Report)
+	if err := json.Unmarshal(b, rep); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rep, nil
+}
+
+// printComparison prints how the throughput and latencies of the
+// operations in both reports changed from base to rep.
+func printComparison(w io.Writer, base, rep *Report) {
+	fmt.Fprintf(w, "\ncompared with the run of %s:\n", base.Start.Format(time.RFC3339))
+	old := make(map[string]OpReport)
+	for _, op := range base.Ops {
+		old[op.Name] = op
+	}
+	for _, op := range rep.Ops {
+		b, ok := old[op.Name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "  %-24s throughput %s  p50 %s  p99 %s  errors %d -> %d\n", op.Name,
+			change(b.Throughput, op.Throughput), change(b.Latency.P50, op.Latency.P50),
+			change(b.Latency.P99, op.Latency.P99), errorCount(b), errorCount(op))
+	}
+}
+
+func change(from, to float64) string {
+	if from == 0 {
+		return fmt.Sprintf("%.3g -> %.3g", from, to)
+	}
+	return fmt.Sprintf("%.3g -> %.3g (%+.1f%%)", from, to, (to-from)/from*100)
+}
+
+func errorCount(op OpReport) int {
+	n := 0
+	for _, c := range op.Errors {
+		n += c
+	}
+	return n
+}
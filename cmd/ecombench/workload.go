@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// The operations of the mix.
+const (
+	opAdd    = "add"
+	opGet    = "get"
+	opSearch = "search"
+)
+
+// mix picks the unary and search operations by weight.
+type mix struct {
+	ops     []string
+	weights []int
+	total   int
+}
+
+// parseMix parses op=weight pairs such as "add=1,get=4,search=1".
+func parseMix(s string) (*mix, error) {
+	m := new(mix)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("want op=weight, got %q", pair)
+		}
+		switch kv[0] {
+		case opAdd, opGet, opSearch:
+		default:
+			return nil, fmt.Errorf("unknown operation %q, want add, get or search", kv[0])
+		}
+		w, err := strconv.Atoi(kv[1])
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q of %s", kv[1], kv[0])
+		}
+		if w > 0 {
+			m.ops = append(m.ops, kv[0])
+			m.weights = append(m.weights, w)
+			m.total += w
+		}
+	}
+	return m, nil
+}
+
+func (m *mix) pick(rnd *rand.Rand) string {
+	n := rnd.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.ops[i]
+		}
+		n -= w
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+// bench runs the workloads against a server.
+type bench struct {
+	client  pb.OrderManagementClient
+	rec     *recorder
+	timeout time.Duration
+	ids     []string
+	queries []string
+	// run tells the orders added apart from those of other runs.
+	run   int64
+	added int64
+}
+
+// call runs op once, with its own deadline so that calls in flight when the
+// run ends still count.
+func (b *bench) call(op string, rnd *rand.Rand) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	start := time.Now()
+	switch op {
+	case opAdd:
+		n := atomic.AddInt64(&b.added, 1)
+		order := &pb.Order{
+			Id:          fmt.Sprintf("bench-%d-%d", b.run, n),
+			Items:       []string{"Bench Item"},
+			Description: "added by ecombench",
+			Price:       float32(rnd.Intn(1000)),
+			Destination: "Bench City",
+		}
+		_, err := b.client.AddOrder(ctx, order)
+		b.rec.record("AddOrder", time.Since(start), err)
+	case opGet:
+		_, err := b.client.GetOrder(ctx, &wrappers.StringValue{Value: b.ids[rnd.Intn(len(b.ids))]})
+		b.rec.record("GetOrder", time.Since(start), err)
+	case opSearch:
+		n, err := b.search(ctx, b.queries[rnd.Intn(len(b.queries))])
+		b.rec.record("SearchOrders", time.Since(start), err)
+		b.rec.addMessages("SearchOrders", n)
+	}
+}
+
+// search reads all the orders found for query.
+func (b *bench) search(ctx context.Context, query string) (int, error) {
+	stream, err := b.client.SearchOrders(ctx, &wrappers.StringValue{Value: query})
+	if err != nil {
+		return 0, err
+	}
+	for n := 0; ; n++ {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+}
+
+// pacer returns a function that waits until the next call may start, at
+// qps calls per second across all callers or at once when qps is 0. It
+// returns false when ctx is done.
+func pacer(ctx context.Context, qps float64) func() bool {
+	if qps <= 0 {
+		return func() bool { return ctx.Err() == nil }
+	}
+	// Ticks are dropped while every caller is busy, so a server that
+	// can't keep up gets fewer calls, not a growing backlog.
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+	go func() {
+		<-ctx.Done()
+		ticker.Stop()
+	}()
+	return func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return ctx.Err() == nil
+		}
+	}
+}
+
+// processOrders keeps a ProcessOrders stream open until ctx is done,
+// sending rate order IDs per second and opening a new stream after
+// perStream IDs, if set.
+func (b *bench) processOrders(ctx context.Context, rnd *rand.Rand, rate float64, perStream int) {
+	for ctx.Err() == nil {
+		if err := b.processStream(ctx, rnd, rate, perStream); err != nil {
+			// Don't spin while the server is down.
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// processStream runs one ProcessOrders stream. Besides the stream it
+// records, for every shipment, how long after its first order was sent the
+// shipment arrived.
+func (b *bench) processStream(ctx context.Context, rnd *rand.Rand, rate float64, perStream int) error {
+	start := time.Now()
+	// The stream is closed, not cancelled, when the run ends; it is
+	// cancelled only if the last shipments take longer than the timeout.
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := b.client.ProcessOrders(streamCtx)
+	if err != nil {
+		b.rec.record("ProcessOrders", time.Since(start), err)
+		return err
+	}
+
+	var mu sync.Mutex
+	sent := make(map[string][]time.Time)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			shipment, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			now := time.Now()
+			first := now
+			mu.Lock()
+			for _, order := range shipment.GetOrderList() {
+				if times := sent[order.GetId()]; len(times) > 0 {
+					if times[0].Before(first) {
+						first = times[0]
+					}
+					sent[order.GetId()] = times[1:]
+				}
+			}
+			mu.Unlock()
+			b.rec.record("ProcessOrders/shipment", now.Sub(first), nil)
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	n := 0
+send:
+	for perStream == 0 || n < perStream {
+		select {
+		case <-ctx.Done():
+			break send
+		case <-ticker.C:
+		}
+		id := b.ids[rnd.Intn(len(b.ids))]
+		mu.Lock()
+		sent[id] = append(sent[id], time.Now())
+		mu.Unlock()
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			// The stream has ended; Recv says why.
+			break
+		}
+		n++
+	}
+	b.rec.addMessages("ProcessOrders", n)
+	stream.CloseSend()
+	select {
+	case err = <-done:
+	case <-time.After(b.timeout):
+		cancel()
+		err = <-done
+	}
+	b.rec.record("ProcessOrders", time.Since(start), err)
+	return err
+}
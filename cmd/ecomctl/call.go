@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// commands are the commands that are not part of a group.
+var commands = []command{
+	{"call", "<service/method> [-d <json> | -d @<file> | -d @-]", call},
+	{"list", "[service]", list},
+	{"describe", "[symbol]", describe},
+}
+
+// source returns the descriptors of the -protoset files or, without them,
+// those the server reports through reflection.
+func (e *env) source(ctx context.Context) (*descsource.Source, error) {
+	if *protosets != "" {
+		return descsource.FromFiles(strings.Split(*protosets, ",")...)
+	}
+	src, err := descsource.FromReflection(ctx, e.conn)
+	if err != nil {
+		return nil, fmt.Errorf("reflection: %v", err)
+	}
+	return src, nil
+}
+
+// call calls any method, building the requests from JSON with the
+// descriptors of the method. Several requests, for client streams, follow
+// one another, usually one per line.
+func call(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("call")
+	data := fs.String("d", "{}", "request as JSON; @file reads the requests from a file, @- from stdin")
+	// The method comes first; the flags follow it.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("call: want a method, such as ecommerce.ProductInfo/getProduct")
+	}
+	method := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 0 {
+		return fmt.Errorf("call: unexpected arguments %q", fs.Args())
+	}
+
+	src, err := e.source(ctx)
+	if err != nil {
+		return err
+	}
+	md, err := src.Method(method)
+	if err != nil {
+		return err
+	}
+	setResolver(e.out, src.Types)
+	var in io.Reader
+	switch {
+	case *data == "@-":
+		in = e.in
+	case strings.HasPrefix(*data, "@"):
+		f, err := os.Open((*data)[1:])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	default:
+		in = strings.NewReader(*data)
+	}
+	dec := json.NewDecoder(in)
+	unmarshal := protojson.UnmarshalOptions{Resolver: src.Types}
+	next := func() (proto.Message, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("request: %v", err)
+		}
+		req := dynamicpb.NewMessage(md.Input())
+		if err := unmarshal.Unmarshal(raw, req); err != nil {
+			return nil, fmt.Errorf("request: %v", err)
+		}
+		return req, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+	stream, err := e.conn.NewStream(ctx, desc, descsource.MethodPath(md))
+	if err != nil {
+		return err
+	}
+	defer e.streamDone(stream)
+
+	// The responses are printed while the requests are still being sent.
+	sendErr := make(chan error, 1)
+	go func() {
+		err := sendAll(stream, md, next)
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		sendErr <- err
+		if err != nil {
+			// Abandon the call; the bad request is what gets reported.
+			cancel()
+		}
+	}()
+
+	for {
+		res := dynamicpb.NewMessage(md.Output())
+		err := stream.RecvMsg(res)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e.out.Flush()
+			select {
+			case sErr := <-sendErr:
+				if sErr != nil {
+					return sErr
+				}
+			default:
+			}
+			return err
+		}
+		if err := e.out.Print(res); err != nil {
+			return err
+		}
+		if !desc.ServerStreams {
+			break
+		}
+	}
+	if err := e.out.Flush(); err != nil {
+		return err
+	}
+	return <-sendErr
+}
+
+// sendAll sends the requests returned by next until it returns io.EOF. A
+// method without a client stream takes exactly one request.
+func sendAll(stream grpc.ClientStream, md protoreflect.MethodDescriptor, next func() (proto.Message, error)) error {
+	for n := 0; ; n++ {
+		req, err := next()
+		if err == io.EOF {
+			if n == 0 && !md.IsStreamingClient() {
+				return fmt.Errorf("call: %s takes one request, got none", md.FullName())
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 && !md.IsStreamingClient() {
+			return fmt.Errorf("call: %s takes one request, got more", md.FullName())
+		}
+		if err := stream.SendMsg(req); err != nil {
+			// Sending stopped because the call ended; RecvMsg reports why.
+			return nil
+		}
+	}
+}
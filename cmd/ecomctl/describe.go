@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// list prints the services or, given one, its methods.
+func list(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("list")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("list: want at most one service")
+	}
+	src, err := e.source(ctx)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		for _, s := range src.Services {
+			fmt.Fprintln(e.stdout, s)
+		}
+		return nil
+	}
+	sd, err := src.Service(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		fmt.Fprintln(e.stdout, methods.Get(i).FullName())
+	}
+	return nil
+}
+
+// describe prints the definition of a service, method, message, enum or
+// field as it would read in a .proto file, or of every service without a
+// symbol.
+func describe(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("describe")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("describe: want at most one symbol")
+	}
+	src, err := e.source(ctx)
+	if err != nil {
+		return err
+	}
+	names := src.Services
+	if fs.NArg() == 1 {
+		// Methods may be named as they are called, service/method.
+		names = []string{strings.Replace(strings.TrimPrefix(fs.Arg(0), "/"), "/", ".", 1)}
+	}
+	for i, name := range names {
+		d, err := src.Files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return fmt.Errorf("describe %s: %v", name, err)
+		}
+		if i > 0 {
+			fmt.Fprintln(e.stdout)
+		}
+		fmt.Fprintf(e.stdout, "// %s: %s in %s\n", d.FullName(), kindOf(d), d.ParentFile().Path())
+		writeDescriptor(e.stdout, d, "")
+	}
+	return nil
+}
+
+func kindOf(d protoreflect.Descriptor) string {
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		return "service"
+	case protoreflect.MethodDescriptor:
+		return "method"
+	case protoreflect.MessageDescriptor:
+		return "message"
+	case protoreflect.EnumDescriptor:
+		return "enum"
+	case protoreflect.EnumValueDescriptor:
+		return "enum value"
+	case protoreflect.FieldDescriptor:
+		if d.IsExtension() {
+			return "extension"
+		}
+		return "field"
+	case protoreflect.OneofDescriptor:
+		return "oneof"
+	}
+	return "symbol"
+}
+
+func writeDescriptor(w io.Writer, d protoreflect.Descriptor, indent string) {
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(w, "%sservice %s {\n", indent, d.Name())
+		methods := d.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			fmt.Fprintf(w, "%s  %s;\n", indent, descsource.MethodSignature(methods.Get(i)))
+		}
+		fmt.Fprintf(w, "%s}\n", indent)
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(w, "%s%s;\n", indent, descsource.MethodSignature(d))
+	case protoreflect.MessageDescriptor:
+		writeMessage(w, d, indent)
+	case protoreflect.EnumDescriptor:
+		fmt.Fprintf(w, "%senum %s {\n", indent, d.Name())
+		values := d.Values()
+		for i := 0; i < values.Len(); i++ {
+			fmt.Fprintf(w, "%s  %s = %d;\n", indent, values.Get(i).Name(), values.Get(i).Number())
+		}
+		fmt.Fprintf(w, "%s}\n", indent)
+	case protoreflect.EnumValueDescriptor:
+		fmt.Fprintf(w, "%s%s = %d;\n", indent, d.Name(), d.Number())
+	case protoreflect.FieldDescriptor:
+		fmt.Fprintf(w, "%s%s;\n", indent, fieldLine(d))
+	case protoreflect.OneofDescriptor:
+		writeOneof(w, d, indent)
+	}
+}
+
+func writeMessage(w io.Writer, md protoreflect.MessageDescriptor, indent string) {
+	fmt.Fprintf(w, "%smessage %s {\n", indent, md.Name())
+	inner := indent + "  "
+	fields := md.Fields()
+	written := make(map[protoreflect.OneofDescriptor]bool)
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// Proto3 optional fields sit in a synthetic oneof of their own.
+		if od := fd.ContainingOneof(); od != nil && !fd.HasOptionalKeyword() {
+			if !written[od] {
+				written[od] = true
+				writeOneof(w, od, inner)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s%s;\n", inner, fieldLine(fd))
+	}
+	msgs := md.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		if !msgs.Get(i).IsMapEntry() {
+			writeMessage(w, msgs.Get(i), inner)
+		}
+	}
+	enums := md.Enums()
+	for i := 0; i < enums.Len(); i++ {
+		writeDescriptor(w, enums.Get(i), inner)
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+func writeOneof(w io.Writer, od protoreflect.OneofDescriptor, indent string) {
+	fmt.Fprintf(w, "%soneof %s {\n", indent, od.Name())
+	fields := od.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fmt.Fprintf(w, "%s  %s;\n", indent, fieldLine(fields.Get(i)))
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+func fieldLine(fd protoreflect.FieldDescriptor) string {
+	var label string
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s> %s = %d", typeName(fd.MapKey()), typeName(fd.MapValue()), fd.Name(), fd.Number())
+	case fd.IsList():
+		label = "repeated "
+	case fd.Cardinality() == protoreflect.Required:
+		label = "required "
+	case fd.HasOptionalKeyword() || fd.Syntax() == protoreflect.Proto2 && fd.ContainingOneof() == nil:
+		label = "optional "
+	}
+	return fmt.Sprintf("%s%s %s = %d", label, typeName(fd), fd.Name(), fd.Number())
+}
+
+func typeName(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "." + string(fd.Message().FullName())
+	case protoreflect.EnumKind:
+		return "." + string(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
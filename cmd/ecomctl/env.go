@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// env is what the commands work with.
+type env struct {
+	conn   *grpc.ClientConn
+	out    printer
+	in     io.Reader
+	stdout io.Writer
+
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+// callOptions records the response metadata of a unary call.
+func (e *env) callOptions() []grpc.CallOption {
+	return []grpc.CallOption{grpc.Header(&e.header), grpc.Trailer(&e.trailer)}
+}
+
+// streamDone records the response metadata of a finished stream.
+func (e *env) streamDone(s grpc.ClientStream) {
+	e.header, _ = s.Header()
+	e.trailer = s.Trailer()
+}
+
+func (e *env) printMetadata() {
+	if !*verbose {
+		return
+	}
+	for _, part := range []struct {
+		name string
+		md   metadata.MD
+	}{{"header", e.header}, {"trailer", e.trailer}} {
+		var keys []string
+		for k := range part.md {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range part.md[k] {
+				fmt.Fprintf(os.Stderr, "< %s %s: %s\n", part.name, k, v)
+			}
+		}
+	}
+}
+
+// newFlagSet returns the flag set of a command.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("ecomctl "+name, flag.ExitOnError)
+}
+
+// readJSON reads the message m from the JSON file path.
+func readJSON(path string, m proto.Message) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// readNDJSON calls each for the messages of r, one JSON message per line;
+// empty lines are skipped.
+func readNDJSON(r io.Reader, newMsg func() proto.Message, each func(proto.Message) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		m := newMsg()
+		if err := protojson.Unmarshal(b, m); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := each(m); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// input returns the file path, or stdin for "" and "-".
+func (e *env) input(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return ioutil.NopCloser(e.in), nil
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,226 @@
+// ecomctl calls every RPC of the ProductInfo and OrderManagement services
+// from the command line:
+//
+//	ecomctl [flags] product add -name "Pixel 5" -price 699
+//	ecomctl [flags] product get <id>
+//	ecomctl [flags] product list [-name <part of the name>]
+//	ecomctl [flags] order add -id 201 -items "Pixel 5,Case" -destination Berlin -price 720
+//	ecomctl [flags] order get <id>
+//	ecomctl [flags] order search <item>
+//	ecomctl [flags] order update < orders.ndjson
+//	ecomctl [flags] order process -ids 102,103 | < ids.ndjson
+//
+// and, like grpcurl, any method of any service, typed or not:
+//
+//	ecomctl [flags] list [service]
+//	ecomctl [flags] describe [symbol]
+//	ecomctl [flags] call ecommerce.ProductInfo/getProduct -d '{"value": "<id>"}'
+//	ecomctl [flags] call ecommerce.OrderManagement/processOrders -d @- < ids.ndjson
+//
+// call builds the requests from JSON with the descriptors the server
+// reports through reflection or, with -protoset, those of descriptor set
+// files such as descdump -o writes, so new RPCs need no new code here.
+//
+// The add commands take the message from flags or, with -f, from a JSON
+// file. The streaming commands read newline delimited JSON, one message per
+// line, from stdin or -f. Responses are printed as a table, as JSON (one
+// message per line) or as proto text, chosen by -o. Metadata, the deadline,
+// TLS and auth are set by flags in front of the command; -v prints the
+// response metadata and -binlog_file writes a binary log of the calls.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/binlog"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	// The standard error details are printed as JSON.
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+var (
+	output    = flag.String("o", "table", "output format: table, json or text")
+	timeout   = flag.Duration("timeout", 10*time.Second, "deadline of the call")
+	verbose   = flag.Bool("v", false, "print the response headers and trailers to stderr")
+	protosets = flag.String("protoset", "", "comma separated FileDescriptorSet files describing the services for call, list and describe; reflection is used when empty")
+	md        = metadataFlag{}
+)
+
+func init() {
+	flag.Var(md, "H", "metadata sent with the calls as key=value, repeatable or comma separated")
+	flag.Usage = usage
+}
+
+// command is a command or a subcommand of a group.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+var groups = map[string][]command{
+	"product": productCommands,
+	"order":   orderCommands,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ecomctl [flags] [<group>] <command> [command flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", c.name, c.usage)
+	}
+	var names []string
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		for _, c := range groups[g] {
+			fmt.Fprintf(os.Stderr, "  %s %s %s\n", g, c.name, c.usage)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var clientCfg config.Client
+	clientCfg.Register(cfg, "localhost:50051")
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var binlogFlags binlog.Flags
+	binlogFlags.Register(flag.CommandLine)
+	cfg.Parse()
+
+	cmd, args := findCommand(flag.Args())
+	if cmd == nil {
+		if flag.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "ecomctl: unknown command %q\n\n", strings.Join(flag.Args(), " "))
+		}
+		usage()
+		os.Exit(2)
+	}
+	p, err := newPrinter(*output, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ecomctl: %v\n", err)
+		os.Exit(2)
+	}
+
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		fatal(fmt.Errorf("failed to configure TLS: %v", err))
+	}
+	authOpts, err := authFlags.DialOptions(tlsOpt)
+	if err != nil {
+		fatal(fmt.Errorf("failed to configure auth: %v", err))
+	}
+	binlogger, err := binlogFlags.Logger()
+	if err != nil {
+		fatal(fmt.Errorf("failed to configure binary logging: %v", err))
+	}
+	defer binlogger.Close()
+	opts := append([]grpc.DialOption{tlsOpt}, authOpts...)
+	conn, err := grpc.Dial(clientCfg.Address, append(opts, binlogger.DialOptions()...)...)
+	if err != nil {
+		fatal(fmt.Errorf("did not connect: %v", err))
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if len(md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.MD(md))
+	}
+	e := &env{conn: conn, out: p, in: os.Stdin, stdout: os.Stdout}
+	err = cmd.run(ctx, e, args)
+	e.printMetadata()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// findCommand returns the command args name and the arguments that follow
+// it.
+func findCommand(args []string) (*command, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c := c
+			return &c, args[1:]
+		}
+	}
+	if len(args) < 2 {
+		return nil, nil
+	}
+	for _, c := range groups[args[0]] {
+		if c.name == args[1] {
+			c := c
+			return &c, args[2:]
+		}
+	}
+	return nil, nil
+}
+
+// fatal prints err, with the details of a status, and exits.
+func fatal(err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ecomctl: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "ecomctl: %s: %s\n", st.Code(), st.Message())
+	for _, d := range st.Proto().GetDetails() {
+		b, err := protojson.Marshal(d)
+		if err != nil {
+			b = []byte(d.GetTypeUrl())
+		}
+		fmt.Fprintf(os.Stderr, "  detail: %s\n", b)
+	}
+	os.Exit(1)
+}
+
+// metadataFlag collects the -H flags.
+type metadataFlag metadata.MD
+
+func (m metadataFlag) String() string {
+	var pairs []string
+	for k, vs := range m {
+		for _, v := range vs {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("want key=value, got %q", pair)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		m[key] = append(m[key], kv[1])
+	}
+	return nil
+}
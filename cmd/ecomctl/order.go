@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/proto"
+)
+
+var orderCommands = []command{
+	{"add", "[-f order.json | -id <id> -items <item,...> -description <text> -price <price> -destination <place>]", orderAdd},
+	{"get", "<id>", orderGet},
+	{"search", "<item>", orderSearch},
+	{"update", "[-f orders.ndjson], orders read from stdin by default", orderUpdate},
+	{"process", "[-ids <id,...> | -f ids.ndjson], IDs read from stdin by default", orderProcess},
+}
+
+func orderAdd(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("order add")
+	file := fs.String("f", "", "JSON file with the order")
+	id := fs.String("id", "", "ID of the order")
+	items := fs.String("items", "", "comma separated items of the order")
+	description := fs.String("description", "", "description of the order")
+	price := fs.Float64("price", 0, "price of the order")
+	destination := fs.String("destination", "", "where the order is shipped to")
+	fs.Parse(args)
+
+	order := &pb.Order{Id: *id, Description: *description, Price: float32(*price), Destination: *destination}
+	if *items != "" {
+		for _, item := range strings.Split(*items, ",") {
+			order.Items = append(order.Items, strings.TrimSpace(item))
+		}
+	}
+	if *file != "" {
+		order = &pb.Order{}
+		if err := readJSON(*file, order); err != nil {
+			return err
+		}
+	}
+	if order.Id == "" {
+		return fmt.Errorf("order add: an ID is required")
+	}
+	res, err := pb.NewOrderManagementClient(e.conn).AddOrder(ctx, order, e.callOptions()...)
+	if err != nil {
+		return err
+	}
+	e.out.Print(res)
+	return e.out.Flush()
+}
+
+func orderGet(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("order get")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("order get: want one order ID")
+	}
+	order, err := pb.NewOrderManagementClient(e.conn).GetOrder(ctx, &wrappers.StringValue{Value: fs.Arg(0)}, e.callOptions()...)
+	if err != nil {
+		return err
+	}
+	e.out.Print(order)
+	return e.out.Flush()
+}
+
+func orderSearch(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("order search")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("order search: want one item to search for")
+	}
+	stream, err := pb.NewOrderManagementClient(e.conn).SearchOrders(ctx, &wrappers.StringValue{Value: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	defer e.streamDone(stream)
+	for {
+		order, err := stream.Recv()
+		if err == io.EOF {
+			return e.out.Flush()
+		}
+		if err != nil {
+			e.out.Flush()
+			return err
+		}
+		if err := e.out.Print(order); err != nil {
+			return err
+		}
+	}
+}
+
+func orderUpdate(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("order update")
+	file := fs.String("f", "", "file with one JSON order per line; stdin when empty or -")
+	fs.Parse(args)
+	in, err := e.input(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stream, err := pb.NewOrderManagementClient(e.conn).UpdateOrders(ctx)
+	if err != nil {
+		return err
+	}
+	defer e.streamDone(stream)
+	err = readNDJSON(in, func() proto.Message { return &pb.Order{} }, func(m proto.Message) error {
+		if err := stream.Send(m.(*pb.Order)); err != nil {
+			// The status comes with CloseAndRecv.
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	e.out.Print(res)
+	return e.out.Flush()
+}
+
+func orderProcess(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("order process")
+	ids := fs.String("ids", "", "comma separated order IDs; read from -f or stdin, one JSON string per line, when empty")
+	file := fs.String("f", "", "file with one JSON order ID per line; stdin when empty or -")
+	fs.Parse(args)
+
+	stream, err := pb.NewOrderManagementClient(e.conn).ProcessOrders(ctx)
+	if err != nil {
+		return err
+	}
+	defer e.streamDone(stream)
+
+	// The shipments are printed while the IDs are still being sent.
+	sendErr := make(chan error, 1)
+	go func() {
+		send := func(m proto.Message) error {
+			if err := stream.Send(m.(*wrappers.StringValue)); err != nil {
+				return io.EOF
+			}
+			return nil
+		}
+		var err error
+		if *ids != "" {
+			for _, id := range strings.Split(*ids, ",") {
+				if err = send(&wrappers.StringValue{Value: strings.TrimSpace(id)}); err != nil {
+					break
+				}
+			}
+		} else {
+			var in io.ReadCloser
+			if in, err = e.input(*file); err == nil {
+				err = readNDJSON(in, func() proto.Message { return &wrappers.StringValue{} }, send)
+				in.Close()
+			}
+		}
+		if err == io.EOF {
+			// Sending stopped because the call ended; Recv reports why.
+			err = nil
+		}
+		stream.CloseSend()
+		sendErr <- err
+	}()
+
+	for {
+		shipment, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			e.out.Flush()
+			return err
+		}
+		if err := e.out.Print(shipment); err != nil {
+			return err
+		}
+	}
+	if err := e.out.Flush(); err != nil {
+		return err
+	}
+	return <-sendErr
+}
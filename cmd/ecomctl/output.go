@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// printer writes the response messages.
+type printer interface {
+	Print(m proto.Message) error
+	// Flush writes what is buffered; it is called once all messages are
+	// printed.
+	Flush() error
+}
+
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "table":
+		return &tablePrinter{w: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)}, nil
+	case "json":
+		return &jsonPrinter{w: w}, nil
+	case "text":
+		return &textPrinter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, want table, json or text", format)
+}
+
+// resolver finds the types of Any fields and extensions.
+type resolver interface {
+	protoregistry.ExtensionTypeResolver
+	protoregistry.MessageTypeResolver
+}
+
+// setResolver makes p find types in r rather than in the types compiled in,
+// for printing messages built from descriptors.
+func setResolver(p printer, r resolver) {
+	switch p := p.(type) {
+	case *jsonPrinter:
+		p.resolver = r
+	case *textPrinter:
+		p.resolver = r
+	}
+}
+
+// jsonPrinter prints one JSON message per line.
+type jsonPrinter struct {
+	w        io.Writer
+	resolver resolver
+}
+
+func (p *jsonPrinter) Print(m proto.Message) error {
+	b, err := protojson.MarshalOptions{UseProtoNames: true, Resolver: p.resolver}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", b)
+	return err
+}
+
+func (p *jsonPrinter) Flush() error { return nil }
+
+// textPrinter prints proto text, the messages separated by empty lines.
+type textPrinter struct {
+	w        io.Writer
+	n        int
+	resolver resolver
+}
+
+func (p *textPrinter) Print(m proto.Message) error {
+	b, err := prototext.MarshalOptions{Multiline: true, Resolver: p.resolver}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if p.n > 0 {
+		fmt.Fprintln(p.w)
+	}
+	p.n++
+	_, err = p.w.Write(b)
+	return err
+}
+
+func (p *textPrinter) Flush() error { return nil }
+
+// tablePrinter prints a row per message and a column per field. Lists are
+// joined with commas; nested messages are shown by their id field, if any.
+type tablePrinter struct {
+	w      *tabwriter.Writer
+	header bool
+}
+
+func (p *tablePrinter) Print(m proto.Message) error {
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	if !p.header {
+		p.header = true
+		names := make([]string, fields.Len())
+		for i := range names {
+			names[i] = strings.ToUpper(string(fields.Get(i).Name()))
+		}
+		fmt.Fprintln(p.w, strings.Join(names, "\t"))
+	}
+	cells := make([]string, fields.Len())
+	for i := range cells {
+		cells[i] = cell(fields.Get(i), msg.Get(fields.Get(i)))
+	}
+	_, err := fmt.Fprintln(p.w, strings.Join(cells, "\t"))
+	return err
+}
+
+func (p *tablePrinter) Flush() error { return p.w.Flush() }
+
+func cell(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.IsList() {
+		l := v.List()
+		parts := make([]string, l.Len())
+		for i := range parts {
+			parts[i] = scalar(fd, l.Get(i))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return scalar(fd, v)
+}
+
+func scalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Kind() != protoreflect.MessageKind {
+		return fmt.Sprint(v.Interface())
+	}
+	m := v.Message()
+	if id := m.Descriptor().Fields().ByName("id"); id != nil {
+		return fmt.Sprint(m.Get(id).Interface())
+	}
+	b, _ := prototext.Marshal(m.Interface())
+	return string(b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	pb "github.com/eadydb/grpc-samples/ch02/proto"
+)
+
+var productCommands = []command{
+	{"add", "[-f product.json | -name <name> -description <text> -price <price>]", productAdd},
+	{"get", "<id>", productGet},
+	{"list", "[-name <part of the name>]", productList},
+}
+
+func productAdd(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("product add")
+	file := fs.String("f", "", "JSON file with the product")
+	name := fs.String("name", "", "name of the product")
+	description := fs.String("description", "", "description of the product")
+	price := fs.Float64("price", 0, "price of the product")
+	fs.Parse(args)
+
+	product := &pb.Product{Name: *name, Description: *description, Price: float32(*price)}
+	if *file != "" {
+		product = &pb.Product{}
+		if err := readJSON(*file, product); err != nil {
+			return err
+		}
+	}
+	if product.Name == "" {
+		return fmt.Errorf("product add: a name is required")
+	}
+	id, err := pb.NewProductInfoClient(e.conn).AddProduct(ctx, product, e.callOptions()...)
+	if err != nil {
+		return err
+	}
+	e.out.Print(id)
+	return e.out.Flush()
+}
+
+func productGet(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("product get")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("product get: want one product ID")
+	}
+	product, err := pb.NewProductInfoClient(e.conn).GetProduct(ctx, &pb.ProductID{Value: fs.Arg(0)}, e.callOptions()...)
+	if err != nil {
+		return err
+	}
+	e.out.Print(product)
+	return e.out.Flush()
+}
+
+func productList(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("product list")
+	name := fs.String("name", "", "list only the products whose name contains this")
+	fs.Parse(args)
+
+	stream, err := pb.NewProductInfoClient(e.conn).ListProducts(ctx, &pb.ProductFilter{Name: *name})
+	if err != nil {
+		return err
+	}
+	defer e.streamDone(stream)
+	for {
+		product, err := stream.Recv()
+		if err == io.EOF {
+			return e.out.Flush()
+		}
+		if err != nil {
+			e.out.Flush()
+			return err
+		}
+		if err := e.out.Print(product); err != nil {
+			return err
+		}
+	}
+}
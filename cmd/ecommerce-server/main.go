@@ -0,0 +1,212 @@
+// ecommerce-server serves ProductInfo and OrderManagement together on one
+// gRPC server, with the health and reflection services next to them. Both
+// services share one store, one interceptor chain and one admin endpoint;
+// each subsystem can be switched off, e.g.
+//
+//	ecommerce-server -listen :50051 -enable_products=false
+//
+// or, in the file named by -config,
+//
+//	enable_reflection: false
+//	order_batch_size: 5
+//
+// With -grpcweb_addr, browsers can call the services over gRPC-Web as well.
+// With -binlog_file, the calls are written to a binary log that the binlog
+// command prints.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/binlog"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/ecommerce"
+	"github.com/eadydb/grpc-samples/pkg/grpcweb"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/loadshed"
+	"github.com/eadydb/grpc-samples/pkg/ratelimit"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/store"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
+)
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50051")
+	var batch config.Batch
+	batch.Register(cfg, 3)
+	var subsystems ecommerce.Flags
+	subsystems.Register(flag.CommandLine)
+	cfg.Check(subsystems.Validate)
+	var authFlags auth.ServerFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var rateFlags ratelimit.ServerFlags
+	rateFlags.Register(flag.CommandLine)
+	var shedFlags loadshed.ServerFlags
+	shedFlags.Register(flag.CommandLine)
+	var deadlineFlags deadline.ServerFlags
+	deadlineFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	var cancelFlags cancellation.ServerFlags
+	cancelFlags.Register(flag.CommandLine)
+	var webFlags grpcweb.ServerFlags
+	webFlags.Register(flag.CommandLine)
+	var binlogFlags binlog.Flags
+	binlogFlags.Register(flag.CommandLine)
+	cfg.Check(cancelFlags.Validate)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("ecommerce-server", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	authOpts, err := authFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	rateOpts, err := rateFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure rate limiting: %v", err)
+	}
+	cfg.Reloadable("rate_limit_config", rateFlags.Reload)
+	shedOpts, err := shedFlags.ServerOptions("ecommerce-server")
+	if err != nil {
+		log.Fatalf("failed to configure load shedding: %v", err)
+	}
+	deadlineOpts, err := deadlineFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure deadline admission: %v", err)
+	}
+	healthDial, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	healthDeps, err := healthFlags.Dependencies(healthDial)
+	if err != nil {
+		log.Fatalf("failed to configure health checks: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	binlogger, err := binlogFlags.Logger()
+	if err != nil {
+		log.Fatalf("failed to configure binary logging: %v", err)
+	}
+
+	st := store.New()
+	if subsystems.SampleOrders {
+		st.LoadSampleOrders()
+	}
+	if subsystems.Orders {
+		// Finish the work cancelled calls left pending, before the pending
+		// store is opened for new batches.
+		n, err := cancellation.Replay(cancelFlags.PendingFile, ecommerce.NewOrderService(st, nil, nil).Replay)
+		if err != nil {
+			log.Printf("failed to replay pending work: %v", err)
+		}
+		if n > 0 {
+			log.Printf("replayed %d pending batches from %s", n, cancelFlags.PendingFile)
+		}
+	}
+	abandoner, err := cancelFlags.Abandoner()
+	if err != nil {
+		log.Fatalf("failed to configure cancellation policy: %v", err)
+	}
+	cfg.Reloadable("cancel_policy", func() error { return cancelFlags.Reload(abandoner) })
+
+	list, err := net.Listen("tcp", serverCfg.Listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	opts := append(binlogger.ServerOptions(), tracing.ServerOptions(tracer)...)
+	opts = append(opts, authOpts...)
+	opts = append(opts, tlsOpts...)
+	opts = append(opts, deadlineOpts...)
+	opts = append(opts, rateOpts...)
+	opts = append(opts, shedOpts...)
+	opts = append(opts, cancellation.ServerOptions()...)
+	run := runFlags.Runner()
+	run.OnStopped(func() {
+		if err := abandoner.Close(); err != nil {
+			log.Printf("failed to close pending store: %v", err)
+		}
+		if err := binlogger.Close(); err != nil {
+			log.Printf("failed to close binary log: %v", err)
+		}
+	})
+	opts = append(opts, run.ServerOptions()...)
+	s := grpc.NewServer(opts...)
+
+	services := subsystems.RegisterServices(s, st, &batch, abandoner)
+	if subsystems.Reflection {
+		reflection.Register(s)
+	}
+	if subsystems.Health {
+		health := healthcheck.New(healthFlags.Interval)
+		for _, name := range services {
+			deps := healthDeps
+			if name == ecommerce.OrderServiceName {
+				deps = append(deps[:len(deps):len(deps)], healthcheck.Dependency{Name: "pending store", Check: abandoner.Check})
+			}
+			health.AddService(name, deps...)
+		}
+		health.Register(s)
+		health.Start()
+		run.OnNotReady(health.Shutdown)
+		run.OnStopped(health.Stop)
+	}
+
+	var webTLS *tls.Config
+	if tlsFlags.Enabled() {
+		if webTLS, err = tlsFlags.Config(); err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+	}
+	web, err := webFlags.Start(s, webTLS)
+	if err != nil {
+		log.Fatalf("failed to start gRPC-Web: %v", err)
+	}
+	run.OnStopped(web.Stop)
+
+	adm.AddServer("ecommerce-server", s)
+	adm.AddStats("store", st.Stats)
+	if subsystems.Orders {
+		adm.AddStats("cancellation", abandoner.Stats)
+	}
+	run.OnStopped(adm.Stop)
+
+	log.Printf("Starting gRPC listener on port %s with %v", serverCfg.Listen, services)
+
+	if err := run.Run(s, list); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
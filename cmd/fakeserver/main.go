@@ -0,0 +1,125 @@
+// fakeserver serves fakes of ProductInfo and OrderManagement, or of the
+// services of a descriptor set, answering as a JSON scenario file scripts,
+// for testing clients against errors, latency and streams a real server
+// rarely produces:
+//
+//	fakeserver -scenario scenario.json -calls calls.jsonl
+//
+// with scenario.json
+//
+//	{"rules": [
+//	  {"method": "getOrder", "match": "102", "responses": [{"message": {"id": "102", "destination": "Mountain View, CA"}}]},
+//	  {"method": "getOrder", "times": 1, "error": {"code": "UNAVAILABLE", "message": "try again",
+//	    "details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1s"}]}},
+//	  {"method": "getOrder", "delay": "2s", "error": {"code": "NOT_FOUND"}},
+//	  {"method": "searchOrders", "header": {"location": "guangzhou"},
+//	    "responses": [{"message": {"id": "102"}}, {"message": {"id": "104"}, "delay": "500ms"}]},
+//	  {"method": "processOrders", "on": "close", "responses": [{"message": {"id": "cmb-all"}}]}
+//	]}
+//
+// Rules are tried in order and the first matching one answers; see package
+// fakeserver for their fields. Every call is written to -calls as a line of
+// JSON once it ends. On SIGHUP the scenario file is read again.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	scenarioFile = flag.String("scenario", "", "JSON file with the rules answering the calls")
+	protoset     = flag.String("protoset", "", "fake the services of this descriptor set file instead of ProductInfo and OrderManagement")
+	callsFile    = flag.String("calls", "", `append every call as a line of JSON to this file, "-" for stdout`)
+)
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50051")
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	cfg.Check(func() error {
+		if *scenarioFile == "" {
+			return fmt.Errorf("-scenario is required")
+		}
+		return nil
+	})
+
+	var fake *fakeserver.Server
+	load := func() error {
+		sc, err := fakeserver.ReadScenario(*scenarioFile)
+		if err != nil {
+			return err
+		}
+		if err := fake.Load(sc); err != nil {
+			return err
+		}
+		log.Printf("Loaded %d rules from %s", len(sc.Rules), *scenarioFile)
+		return nil
+	}
+	cfg.Parse()
+
+	fake = fakeserver.New()
+	if *protoset != "" {
+		src, err := descsource.FromFiles(*protoset)
+		if err != nil {
+			log.Fatalf("failed to load descriptors: %v", err)
+		}
+		if fake, err = fakeserver.NewFromSource(src); err != nil {
+			log.Fatalf("failed to fake services: %v", err)
+		}
+	}
+	if err := load(); err != nil {
+		log.Fatalf("failed to load scenario: %v", err)
+	}
+	// Reloads may run as soon as Parse returns; only hand them the
+	// scenario once there is a fake to load it into.
+	cfg.Reloadable("scenario", load)
+
+	if *callsFile != "" {
+		var w io.Writer = os.Stdout
+		if *callsFile != "-" {
+			f, err := os.OpenFile(*callsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				log.Fatalf("failed to open calls file: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		fake.LogCalls(w)
+	}
+
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	list, err := net.Listen("tcp", serverCfg.Listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	s := grpc.NewServer(tlsOpts...)
+	fake.Register(s)
+	// The reflection service only knows the compiled-in files; clients of a
+	// descriptor set can use the same file.
+	if *protoset == "" {
+		reflection.Register(s)
+	}
+
+	log.Printf("Starting fake server on port %s", serverCfg.Listen)
+	if err := s.Serve(list); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// gateway serves the OrderManagement and ProductInfo services of a gRPC
+// server as HTTP/JSON routes, e.g.
+//
+//	gateway -listen :8081 -address localhost:50051
+//	curl -d '{"id": "201", "items": ["Pixel 5"], "destination": "Berlin"}' localhost:8081/v1/orders
+//	curl localhost:8081/v1/orders/201
+//	curl 'localhost:8081/v1/orders:search?q=Pixel'
+//
+// See package gateway for the routes.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/gateway"
+	"github.com/eadydb/grpc-samples/pkg/retry"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
+	"google.golang.org/grpc"
+)
+
+var (
+	traceFile = flag.String("trace_file", "", "append finished spans as OTLP/JSON to this file")
+)
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":8081")
+	var clientCfg config.Client
+	clientCfg.Register(cfg, "localhost:50051")
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var retryFlags retry.ClientFlags
+	retryFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg.Parse()
+	tracer, err := tracing.NewFileTracer("gateway", *traceFile)
+	if err != nil {
+		log.Fatalf("failed to create tracer: %v", err)
+	}
+	defer tracer.Close()
+
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	authOpts, err := authFlags.DialOptions(tlsOpt)
+	if err != nil {
+		log.Fatalf("failed to configure auth: %v", err)
+	}
+	retryOpts, err := retryFlags.DialOptions()
+	if err != nil {
+		log.Fatalf("failed to configure retries: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+	defer adm.Stop()
+
+	opts := append([]grpc.DialOption{tlsOpt}, tracing.DialOptions(tracer)...)
+	opts = append(opts, authOpts...)
+	opts = append(opts, retryOpts...)
+	conn, err := grpc.Dial(clientCfg.Address, opts...)
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	adm.AddConn(clientCfg.Address, conn)
+
+	hs := &http.Server{
+		Addr:              serverCfg.Listen,
+		Handler:           gateway.New(conn),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Serving HTTP/JSON gateway to %s on %s", clientCfg.Address, serverCfg.Listen)
+	if err := hs.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
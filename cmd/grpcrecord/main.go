@@ -0,0 +1,173 @@
+// grpcrecord records the gRPC calls between clients and a server and plays
+// them back, to reproduce problems seen in production locally:
+//
+//	grpcrecord [flags] proxy    forward calls from -listen to -address, recording them
+//	grpcrecord [flags] replay   make the recorded calls again against -address
+//	grpcrecord [flags] serve    answer calls on -listen from the recording
+//
+// e.g.
+//
+//	grpcrecord -listen :50052 -address prod-orders:50051 -tls -recording calls.jsonl proxy
+//	grpcrecord -address localhost:50051 -recording calls.jsonl replay
+//	grpcrecord -listen :50051 -recording calls.jsonl -timing serve
+//
+// The proxy forwards any method, with the metadata, header, trailer and
+// status of each call, so clients only need to be pointed at it; it appends
+// to -recording, one call per line of JSON. replay reports the calls whose
+// status or responses differ from the recording and exits with status 1 if
+// any do. With -timing, replay and serve keep the recorded delays between
+// the messages.
+//
+// The proxy records the authorization metadata and the keys listed in
+// -redact as REDACTED. replay leaves redacted metadata out and authenticates
+// with -token, -token_file or -token_server instead.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/recording"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"google.golang.org/grpc"
+)
+
+var (
+	recordingFile = flag.String("recording", "calls.jsonl", "file the calls are recorded to and replayed from")
+	timing        = flag.Bool("timing", false, "replay and serve the messages with their recorded delays")
+	timeout       = flag.Duration("timeout", 30*time.Second, "deadline of each replayed call")
+	redact        = flag.String("redact", "", "comma separated metadata keys whose values the proxy does not record, besides authorization")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: grpcrecord [flags] proxy|replay|serve\n\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50052")
+	var clientCfg config.Client
+	clientCfg.Register(cfg, "localhost:50051")
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	var authFlags auth.ClientFlags
+	authFlags.Register(flag.CommandLine)
+	flag.Usage = usage
+	cfg.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch mode := flag.Arg(0); mode {
+	case "proxy":
+		proxy(serverCfg.Listen, dial(clientCfg.Address, &tlsFlags))
+	case "replay":
+		tlsOpt, err := tlsFlags.DialOption()
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+		authOpts, err := authFlags.DialOptions(tlsOpt)
+		if err != nil {
+			log.Fatalf("failed to configure authentication: %v", err)
+		}
+		if !replay(dial(clientCfg.Address, &tlsFlags, authOpts...)) {
+			os.Exit(1)
+		}
+	case "serve":
+		serve(serverCfg.Listen)
+	default:
+		fmt.Fprintf(os.Stderr, "grpcrecord: unknown mode %q\n\n", mode)
+		usage()
+		os.Exit(2)
+	}
+}
+
+func dial(addr string, tlsFlags *tlsconfig.ClientFlags, opts ...grpc.DialOption) *grpc.ClientConn {
+	tlsOpt, err := tlsFlags.DialOption()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	conn, err := grpc.Dial(addr, append(opts, tlsOpt)...)
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	return conn
+}
+
+func proxy(listen string, backend *grpc.ClientConn) {
+	f, err := os.OpenFile(*recordingFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatalf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+	var keys []string
+	for _, k := range strings.Split(*redact, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	p := recording.NewProxy(backend, recording.NewWriter(f), keys...)
+	s := grpc.NewServer(p.ServerOptions()...)
+
+	log.Printf("Recording calls from %s to %s in %s", listen, backend.Target(), *recordingFile)
+	run(s, listen)
+}
+
+func serve(listen string) {
+	calls, err := recording.ReadFile(*recordingFile)
+	if err != nil {
+		log.Fatalf("failed to read recording: %v", err)
+	}
+	r := recording.NewServer(calls, *timing)
+	s := grpc.NewServer(r.ServerOptions()...)
+
+	log.Printf("Answering calls on %s from the %d calls of %s", listen, len(calls), *recordingFile)
+	run(s, listen)
+}
+
+func run(s *grpc.Server, listen string) {
+	list, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	if err := s.Serve(list); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+// replay replays the recording and reports whether every call got the
+// recorded answers.
+func replay(conn *grpc.ClientConn) bool {
+	calls, err := recording.ReadFile(*recordingFile)
+	if err != nil {
+		log.Fatalf("failed to read recording: %v", err)
+	}
+	same := 0
+	for _, c := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		res := recording.Replay(ctx, conn, c, *timing)
+		cancel()
+		if len(res.Diffs) == 0 {
+			same++
+			fmt.Printf("ok    %s %s in %v\n", c.Method, res.Got.Code, res.Got.Duration.Round(time.Microsecond))
+			continue
+		}
+		fmt.Printf("DIFF  %s recorded at %s\n", c.Method, c.Start.Format(time.RFC3339Nano))
+		for _, d := range res.Diffs {
+			fmt.Printf("      %s\n", d)
+		}
+	}
+	fmt.Printf("%d of %d calls answered as recorded\n", same, len(calls))
+	return same == len(calls)
+}
@@ -0,0 +1,99 @@
+// grpcwebclient calls the ecommerce services over gRPC-Web, the way a
+// browser would, to try out the gRPC-Web endpoint of ecommerce-server:
+//
+//	ecommerce-server -grpcweb_addr :8080 -grpcweb_allowed_origins http://localhost:3000
+//	grpcwebclient -url http://localhost:8080 -text -origin http://localhost:3000
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/grpcweb"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	url    = flag.String("url", "http://localhost:8080", "base URL of the gRPC-Web endpoint")
+	text   = flag.Bool("text", false, "use the base64 text framing instead of the binary one")
+	origin = flag.String("origin", "", "Origin header sent with the calls, as a browser page on that origin would")
+	query  = flag.String("query", "Google", "item searched for in the orders")
+)
+
+func main() {
+	cfg := config.NewLoader(flag.CommandLine)
+	var tlsFlags tlsconfig.ClientFlags
+	tlsFlags.Register(flag.CommandLine)
+	cfg.Parse()
+
+	hc := &http.Client{}
+	if tlsFlags.Enabled() {
+		tlsConfig, err := tlsFlags.Config()
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+		hc.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	opts := grpcweb.ClientOptions{HTTPClient: hc, Text: *text}
+	if *origin != "" {
+		opts.Header = http.Header{"Origin": {*origin}}
+	}
+	conn := grpcweb.NewClientConn(*url, opts)
+	orders := opb.NewOrderManagementClient(conn)
+	products := ppb.NewProductInfoClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "client", "grpcwebclient")
+
+	// Unary
+	var header metadata.MD
+	order, err := orders.GetOrder(ctx, &wrappers.StringValue{Value: "106"}, grpc.Header(&header))
+	if err != nil {
+		log.Printf("GetOrder: %v", err)
+	} else {
+		log.Printf("GetOrder: %v (header %v)", order, header)
+	}
+
+	// Server streaming
+	stream, err := orders.SearchOrders(ctx, &wrappers.StringValue{Value: *query})
+	if err != nil {
+		log.Fatalf("SearchOrders: %v", err)
+	}
+	for {
+		order, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("SearchOrders: %v", err)
+		}
+		log.Printf("Search Result : %v", order)
+	}
+
+	id, err := products.AddProduct(ctx, &ppb.Product{Name: "Apple iphone 11", Price: 699})
+	if err != nil {
+		log.Printf("AddProduct: %v", err)
+	} else if product, err := products.GetProduct(ctx, id); err != nil {
+		log.Printf("GetProduct: %v", err)
+	} else {
+		log.Printf("Product: %v", product)
+	}
+
+	_, err = orders.GetOrder(ctx, &wrappers.StringValue{Value: "no-such-order"})
+	log.Printf("GetOrder of a missing order: %v", err)
+
+	// gRPC-Web has no client streaming.
+	_, err = orders.UpdateOrders(ctx)
+	log.Printf("UpdateOrders: %v", err)
+}
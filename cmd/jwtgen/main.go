@@ -0,0 +1,71 @@
+// jwtgen mints signed tokens for the ecommerce servers, e.g.
+//
+//	jwtgen -hmac_key secret.key -sub alice -roles admin > alice.jwt
+//	order-client -token_file alice.jwt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+)
+
+var (
+	hmacKey  = flag.String("hmac_key", "", "file holding the HMAC secret (HS256)")
+	rsaKey   = flag.String("rsa_key", "", "PEM file holding the RSA private key (RS256)")
+	kid      = flag.String("kid", "", "key id written to the token header")
+	subject  = flag.String("sub", "", "subject of the token")
+	roles    = flag.String("roles", "", "comma separated roles granted to the subject")
+	scope    = flag.String("scope", "", "space separated scopes granted to the subject")
+	issuer   = flag.String("iss", "", "issuer of the token")
+	audience = flag.String("aud", "", "audience of the token")
+	ttl      = flag.Duration("ttl", time.Hour, "lifetime of the token")
+)
+
+func main() {
+	config.NewLoader(flag.CommandLine).Parse()
+
+	var signer *auth.Signer
+	switch {
+	case *rsaKey != "":
+		key, err := auth.ReadRSAPrivateKey(*rsaKey)
+		if err != nil {
+			log.Fatalf("failed to load rsa key: %v", err)
+		}
+		signer = auth.NewRSASigner(*kid, key)
+	case *hmacKey != "":
+		b, err := ioutil.ReadFile(*hmacKey)
+		if err != nil {
+			log.Fatalf("failed to load hmac key: %v", err)
+		}
+		signer = auth.NewHMACSigner(*kid, []byte(strings.TrimSpace(string(b))))
+	default:
+		log.Fatal("one of -hmac_key or -rsa_key is required")
+	}
+
+	now := time.Now()
+	claims := &auth.Claims{
+		Subject:   *subject,
+		Issuer:    *issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(*ttl).Unix(),
+		Scope:     *scope,
+	}
+	if *audience != "" {
+		claims.Audience = auth.Audience{*audience}
+	}
+	if *roles != "" {
+		claims.Roles = strings.Split(*roles, ",")
+	}
+	token, err := signer.Sign(claims)
+	if err != nil {
+		log.Fatalf("failed to sign token: %v", err)
+	}
+	fmt.Println(token)
+}
@@ -0,0 +1,123 @@
+// tokenserver runs the TokenService, issuing short-lived access tokens to the
+// clients registered in a JSON file:
+//
+//	[
+//	  {"client_id": "order-client", "client_secret": "s3cret", "scopes": ["orders.read", "orders.write"], "audiences": ["order-server"]},
+//	  {"client_id": "product-client", "client_secret_sha256": "<hex sha256>", "scopes": ["products.read"], "roles": ["viewer"]}
+//	]
+//
+// A client gets tokens for the -audience of the server and for the other
+// audiences it lists. The order and product servers verify the tokens with
+// the same key, e.g.
+//
+//	tokenserver -clients clients.json -hmac_key secret.key
+//	server -jwt_hmac_key secret.key -auth_policy policy.json
+//	client -token_server localhost:50053 -client_id order-client -client_secret s3cret
+//
+// Tokens signed with a -kid are only accepted by servers started with the
+// same -jwt_kid.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/admin"
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/healthcheck"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/tlsconfig"
+	"github.com/eadydb/grpc-samples/pkg/token"
+	pb "github.com/eadydb/grpc-samples/pkg/token/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	clientsFile = flag.String("clients", "clients.json", "JSON file with the registered clients")
+	hmacKey     = flag.String("hmac_key", "", "file holding the HMAC secret used to sign HS256 tokens")
+	rsaKey      = flag.String("rsa_key", "", "PEM file holding the RSA private key used to sign RS256 tokens")
+	kid         = flag.String("kid", "", "key id written to the token header")
+	issuer      = flag.String("issuer", "ecommerce-token-service", "issuer written to the tokens")
+	audience    = flag.String("audience", "", "default audience written to the tokens")
+	ttl         = flag.Duration("ttl", 5*time.Minute, "lifetime of issued tokens")
+)
+
+func main() {
+	var tlsFlags tlsconfig.ServerFlags
+	tlsFlags.Register(flag.CommandLine)
+	var runFlags runner.ServerFlags
+	runFlags.Register(flag.CommandLine)
+	var healthFlags healthcheck.ServerFlags
+	healthFlags.Register(flag.CommandLine)
+	var adminFlags admin.Flags
+	adminFlags.Register(flag.CommandLine)
+	cfg := config.NewLoader(flag.CommandLine)
+	var serverCfg config.Server
+	serverCfg.Register(cfg, ":50053")
+	cfg.Parse()
+
+	var signer *auth.Signer
+	switch {
+	case *rsaKey != "":
+		key, err := auth.ReadRSAPrivateKey(*rsaKey)
+		if err != nil {
+			log.Fatalf("failed to load rsa key: %v", err)
+		}
+		signer = auth.NewRSASigner(*kid, key)
+	case *hmacKey != "":
+		b, err := ioutil.ReadFile(*hmacKey)
+		if err != nil {
+			log.Fatalf("failed to load hmac key: %v", err)
+		}
+		signer = auth.NewHMACSigner(*kid, []byte(strings.TrimSpace(string(b))))
+	default:
+		log.Fatal("one of -hmac_key or -rsa_key is required")
+	}
+
+	clients, err := token.LoadClients(*clientsFile)
+	if err != nil {
+		log.Fatalf("failed to load clients: %v", err)
+	}
+
+	tlsOpts, err := tlsFlags.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to configure TLS: %v", err)
+	}
+	adm, err := adminFlags.Start()
+	if err != nil {
+		log.Fatalf("failed to start admin endpoint: %v", err)
+	}
+
+	list, err := net.Listen("tcp", serverCfg.Listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	s := grpc.NewServer(tlsOpts...)
+	pb.RegisterTokenServiceServer(s, token.NewIssuer(signer, clients, *ttl, *issuer, *audience))
+	reflection.Register(s)
+
+	run := runFlags.Runner()
+	health := healthcheck.New(healthFlags.Interval)
+	health.AddService(pb.TokenService_ServiceDesc.ServiceName)
+	health.Register(s)
+	health.Start()
+	run.OnNotReady(health.Shutdown)
+	run.OnStopped(health.Stop)
+
+	adm.AddServer("token-server", s)
+	adm.AddStats("clients", func() interface{} { return len(clients) })
+	run.OnStopped(adm.Stop)
+
+	log.Printf("Starting token service on %s with %d clients", serverCfg.Listen, len(clients))
+
+	if err := run.Run(s, list); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// traceview renders traces written by the tracing file exporter as a text
+// waterfall.
+//
+//	traceview -file spans.json               # list the traces in the file
+//	traceview -file spans.json -trace 4bf92f # render one trace (id prefix)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
+)
+
+var (
+	file    = flag.String("file", "spans.json", "OTLP/JSON span file written by the tracing file exporter")
+	traceID = flag.String("trace", "", "id (or unique prefix) of the trace to render; lists traces when empty")
+	width   = flag.Int("width", 50, "width of the waterfall bars in characters")
+)
+
+func main() {
+	config.NewLoader(flag.CommandLine).Parse()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("failed to open span file: %v", err)
+	}
+	defer f.Close()
+
+	spans, err := tracing.ReadSpans(f)
+	if err != nil {
+		log.Fatalf("failed to read spans: %v", err)
+	}
+
+	traces := groupByTrace(spans)
+	if *traceID == "" {
+		listTraces(os.Stdout, traces)
+		return
+	}
+
+	var matched []string
+	for id := range traces {
+		if strings.HasPrefix(id, strings.ToLower(*traceID)) {
+			matched = append(matched, id)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		log.Fatalf("no trace matches %q", *traceID)
+	case 1:
+		renderWaterfall(os.Stdout, matched[0], traces[matched[0]], *width)
+	default:
+		log.Fatalf("trace id %q is ambiguous, %d traces match", *traceID, len(matched))
+	}
+}
+
+func groupByTrace(spans []*tracing.SpanData) map[string][]*tracing.SpanData {
+	traces := make(map[string][]*tracing.SpanData)
+	for _, s := range spans {
+		id := s.Context.TraceID.String()
+		traces[id] = append(traces[id], s)
+	}
+	return traces
+}
+
+// bounds returns the earliest start and latest end of spans.
+func bounds(spans []*tracing.SpanData) (time.Time, time.Time) {
+	start, end := spans[0].Start, spans[0].End
+	for _, s := range spans[1:] {
+		if s.Start.Before(start) {
+			start = s.Start
+		}
+		if s.End.After(end) {
+			end = s.End
+		}
+	}
+	return start, end
+}
+
+func listTraces(w io.Writer, traces map[string][]*tracing.SpanData) {
+	type row struct {
+		id    string
+		root  string
+		start time.Time
+		dur   time.Duration
+		n     int
+	}
+	var rows []row
+	for id, spans := range traces {
+		start, end := bounds(spans)
+		root := "?"
+		for _, s := range spans {
+			if !s.Parent.IsValid() || !hasSpan(spans, s.Parent) {
+				root = s.Name
+				break
+			}
+		}
+		rows = append(rows, row{id: id, root: root, start: start, dur: end.Sub(start), n: len(spans)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].start.Before(rows[j].start) })
+	fmt.Fprintf(w, "%-32s  %-23s  %5s  %10s  %s\n", "TRACE", "START", "SPANS", "DURATION", "ROOT")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%-32s  %-23s  %5d  %10s  %s\n", r.id, r.start.Format("2006-01-02 15:04:05.000"), r.n, fmtDur(r.dur), r.root)
+	}
+}
+
+func hasSpan(spans []*tracing.SpanData, id tracing.SpanID) bool {
+	for _, s := range spans {
+		if s.Context.SpanID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func renderWaterfall(w io.Writer, id string, spans []*tracing.SpanData, width int) {
+	start, end := bounds(spans)
+	total := end.Sub(start)
+	if total <= 0 {
+		total = time.Nanosecond
+	}
+
+	children := make(map[tracing.SpanID][]*tracing.SpanData)
+	var roots []*tracing.SpanData
+	for _, s := range spans {
+		if s.Parent.IsValid() && hasSpan(spans, s.Parent) {
+			children[s.Parent] = append(children[s.Parent], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+	byStart := func(list []*tracing.SpanData) {
+		sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	}
+
+	fmt.Fprintf(w, "trace %s  %d spans  %s\n\n", id, len(spans), fmtDur(total))
+
+	var walk func(s *tracing.SpanData, depth int)
+	walk = func(s *tracing.SpanData, depth int) {
+		from := int(int64(width) * int64(s.Start.Sub(start)) / int64(total))
+		to := int(int64(width) * int64(s.End.Sub(start)) / int64(total))
+		if to <= from {
+			to = from + 1
+		}
+		if to > width {
+			to = width
+			if from >= to {
+				from = to - 1
+			}
+		}
+		bar := strings.Repeat(" ", from) + strings.Repeat("=", to-from) + strings.Repeat(" ", width-to)
+
+		label := strings.Repeat("  ", depth) + s.Name
+		if s.Kind != tracing.SpanKindInternal {
+			label += " [" + s.Service + " " + s.Kind.String() + "]"
+		}
+		mark := " "
+		if s.StatusCode == tracing.StatusError {
+			mark = "!"
+		}
+		fmt.Fprintf(w, "%s|%s| %9s +%-9s %s\n", mark, bar, fmtDur(s.Duration()), fmtDur(s.Start.Sub(start)), label)
+
+		kids := children[s.Context.SpanID]
+		byStart(kids)
+		for _, c := range kids {
+			walk(c, depth+1)
+		}
+	}
+	byStart(roots)
+	for _, r := range roots {
+		walk(r, 0)
+	}
+}
+
+func fmtDur(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
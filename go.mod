@@ -9,4 +9,5 @@ require (
 	google.golang.org/grpc v1.36.1
 	google.golang.org/grpc/examples v0.0.0-20210409234925-fab5982df20a
 	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,309 @@
+// Package admin serves an HTTP endpoint for looking into a running server or
+// client: the channelz data of its channels, servers and sockets as HTML
+// pages and JSON, pprof profiles, the expvar metrics, the current flag values,
+// the registered services and statistics of the stores behind them.
+//
+// The endpoint is meant for operators and has no authentication; bind it to
+// a loopback or otherwise private address.
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+)
+
+// Admin is the admin endpoint of a process. A nil *Admin, returned when the
+// endpoint is disabled, ignores all calls, so that programs can register
+// their servers and stores unconditionally.
+type Admin struct {
+	fs     *flag.FlagSet
+	linger time.Duration
+	mux    *http.ServeMux
+	cz     *channelz
+	hs     *http.Server
+
+	mu      sync.Mutex
+	servers []namedServer
+	conns   []namedConn
+	stats   []namedStats
+}
+
+type namedServer struct {
+	name string
+	s    *grpc.Server
+}
+
+type namedConn struct {
+	name string
+	conn *grpc.ClientConn
+}
+
+type namedStats struct {
+	name string
+	f    func() interface{}
+}
+
+// New returns an admin endpoint reporting the flags of fs. It does not
+// listen until Serve or Start.
+func New(fs *flag.FlagSet) (*Admin, error) {
+	cz, err := newChannelz()
+	if err != nil {
+		return nil, err
+	}
+	a := &Admin{fs: fs, mux: http.NewServeMux(), cz: cz}
+	a.mux.HandleFunc("/", a.index)
+	a.mux.HandleFunc("/config", a.config)
+	a.mux.HandleFunc("/services", a.services)
+	a.mux.HandleFunc("/stats", a.statsPage)
+	a.mux.Handle("/debug/vars", expvar.Handler())
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	cz.register(a.mux)
+	return a, nil
+}
+
+// AddServer lists the services of s under name.
+func (a *Admin) AddServer(name string, s *grpc.Server) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.servers = append(a.servers, namedServer{name, s})
+}
+
+// AddConn lists the target and state of conn under name.
+func (a *Admin) AddConn(name string, conn *grpc.ClientConn) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns = append(a.conns, namedConn{name, conn})
+}
+
+// AddStats reports the result of f, which must be safe to call at any time
+// and return a value encodable as JSON, under name.
+func (a *Admin) AddStats(name string, f func() interface{}) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats = append(a.stats, namedStats{name, f})
+}
+
+// ServeHTTP serves the admin pages.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Start serves the admin pages on addr in the background.
+func (a *Admin) Start(addr string) error {
+	if a == nil {
+		return nil
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("admin: %v", err)
+	}
+	logging.Infof("Serving admin pages on http://%s/", lis.Addr())
+	// Create the server before going to the background, so that a Stop
+	// right after Start finds it and the listener gets closed.
+	hs := a.server()
+	go hs.Serve(lis)
+	return nil
+}
+
+// Serve serves the admin pages on lis until Stop.
+func (a *Admin) Serve(lis net.Listener) error {
+	if err := a.server().Serve(lis); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// server returns the HTTP server of the endpoint, creating it on first use.
+func (a *Admin) server() *http.Server {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hs == nil {
+		a.hs = &http.Server{Handler: a}
+	}
+	return a.hs
+}
+
+// Linger keeps the endpoint up for the configured time before it is
+// stopped, so that the channels of a client that has finished its calls can
+// still be inspected.
+func (a *Admin) Linger() {
+	if a == nil || a.linger <= 0 {
+		return
+	}
+	logging.Infof("admin pages stay up for %v", a.linger)
+	time.Sleep(a.linger)
+}
+
+// Stop closes the endpoint.
+func (a *Admin) Stop() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	hs := a.hs
+	a.mu.Unlock()
+	if hs != nil {
+		hs.Close()
+	}
+	a.cz.close()
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>admin</title>` + style + `</head><body>
+<h1>admin</h1>
+<ul>
+<li><a href="/channelz/">channelz</a> (<a href="/channelz/?format=json">json</a>): channels, servers and sockets</li>
+<li><a href="/services">services</a>: registered services and client connections</li>
+<li><a href="/stats">stats</a>: store statistics</li>
+<li><a href="/config">config</a>: current flag values</li>
+<li><a href="/debug/vars">expvar</a>: metrics</li>
+<li><a href="/debug/pprof/">pprof</a>: profiles</li>
+</ul>
+{{if .}}<h2>stats</h2><pre>{{.}}</pre>{{end}}
+</body></html>
+`))
+
+const style = `<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; }
+pre { background: #f6f6f6; padding: 6px; }
+</style>`
+
+func (a *Admin) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var stats string
+	if m := a.collectStats(); len(m) > 0 {
+		b, _ := json.MarshalIndent(m, "", "  ")
+		stats = string(b)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, stats)
+}
+
+// flagValue is a flag as reported by /config.
+type flagValue struct {
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+}
+
+func (a *Admin) config(w http.ResponseWriter, r *http.Request) {
+	flags := make(map[string]flagValue)
+	if a.fs != nil {
+		config.VisitAll(a.fs, func(f *flag.Flag) {
+			v := f.Value.String()
+			if sensitive(f.Name) && v != "" {
+				v = "REDACTED"
+			}
+			flags[f.Name] = flagValue{Value: v, Default: f.DefValue, Usage: f.Usage}
+		})
+	}
+	writeJSON(w, flags)
+}
+
+// sensitive reports whether the value of the flag is a secret rather than
+// a reference to one.
+func sensitive(name string) bool {
+	return name == "token" || strings.HasSuffix(name, "secret") || strings.HasSuffix(name, "password")
+}
+
+type serviceInfo struct {
+	Name    string       `json:"name"`
+	Methods []methodInfo `json:"methods"`
+}
+
+type methodInfo struct {
+	Name            string `json:"name"`
+	ClientStreaming bool   `json:"client_streaming,omitempty"`
+	ServerStreaming bool   `json:"server_streaming,omitempty"`
+}
+
+type connInfo struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	State  string `json:"state"`
+}
+
+func (a *Admin) services(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	servers := append([]namedServer(nil), a.servers...)
+	conns := append([]namedConn(nil), a.conns...)
+	a.mu.Unlock()
+
+	resp := struct {
+		Servers map[string][]serviceInfo `json:"servers,omitempty"`
+		Conns   []connInfo               `json:"conns,omitempty"`
+	}{Servers: make(map[string][]serviceInfo)}
+	for _, ns := range servers {
+		var svcs []serviceInfo
+		for name, info := range ns.s.GetServiceInfo() {
+			svc := serviceInfo{Name: name}
+			for _, m := range info.Methods {
+				svc.Methods = append(svc.Methods, methodInfo{m.Name, m.IsClientStream, m.IsServerStream})
+			}
+			svcs = append(svcs, svc)
+		}
+		sort.Slice(svcs, func(i, j int) bool { return svcs[i].Name < svcs[j].Name })
+		resp.Servers[ns.name] = svcs
+	}
+	for _, nc := range conns {
+		resp.Conns = append(resp.Conns, connInfo{nc.name, nc.conn.Target(), nc.conn.GetState().String()})
+	}
+	writeJSON(w, resp)
+}
+
+func (a *Admin) collectStats() map[string]interface{} {
+	a.mu.Lock()
+	stats := append([]namedStats(nil), a.stats...)
+	a.mu.Unlock()
+	m := make(map[string]interface{}, len(stats))
+	for _, s := range stats {
+		m[s.name] = s.f()
+	}
+	return m
+}
+
+func (a *Admin) statsPage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.collectStats())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,529 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	czpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The channelz data is only reachable through the channelz service, so the
+// admin endpoint serves that service on an in-process connection of its own.
+// Its server and channel are named after czTarget and left out of the pages.
+const czTarget = "admin-channelz"
+
+type channelz struct {
+	s      *grpc.Server
+	conn   *grpc.ClientConn
+	client czpb.ChannelzClient
+}
+
+type czListener struct{ *bufconn.Listener }
+
+func (czListener) Addr() net.Addr { return czAddr{} }
+
+type czAddr struct{}
+
+func (czAddr) Network() string { return "bufconn" }
+func (czAddr) String() string  { return czTarget }
+
+func newChannelz() (*channelz, error) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	service.RegisterChannelzServiceToServer(s)
+	go s.Serve(czListener{lis})
+	conn, err := grpc.Dial(czTarget, grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		s.Stop()
+		return nil, fmt.Errorf("admin: channelz: %v", err)
+	}
+	return &channelz{s: s, conn: conn, client: czpb.NewChannelzClient(conn)}, nil
+}
+
+func (c *channelz) close() {
+	c.conn.Close()
+	c.s.Stop()
+}
+
+func (c *channelz) register(mux *http.ServeMux) {
+	mux.HandleFunc("/channelz/", c.overview)
+	mux.HandleFunc("/channelz/channel/", c.channel)
+	mux.HandleFunc("/channelz/subchannel/", c.subchannel)
+	mux.HandleFunc("/channelz/server/", c.server)
+	mux.HandleFunc("/channelz/socket/", c.socket)
+}
+
+func (c *channelz) topChannels(ctx context.Context) ([]*czpb.Channel, error) {
+	var chans []*czpb.Channel
+	var start int64
+	for {
+		resp, err := c.client.GetTopChannels(ctx, &czpb.GetTopChannelsRequest{StartChannelId: start})
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range resp.Channel {
+			if ch.GetData().GetTarget() != czTarget {
+				chans = append(chans, ch)
+			}
+			start = ch.GetRef().GetChannelId() + 1
+		}
+		if resp.End || len(resp.Channel) == 0 {
+			return chans, nil
+		}
+	}
+}
+
+func (c *channelz) servers(ctx context.Context) ([]*czpb.Server, error) {
+	var servers []*czpb.Server
+	var start int64
+	for {
+		resp, err := c.client.GetServers(ctx, &czpb.GetServersRequest{StartServerId: start})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range resp.Server {
+			if !isAdminServer(s) {
+				servers = append(servers, s)
+			}
+			start = s.GetRef().GetServerId() + 1
+		}
+		if resp.End || len(resp.Server) == 0 {
+			return servers, nil
+		}
+	}
+}
+
+func isAdminServer(s *czpb.Server) bool {
+	for _, ls := range s.ListenSocket {
+		if ls.Name == czTarget {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *channelz) serverSockets(ctx context.Context, id int64) ([]*czpb.SocketRef, error) {
+	var refs []*czpb.SocketRef
+	var start int64
+	for {
+		resp, err := c.client.GetServerSockets(ctx, &czpb.GetServerSocketsRequest{ServerId: id, StartSocketId: start})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp.SocketRef {
+			refs = append(refs, r)
+			start = r.SocketId + 1
+		}
+		if resp.End || len(resp.SocketRef) == 0 {
+			return refs, nil
+		}
+	}
+}
+
+// The pages. Each is HTML, or the channelz messages as JSON with
+// ?format=json.
+
+func (c *channelz) overview(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/channelz/" {
+		http.NotFound(w, r)
+		return
+	}
+	chans, err := c.topChannels(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	servers, err := c.servers(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wantJSON(r) {
+		resp := struct {
+			Channels []json.RawMessage `json:"channels"`
+			Servers  []json.RawMessage `json:"servers"`
+		}{Channels: []json.RawMessage{}, Servers: []json.RawMessage{}}
+		for _, ch := range chans {
+			resp.Channels = append(resp.Channels, marshalProto(ch))
+		}
+		for _, s := range servers {
+			resp.Servers = append(resp.Servers, marshalProto(s))
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	var v overviewView
+	for _, ch := range chans {
+		d := ch.GetData()
+		v.Channels = append(v.Channels, channelRow{
+			ID:     ch.GetRef().GetChannelId(),
+			Target: d.GetTarget(),
+			State:  d.GetState().GetState().String(),
+			Calls:  calls(d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed(), d.GetLastCallStartedTimestamp()),
+		})
+	}
+	for _, s := range servers {
+		d := s.GetData()
+		row := serverRow{
+			ID:    s.GetRef().GetServerId(),
+			Calls: calls(d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed(), d.GetLastCallStartedTimestamp()),
+		}
+		for _, ls := range s.ListenSocket {
+			row.Listen = append(row.Listen, ls.Name)
+		}
+		v.Servers = append(v.Servers, row)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	overviewTemplate.Execute(w, v)
+}
+
+func (c *channelz) channel(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "/channelz/channel/")
+	if !ok {
+		return
+	}
+	resp, err := c.client.GetChannel(r.Context(), &czpb.GetChannelRequest{ChannelId: id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ch := resp.Channel
+	if wantJSON(r) {
+		writeJSON(w, marshalProto(ch))
+		return
+	}
+	p := channelPage("channel", id, ch.GetRef().GetName(), ch.GetData())
+	p.Groups = append(p.Groups,
+		group{"child channels", channelLinks(ch.ChannelRef)},
+		group{"subchannels", subchannelLinks(ch.SubchannelRef)},
+		group{"sockets", socketLinks(ch.SocketRef)})
+	renderDetail(w, p)
+}
+
+func (c *channelz) subchannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "/channelz/subchannel/")
+	if !ok {
+		return
+	}
+	resp, err := c.client.GetSubchannel(r.Context(), &czpb.GetSubchannelRequest{SubchannelId: id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sc := resp.Subchannel
+	if wantJSON(r) {
+		writeJSON(w, marshalProto(sc))
+		return
+	}
+	p := channelPage("subchannel", id, sc.GetRef().GetName(), sc.GetData())
+	p.Groups = append(p.Groups,
+		group{"child channels", channelLinks(sc.ChannelRef)},
+		group{"subchannels", subchannelLinks(sc.SubchannelRef)},
+		group{"sockets", socketLinks(sc.SocketRef)})
+	renderDetail(w, p)
+}
+
+func (c *channelz) server(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "/channelz/server/")
+	if !ok {
+		return
+	}
+	resp, err := c.client.GetServer(r.Context(), &czpb.GetServerRequest{ServerId: id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s := resp.Server
+	sockets, err := c.serverSockets(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wantJSON(r) {
+		writeJSON(w, struct {
+			Server  json.RawMessage   `json:"server"`
+			Sockets []json.RawMessage `json:"sockets"`
+		}{marshalProto(s), marshalRefs(sockets)})
+		return
+	}
+	d := s.GetData()
+	p := detailView{
+		Title:  fmt.Sprintf("server %d", id),
+		Fields: callFields(d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed(), d.GetLastCallStartedTimestamp()),
+		Trace:  traceEvents(d.GetTrace()),
+		Groups: []group{
+			{"listen sockets", socketLinks(s.ListenSocket)},
+			{"connections", socketLinks(sockets)},
+		},
+	}
+	renderDetail(w, p)
+}
+
+func (c *channelz) socket(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "/channelz/socket/")
+	if !ok {
+		return
+	}
+	resp, err := c.client.GetSocket(r.Context(), &czpb.GetSocketRequest{SocketId: id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s := resp.Socket
+	if wantJSON(r) {
+		writeJSON(w, marshalProto(s))
+		return
+	}
+	d := s.GetData()
+	p := detailView{
+		Title: fmt.Sprintf("socket %d %s", id, s.GetRef().GetName()),
+		Fields: []field{
+			{"local", address(s.GetLocal())},
+			{"remote", address(s.GetRemote())},
+			{"remote name", s.GetRemoteName()},
+			{"security", security(s.GetSecurity())},
+			{"streams started", strconv.FormatInt(d.GetStreamsStarted(), 10)},
+			{"streams succeeded", strconv.FormatInt(d.GetStreamsSucceeded(), 10)},
+			{"streams failed", strconv.FormatInt(d.GetStreamsFailed(), 10)},
+			{"messages sent", strconv.FormatInt(d.GetMessagesSent(), 10)},
+			{"messages received", strconv.FormatInt(d.GetMessagesReceived(), 10)},
+			{"keepalives sent", strconv.FormatInt(d.GetKeepAlivesSent(), 10)},
+			{"last local stream", timestamp(d.GetLastLocalStreamCreatedTimestamp())},
+			{"last remote stream", timestamp(d.GetLastRemoteStreamCreatedTimestamp())},
+			{"last message sent", timestamp(d.GetLastMessageSentTimestamp())},
+			{"last message received", timestamp(d.GetLastMessageReceivedTimestamp())},
+			{"local flow control window", fmt.Sprint(d.GetLocalFlowControlWindow().GetValue())},
+			{"remote flow control window", fmt.Sprint(d.GetRemoteFlowControlWindow().GetValue())},
+		},
+	}
+	renderDetail(w, p)
+}
+
+func wantJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json"
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, prefix string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, prefix), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func marshalProto(m proto.Message) json.RawMessage {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		b, _ = json.Marshal(err.Error())
+	}
+	return b
+}
+
+func marshalRefs(refs []*czpb.SocketRef) []json.RawMessage {
+	var out []json.RawMessage
+	for _, r := range refs {
+		out = append(out, marshalProto(r))
+	}
+	return out
+}
+
+// Views of the channelz messages for the templates.
+
+type overviewView struct {
+	Channels []channelRow
+	Servers  []serverRow
+}
+
+type channelRow struct {
+	ID     int64
+	Target string
+	State  string
+	Calls  callCounts
+}
+
+type serverRow struct {
+	ID     int64
+	Listen []string
+	Calls  callCounts
+}
+
+type callCounts struct {
+	Started, Succeeded, Failed int64
+	Last                       string
+}
+
+type detailView struct {
+	Title  string
+	Fields []field
+	Groups []group
+	Trace  []traceEvent
+}
+
+type field struct{ Name, Value string }
+
+type group struct {
+	Title string
+	Links []link
+}
+
+type link struct {
+	Href string
+	Text string
+}
+
+type traceEvent struct{ Time, Severity, Description string }
+
+func calls(started, succeeded, failed int64, last *timestamppb.Timestamp) callCounts {
+	return callCounts{started, succeeded, failed, timestamp(last)}
+}
+
+func callFields(started, succeeded, failed int64, last *timestamppb.Timestamp) []field {
+	return []field{
+		{"calls started", strconv.FormatInt(started, 10)},
+		{"calls succeeded", strconv.FormatInt(succeeded, 10)},
+		{"calls failed", strconv.FormatInt(failed, 10)},
+		{"last call started", timestamp(last)},
+	}
+}
+
+func channelPage(kind string, id int64, name string, d *czpb.ChannelData) detailView {
+	fields := []field{
+		{"target", d.GetTarget()},
+		{"state", d.GetState().GetState().String()},
+	}
+	return detailView{
+		Title:  strings.TrimSpace(fmt.Sprintf("%s %d %s", kind, id, name)),
+		Fields: append(fields, callFields(d.GetCallsStarted(), d.GetCallsSucceeded(), d.GetCallsFailed(), d.GetLastCallStartedTimestamp())...),
+		Trace:  traceEvents(d.GetTrace()),
+	}
+}
+
+func traceEvents(t *czpb.ChannelTrace) []traceEvent {
+	var evs []traceEvent
+	for _, e := range t.GetEvents() {
+		evs = append(evs, traceEvent{timestamp(e.Timestamp), e.Severity.String(), e.Description})
+	}
+	return evs
+}
+
+func channelLinks(refs []*czpb.ChannelRef) []link {
+	var ls []link
+	for _, r := range refs {
+		ls = append(ls, link{fmt.Sprintf("/channelz/channel/%d", r.ChannelId), refText(r.ChannelId, r.Name)})
+	}
+	return ls
+}
+
+func subchannelLinks(refs []*czpb.SubchannelRef) []link {
+	var ls []link
+	for _, r := range refs {
+		ls = append(ls, link{fmt.Sprintf("/channelz/subchannel/%d", r.SubchannelId), refText(r.SubchannelId, r.Name)})
+	}
+	return ls
+}
+
+func socketLinks(refs []*czpb.SocketRef) []link {
+	var ls []link
+	for _, r := range refs {
+		ls = append(ls, link{fmt.Sprintf("/channelz/socket/%d", r.SocketId), refText(r.SocketId, r.Name)})
+	}
+	return ls
+}
+
+func refText(id int64, name string) string {
+	if name == "" {
+		return strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("%d %s", id, name)
+}
+
+func timestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ts.AsTime().Local().Format("2006-01-02 15:04:05.000")
+}
+
+func address(a *czpb.Address) string {
+	switch {
+	case a.GetTcpipAddress() != nil:
+		t := a.GetTcpipAddress()
+		return net.JoinHostPort(net.IP(t.IpAddress).String(), strconv.Itoa(int(t.Port)))
+	case a.GetUdsAddress() != nil:
+		return "unix:" + a.GetUdsAddress().Filename
+	case a.GetOtherAddress() != nil:
+		return a.GetOtherAddress().Name
+	}
+	return ""
+}
+
+func security(s *czpb.Security) string {
+	switch {
+	case s.GetTls() != nil:
+		t := s.GetTls()
+		if t.GetStandardName() != "" {
+			return "TLS " + t.GetStandardName()
+		}
+		return "TLS " + t.GetOtherName()
+	case s.GetOther() != nil:
+		return s.GetOther().Name
+	}
+	return "none"
+}
+
+func renderDetail(w http.ResponseWriter, p detailView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	detailTemplate.Execute(w, p)
+}
+
+var overviewTemplate = template.Must(template.New("overview").Parse(`<!DOCTYPE html>
+<html><head><title>channelz</title>` + style + `</head><body>
+<p><a href="/">admin</a> / channelz (<a href="?format=json">json</a>)</p>
+<h2>channels</h2>
+<table>
+<tr><th>id</th><th>target</th><th>state</th><th>started</th><th>succeeded</th><th>failed</th><th>last call</th></tr>
+{{range .Channels}}<tr><td><a href="/channelz/channel/{{.ID}}">{{.ID}}</a></td><td>{{.Target}}</td><td>{{.State}}</td><td>{{.Calls.Started}}</td><td>{{.Calls.Succeeded}}</td><td>{{.Calls.Failed}}</td><td>{{.Calls.Last}}</td></tr>
+{{end}}</table>
+<h2>servers</h2>
+<table>
+<tr><th>id</th><th>listening on</th><th>started</th><th>succeeded</th><th>failed</th><th>last call</th></tr>
+{{range .Servers}}<tr><td><a href="/channelz/server/{{.ID}}">{{.ID}}</a></td><td>{{range .Listen}}{{.}} {{end}}</td><td>{{.Calls.Started}}</td><td>{{.Calls.Succeeded}}</td><td>{{.Calls.Failed}}</td><td>{{.Calls.Last}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var detailTemplate = template.Must(template.New("detail").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Title}}</title>` + style + `</head><body>
+<p><a href="/">admin</a> / <a href="/channelz/">channelz</a> / {{.Title}} (<a href="?format=json">json</a>)</p>
+<h2>{{.Title}}</h2>
+<table>
+{{range .Fields}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{range .Groups}}{{if .Links}}<h3>{{.Title}}</h3>
+<ul>{{range .Links}}<li><a href="{{.Href}}">{{.Text}}</a></li>{{end}}</ul>
+{{end}}{{end}}
+{{if .Trace}}<h3>trace</h3>
+<table>
+<tr><th>time</th><th>severity</th><th>event</th></tr>
+{{range .Trace}}<tr><td>{{.Time}}</td><td>{{.Severity}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"flag"
+	"time"
+)
+
+// Flags are the command line flags enabling the admin endpoint.
+type Flags struct {
+	Addr   string
+	Linger time.Duration
+	fs     *flag.FlagSet
+}
+
+// Register adds the flags to fs, whose values the endpoint reports.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.Addr, "admin_addr", "", "address of the admin HTTP endpoint serving channelz, pprof and the process state, e.g. localhost:8080; off when empty")
+	fs.DurationVar(&f.Linger, "admin_linger", 0, "how long a client keeps the admin endpoint up after its calls are done")
+}
+
+// Start starts the endpoint. It returns nil when the endpoint is disabled.
+func (f *Flags) Start() (*Admin, error) {
+	if f.Addr == "" {
+		return nil, nil
+	}
+	a, err := New(f.fs)
+	if err != nil {
+		return nil, err
+	}
+	a.linger = f.Linger
+	if err := a.Start(f.Addr); err != nil {
+		a.Stop()
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TokenSource supplies the bearer token attached to outgoing RPCs.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// ReadTokenFile returns the token stored in path.
+func ReadTokenFile(path string) (StaticToken, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("auth: read token: %v", err)
+	}
+	return StaticToken(strings.TrimSpace(string(b))), nil
+}
+
+// TokenCredentials implements credentials.PerRPCCredentials and sends the
+// token of a TokenSource in the authorization metadata of every RPC.
+type TokenCredentials struct {
+	source     TokenSource
+	requireTLS bool
+}
+
+// NewTokenCredentials returns per-RPC credentials backed by source. When
+// requireTLS is set gRPC refuses to send the token over a plaintext
+// connection.
+func NewTokenCredentials(source TokenSource, requireTLS bool) *TokenCredentials {
+	return &TokenCredentials{source: source, requireTLS: requireTLS}
+}
+
+var _ credentials.PerRPCCredentials = (*TokenCredentials)(nil)
+
+func (c *TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{authorizationHeader: "Bearer " + token}, nil
+}
+
+func (c *TokenCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
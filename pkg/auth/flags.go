@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// ServerFlags are the command line flags enabling token authentication on a
+// server. Authentication stays off unless a key is configured.
+type ServerFlags struct {
+	HMACKeyFile      string
+	RSAPublicKeyFile string
+	KeyID            string
+	PolicyFile       string
+	Issuer           string
+	Audience         string
+	AllowNoExpiry    bool
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.HMACKeyFile, "jwt_hmac_key", "", "file holding the HMAC secret used to verify HS256 tokens")
+	fs.StringVar(&f.RSAPublicKeyFile, "jwt_rsa_public_key", "", "PEM file holding the RSA public key used to verify RS256 tokens")
+	fs.StringVar(&f.KeyID, "jwt_kid", "", "key id the -jwt_hmac_key and -jwt_rsa_public_key keys are registered under; tokens must carry it in their kid header")
+	fs.StringVar(&f.PolicyFile, "auth_policy", "", "JSON file mapping roles and scopes to the full method names they may call")
+	fs.StringVar(&f.Issuer, "jwt_issuer", "", "required token issuer")
+	fs.StringVar(&f.Audience, "jwt_audience", "", "required token audience")
+	fs.BoolVar(&f.AllowNoExpiry, "jwt_allow_no_expiry", false, "accept tokens without an exp claim; they never expire")
+}
+
+// Enabled reports whether a verification key was configured.
+func (f *ServerFlags) Enabled() bool {
+	return f.HMACKeyFile != "" || f.RSAPublicKeyFile != ""
+}
+
+// Authenticator builds the authenticator described by the flags. It returns
+// nil when authentication is disabled.
+func (f *ServerFlags) Authenticator() (*Authenticator, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	v := NewVerifier()
+	v.Issuer = f.Issuer
+	v.Audience = f.Audience
+	v.AllowNoExpiry = f.AllowNoExpiry
+	if f.HMACKeyFile != "" {
+		if err := v.LoadHMACKeyFile(f.KeyID, f.HMACKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if f.RSAPublicKeyFile != "" {
+		if err := v.LoadRSAPublicKeyFile(f.KeyID, f.RSAPublicKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	var p *Policy
+	if f.PolicyFile != "" {
+		var err error
+		if p, err = LoadPolicy(f.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+	return NewAuthenticator(v, p), nil
+}
+
+// ServerOptions returns the server options for the configured
+// authenticator, or none when authentication is disabled.
+func (f *ServerFlags) ServerOptions() ([]grpc.ServerOption, error) {
+	a, err := f.Authenticator()
+	if a == nil || err != nil {
+		return nil, err
+	}
+	return a.ServerOptions(), nil
+}
+
+// ClientFlags are the command line flags attaching a bearer token to every
+// RPC of a client. The token is either given directly or obtained from a
+// TokenService with the client credentials grant.
+type ClientFlags struct {
+	Token        string
+	TokenFile    string
+	TokenServer  string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	Audience     string
+}
+
+// Register adds the flags to fs.
+func (f *ClientFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Token, "token", "", "bearer token sent with every RPC")
+	fs.StringVar(&f.TokenFile, "token_file", "", "file holding the bearer token sent with every RPC")
+	fs.StringVar(&f.TokenServer, "token_server", "", "address of a TokenService to obtain tokens from with -client_id and -client_secret")
+	fs.StringVar(&f.ClientID, "client_id", "", "client id presented to -token_server")
+	fs.StringVar(&f.ClientSecret, "client_secret", "", "client secret presented to -token_server")
+	fs.StringVar(&f.Scopes, "scopes", "", "space separated scopes requested from -token_server")
+	fs.StringVar(&f.Audience, "audience", "", "audience requested from -token_server")
+}
+
+// DialOptions returns the dial options attaching the configured token. The
+// token server, if any, is dialed with tokenServerOpts; that connection stays
+// open for the life of the process. The token is allowed on plaintext
+// connections so that the insecure samples keep working.
+func (f *ClientFlags) DialOptions(tokenServerOpts ...grpc.DialOption) ([]grpc.DialOption, error) {
+	var source TokenSource
+	switch {
+	case f.Token != "":
+		source = StaticToken(f.Token)
+	case f.TokenFile != "":
+		t, err := ReadTokenFile(f.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		source = t
+	case f.TokenServer != "":
+		conn, err := grpc.Dial(f.TokenServer, tokenServerOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("auth: dial token server: %v", err)
+		}
+		source = NewClientCredentialsSource(conn, f.ClientID, f.ClientSecret, strings.Fields(f.Scopes), f.Audience)
+	default:
+		return nil, nil
+	}
+	return []grpc.DialOption{grpc.WithPerRPCCredentials(NewTokenCredentials(source, false))}, nil
+}
@@ -0,0 +1,49 @@
+package auth_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const method = "/ecommerce.OrderManagement/getOrder"
+
+func authorize(t *testing.T, a *auth.Authenticator, signer *auth.Signer) error {
+	t.Helper()
+	token, err := signer.Sign(&auth.Claims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	_, err = a.Authorize(ctx, method)
+	return err
+}
+
+func TestServerFlagsKeyID(t *testing.T) {
+	key := []byte("sekrit")
+	path := filepath.Join(t.TempDir(), "hmac.key")
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := auth.ServerFlags{HMACKeyFile: path, KeyID: "2021-01"}
+	a, err := f.Authenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authorize(t, a, auth.NewHMACSigner("2021-01", key)); err != nil {
+		t.Errorf("token with kid 2021-01 was rejected: %v", err)
+	}
+	for _, kid := range []string{"", "2020-12"} {
+		if err := authorize(t, a, auth.NewHMACSigner(kid, key)); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("token with kid %q returned %v, want Unauthenticated", kid, err)
+		}
+	}
+}
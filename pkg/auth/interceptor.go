@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "bearer "
+)
+
+type claimsKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying the caller's claims.
+func ContextWithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// ClaimsFromContext returns the verified claims of the caller, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// Authenticator validates the bearer token of every incoming RPC and checks
+// the caller's roles against a policy.
+type Authenticator struct {
+	verifier *Verifier
+	policy   *Policy
+}
+
+// NewAuthenticator returns an authenticator. With a nil policy any caller
+// holding a valid token is allowed.
+func NewAuthenticator(v *Verifier, p *Policy) *Authenticator {
+	return &Authenticator{verifier: v, policy: p}
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	vals := md.Get(authorizationHeader)
+	if len(vals) == 0 {
+		return "", false
+	}
+	v := vals[0]
+	if len(v) <= len(bearerPrefix) || !strings.EqualFold(v[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(v[len(bearerPrefix):]), true
+}
+
+// Authorize authenticates the caller of fullMethod and returns ctx extended
+// with its claims, or a status error with Unauthenticated or
+// PermissionDenied.
+func (a *Authenticator) Authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	if a.policy != nil && a.policy.IsPublic(fullMethod) {
+		return ctx, nil
+	}
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "missing bearer token")
+	}
+	claims, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	if a.policy != nil && !a.policy.Allowed(claims.Roles, claims.Scopes(), fullMethod) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", claims.Subject, fullMethod)
+	}
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// UnaryServerInterceptor authorizes every unary RPC.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.Authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authorizes every streaming RPC before the handler
+// runs.
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.Authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// ServerOptions returns the options installing both interceptors.
+func (a *Authenticator) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(a.StreamServerInterceptor()),
+	}
+}
+
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authServerStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,371 @@
+// Package auth implements bearer-token authentication and per-method
+// authorization for the ecommerce services. Tokens are JWTs verified locally
+// with HMAC secrets or RSA public keys read from disk.
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("auth: malformed token")
+	ErrBadSignature     = errors.New("auth: token signature is invalid")
+	ErrUnsupportedAlg   = errors.New("auth: unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("auth: no key to verify token")
+	ErrTokenExpired     = errors.New("auth: token is expired")
+	ErrMissingExpiry    = errors.New("auth: token has no expiry")
+	ErrTokenNotYetValid = errors.New("auth: token is not valid yet")
+	ErrBadIssuer        = errors.New("auth: token issuer is not accepted")
+	ErrBadAudience      = errors.New("auth: token audience is not accepted")
+)
+
+// Audience is the "aud" claim, which may be a single string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered JWT claims plus the ones used by the ecommerce
+// services for authorization.
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// Scopes splits the space separated scope claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+func hashFor(alg string) (crypto.Hash, bool) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+func hmacSum(h crypto.Hash, key []byte, data string) []byte {
+	var mac = hmac.New(sha256.New, key)
+	switch h {
+	case crypto.SHA384:
+		mac = hmac.New(sha512.New384, key)
+	case crypto.SHA512:
+		mac = hmac.New(sha512.New, key)
+	}
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func digest(h crypto.Hash, data string) []byte {
+	hh := h.New()
+	hh.Write([]byte(data))
+	return hh.Sum(nil)
+}
+
+var enc = base64.RawURLEncoding
+
+// Verifier checks JWT signatures and the time, issuer and audience claims.
+// HMAC and RSA keys may be registered under a key id; a key registered with
+// the empty id is used for tokens without a "kid" header.
+type Verifier struct {
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+
+	// Issuer, when set, must equal the "iss" claim.
+	Issuer string
+	// Audience, when set, must be contained in the "aud" claim.
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+	// AllowNoExpiry accepts tokens without an "exp" claim, which never
+	// expire. They are rejected by default.
+	AllowNoExpiry bool
+
+	now func() time.Time
+}
+
+// NewVerifier returns a verifier without keys.
+func NewVerifier() *Verifier {
+	return &Verifier{
+		hmacKeys: make(map[string][]byte),
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+		Leeway:   30 * time.Second,
+		now:      time.Now,
+	}
+}
+
+// AddHMACKey registers an HMAC secret for the HS256/384/512 algorithms.
+func (v *Verifier) AddHMACKey(kid string, key []byte) {
+	v.hmacKeys[kid] = key
+}
+
+// AddRSAKey registers an RSA public key for the RS256/384/512 algorithms.
+func (v *Verifier) AddRSAKey(kid string, key *rsa.PublicKey) {
+	v.rsaKeys[kid] = key
+}
+
+// LoadHMACKeyFile registers the contents of path, with surrounding white
+// space removed, as an HMAC secret.
+func (v *Verifier) LoadHMACKeyFile(kid, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("auth: read hmac key: %v", err)
+	}
+	key := []byte(strings.TrimSpace(string(b)))
+	if len(key) == 0 {
+		return fmt.Errorf("auth: hmac key file %s is empty", path)
+	}
+	v.AddHMACKey(kid, key)
+	return nil
+}
+
+// LoadRSAPublicKeyFile registers the RSA public key in the PEM file at path.
+// PKIX and PKCS#1 public keys as well as X.509 certificates are accepted.
+func (v *Verifier) LoadRSAPublicKeyFile(kid, path string) error {
+	key, err := ReadRSAPublicKey(path)
+	if err != nil {
+		return err
+	}
+	v.AddRSAKey(kid, key)
+	return nil
+}
+
+// ReadRSAPublicKey reads a PEM encoded RSA public key or certificate.
+func ReadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: read rsa key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("auth: no PEM data in %s", path)
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	default:
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := pub.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("auth: %s does not hold an RSA public key", path)
+}
+
+// ReadRSAPrivateKey reads a PEM encoded PKCS#1 or PKCS#8 RSA private key.
+func ReadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: read rsa key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("auth: no PEM data in %s", path)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("auth: %s does not hold an RSA private key", path)
+	}
+	return key, nil
+}
+
+// Verify checks the signature and claims of token and returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	hb, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var h header
+	if err := json.Unmarshal(hb, &h); err != nil || len(h.Alg) != 5 {
+		return nil, ErrUnsupportedAlg
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	hash, ok := hashFor(h.Alg)
+	if !ok {
+		return nil, ErrUnsupportedAlg
+	}
+	signed := parts[0] + "." + parts[1]
+
+	// The key type is chosen by the algorithm family, so an RSA public key can
+	// never be abused as an HMAC secret.
+	switch h.Alg[:2] {
+	case "HS":
+		key, ok := v.hmacKeys[h.Kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if !hmac.Equal(sig, hmacSum(hash, key, signed)) {
+			return nil, ErrBadSignature
+		}
+	case "RS":
+		key, ok := v.rsaKeys[h.Kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest(hash, signed), sig); err != nil {
+			return nil, ErrBadSignature
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+
+	cb, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var c Claims
+	if err := json.Unmarshal(cb, &c); err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.validate(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.now()
+	if c.ExpiresAt == 0 && !v.AllowNoExpiry {
+		return ErrMissingExpiry
+	}
+	if c.ExpiresAt != 0 && now.After(time.Unix(c.ExpiresAt, 0).Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return ErrBadIssuer
+	}
+	if v.Audience != "" && !c.Audience.Contains(v.Audience) {
+		return ErrBadAudience
+	}
+	return nil
+}
+
+// Signer creates signed JWTs. It is used by the token tooling and tests; the
+// services themselves only verify tokens.
+type Signer struct {
+	alg     string
+	kid     string
+	hmacKey []byte
+	rsaKey  *rsa.PrivateKey
+}
+
+// NewHMACSigner returns a signer using HS256 and key.
+func NewHMACSigner(kid string, key []byte) *Signer {
+	return &Signer{alg: "HS256", kid: kid, hmacKey: key}
+}
+
+// NewRSASigner returns a signer using RS256 and key.
+func NewRSASigner(kid string, key *rsa.PrivateKey) *Signer {
+	return &Signer{alg: "RS256", kid: kid, rsaKey: key}
+}
+
+// Sign encodes and signs claims.
+func (s *Signer) Sign(c *Claims) (string, error) {
+	hb, err := json.Marshal(header{Alg: s.alg, Typ: "JWT", Kid: s.kid})
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := enc.EncodeToString(hb) + "." + enc.EncodeToString(cb)
+	var sig []byte
+	if s.rsaKey != nil {
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest(crypto.SHA256, signed))
+		if err != nil {
+			return "", err
+		}
+	} else {
+		sig = hmacSum(crypto.SHA256, s.hmacKey, signed)
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+)
+
+// Policy maps roles and OAuth scopes to the full gRPC method names they may
+// call, e.g.
+//
+//	{
+//	  "public": ["/grpc.health.v1.Health/Check"],
+//	  "roles": {
+//	    "admin":  ["/ecommerce.OrderManagement/*", "/ecommerce.ProductInfo/*"],
+//	    "viewer": ["/ecommerce.OrderManagement/getOrder", "/ecommerce.OrderManagement/searchOrders"]
+//	  },
+//	  "scopes": {
+//	    "orders.write": ["/ecommerce.OrderManagement/addOrder"]
+//	  }
+//	}
+//
+// A method entry ending in "/*" matches every method of that service and a
+// lone "*" matches everything. Methods listed under "public" need no token.
+// The roles of a token are looked up in Roles and its scopes in Scopes only,
+// so a scope never passes for a role of the same name.
+type Policy struct {
+	Public []string            `json:"public,omitempty"`
+	Roles  map[string][]string `json:"roles"`
+	Scopes map[string][]string `json:"scopes,omitempty"`
+}
+
+// LoadPolicy reads a JSON policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: read policy: %v", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("auth: parse policy %s: %v", path, err)
+	}
+	for _, m := range p.Public {
+		if err := methodmatch.Validate(m); err != nil {
+			return nil, fmt.Errorf("auth: policy %s: public: %v", path, err)
+		}
+	}
+	for role, methods := range p.Roles {
+		for _, m := range methods {
+			if err := methodmatch.Validate(m); err != nil {
+				return nil, fmt.Errorf("auth: policy %s: role %q: %v", path, role, err)
+			}
+		}
+	}
+	for scope, methods := range p.Scopes {
+		for _, m := range methods {
+			if err := methodmatch.Validate(m); err != nil {
+				return nil, fmt.Errorf("auth: policy %s: scope %q: %v", path, scope, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// IsPublic reports whether fullMethod may be called without a token.
+func (p *Policy) IsPublic(fullMethod string) bool {
+	for _, m := range p.Public {
+		if methodmatch.Match(m, fullMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether any of roles or scopes grants access to
+// fullMethod.
+func (p *Policy) Allowed(roles, scopes []string, fullMethod string) bool {
+	return grants(p.Roles, roles, fullMethod) || grants(p.Scopes, scopes, fullMethod)
+}
+
+func grants(section map[string][]string, names []string, fullMethod string) bool {
+	for _, n := range names {
+		for _, m := range section[n] {
+			if methodmatch.Match(m, fullMethod) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tokenpb "github.com/eadydb/grpc-samples/pkg/token/proto"
+	"google.golang.org/grpc"
+)
+
+// ClientCredentialsSource is a TokenSource which obtains access tokens from a
+// TokenService with the client credentials grant. Tokens are cached and
+// refreshed shortly before they expire, so concurrent RPCs share one token
+// and never present an expired one.
+type ClientCredentialsSource struct {
+	client       tokenpb.TokenServiceClient
+	clientID     string
+	clientSecret string
+	scopes       []string
+	audience     string
+
+	// RefreshBefore is how long before expiry a token is replaced. Tokens
+	// that live shorter than twice this value are refreshed at half-life.
+	RefreshBefore time.Duration
+
+	mu       sync.Mutex
+	token    string
+	refresh  time.Time
+	expiry   time.Time
+	fetching chan struct{} // closed when the fetch in flight is done
+	now      func() time.Time
+}
+
+// NewClientCredentialsSource returns a source requesting tokens for
+// clientID over conn.
+func NewClientCredentialsSource(conn *grpc.ClientConn, clientID, clientSecret string, scopes []string, audience string) *ClientCredentialsSource {
+	return &ClientCredentialsSource{
+		client:        tokenpb.NewTokenServiceClient(conn),
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		scopes:        scopes,
+		audience:      audience,
+		RefreshBefore: 30 * time.Second,
+		now:           time.Now,
+	}
+}
+
+// Token returns the cached token, fetching a new one when it is due for
+// refresh. Only one call fetches at a time; the others keep using the cached
+// token while it is valid and otherwise wait for the fetch. If a refresh
+// fails while the cached token is still valid, the cached token is used and
+// the refresh is retried on the next call.
+func (s *ClientCredentialsSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	for {
+		now := s.now()
+		if s.token != "" && (now.Before(s.refresh) || s.fetching != nil && now.Before(s.expiry)) {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+		if s.fetching == nil {
+			break
+		}
+		fetching := s.fetching
+		s.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return "", fmt.Errorf("auth: fetch token for %s: %v", s.clientID, ctx.Err())
+		}
+		s.mu.Lock()
+	}
+	done := make(chan struct{})
+	s.fetching = done
+	s.mu.Unlock()
+
+	// The lifetime counts from before the request, so the token is never
+	// believed to live longer than it does.
+	start := s.now()
+	resp, err := s.client.IssueToken(ctx, &tokenpb.TokenRequest{
+		GrantType:    "client_credentials",
+		ClientId:     s.clientID,
+		ClientSecret: s.clientSecret,
+		Scopes:       s.scopes,
+		Audience:     s.audience,
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetching = nil
+	close(done)
+	if err != nil {
+		if s.token != "" && s.now().Before(s.expiry) {
+			return s.token, nil
+		}
+		return "", fmt.Errorf("auth: fetch token for %s: %v", s.clientID, err)
+	}
+
+	lifetime := time.Duration(resp.ExpiresIn) * time.Second
+	early := s.RefreshBefore
+	if lifetime < 2*early {
+		early = lifetime / 2
+	}
+	s.token = resp.AccessToken
+	s.expiry = start.Add(lifetime)
+	s.refresh = s.expiry.Add(-early)
+	return s.token, nil
+}
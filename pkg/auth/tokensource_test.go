@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	tokenpb "github.com/eadydb/grpc-samples/pkg/token/proto"
+	"google.golang.org/grpc"
+)
+
+// gatedTokenServer issues token-1, token-2, ... valid for a minute, each one
+// only once the test sends on release.
+type gatedTokenServer struct {
+	tokenpb.UnimplementedTokenServiceServer
+	calls   int32
+	release chan struct{}
+}
+
+func (s *gatedTokenServer) IssueToken(ctx context.Context, _ *tokenpb.TokenRequest) (*tokenpb.TokenResponse, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &tokenpb.TokenResponse{AccessToken: fmt.Sprintf("token-%d", n), ExpiresIn: 60}, nil
+}
+
+func TestClientCredentialsSourceSharesFetch(t *testing.T) {
+	srv := &gatedTokenServer{release: make(chan struct{})}
+	conn := grpctest.Start(t, func(s *grpc.Server) { tokenpb.RegisterTokenServiceServer(s, srv) })
+	src := NewClientCredentialsSource(conn, "order-client", "s3cret", nil, "")
+	now := time.Now()
+	src.now = func() time.Time { return now }
+	calls := func() int32 { return atomic.LoadInt32(&srv.calls) }
+
+	// Without a token every caller waits for the one fetch.
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok, err := src.Token(grpctest.Context(t))
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = tok
+		}(i)
+	}
+	grpctest.Wait(t, "the first fetch", func() bool { return calls() == 1 })
+	srv.release <- struct{}{}
+	wg.Wait()
+	for _, tok := range tokens {
+		if tok != "token-1" {
+			t.Errorf("got %q, want token-1", tok)
+		}
+	}
+
+	// Once the token is due for refresh, the caller fetching holds no lock:
+	// the others keep using the valid token meanwhile.
+	now = now.Add(45 * time.Second)
+	refreshed := make(chan string)
+	go func() {
+		tok, err := src.Token(grpctest.Context(t))
+		if err != nil {
+			t.Error(err)
+		}
+		refreshed <- tok
+	}()
+	grpctest.Wait(t, "the refresh", func() bool { return calls() == 2 })
+	if tok, err := src.Token(grpctest.Context(t)); tok != "token-1" || err != nil {
+		t.Errorf("Token during the refresh = %q, %v; want token-1", tok, err)
+	}
+	srv.release <- struct{}{}
+	if tok := <-refreshed; tok != "token-2" {
+		t.Errorf("refresh returned %q, want token-2", tok)
+	}
+	if n := calls(); n != 2 {
+		t.Errorf("token server got %d calls, want 2", n)
+	}
+}
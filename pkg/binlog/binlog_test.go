@@ -0,0 +1,427 @@
+package binlog_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/binlog"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// memSink keeps the entries in memory.
+type memSink struct {
+	mu      sync.Mutex
+	entries []*pb.GrpcLogEntry
+}
+
+func (s *memSink) Write(e *pb.GrpcLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *memSink) Close() error { return nil }
+
+// calls returns the entries of each call by id.
+func (s *memSink) calls() map[uint64][]*pb.GrpcLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make(map[uint64][]*pb.GrpcLogEntry)
+	for _, e := range s.entries {
+		calls[e.CallId] = append(calls[e.CallId], e)
+	}
+	return calls
+}
+
+var rules = []fakeserver.Rule{
+	{
+		Method:    "getOrder",
+		Match:     fakeserver.Message(&wrappers.StringValue{Value: "202"}),
+		Header:    map[string]string{"location": "guangzhou"},
+		Trailer:   map[string]string{"served-by": "backend"},
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.Order{Id: "202", Destination: "Paris"})}},
+	},
+	{
+		Method: "getOrder",
+		Error: &fakeserver.Error{
+			Code:    codes.NotFound,
+			Message: "no such order",
+			Details: []json.RawMessage{json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.ResourceInfo", "resourceName": "999"}`)},
+		},
+	},
+	{
+		Method: "searchOrders",
+		Responses: []fakeserver.Response{
+			{Message: fakeserver.Message(&opb.Order{Id: "202"})},
+			{Message: fakeserver.Message(&opb.Order{Id: "204"})},
+		},
+	},
+	{
+		Method:    "processOrders",
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.CombinedShipment{Id: "cmb"})}},
+	},
+}
+
+// start serves the fake with a server logger and returns a client with a
+// client logger.
+func start(t *testing.T, c binlog.Config) (opb.OrderManagementClient, *memSink, *memSink) {
+	fake := fakeserver.New()
+	if err := fake.Add(rules...); err != nil {
+		t.Fatal(err)
+	}
+	server, client := new(memSink), new(memSink)
+	s := grpctest.NewServer(t, fake.Register, binlog.New(server, c).ServerOptions()...)
+	conn := s.Dial(t, binlog.New(client, c).DialOptions()...)
+	return opb.NewOrderManagementClient(conn), server, client
+}
+
+func types(entries []*pb.GrpcLogEntry) []pb.GrpcLogEntry_EventType {
+	var ts []pb.GrpcLogEntry_EventType
+	for _, e := range entries {
+		ts = append(ts, e.Type)
+	}
+	return ts
+}
+
+func value(md *pb.Metadata, key string) string {
+	for _, e := range md.GetEntry() {
+		if e.Key == key {
+			return string(e.Value)
+		}
+	}
+	return ""
+}
+
+const (
+	clientHeader  = pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER
+	serverHeader  = pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER
+	clientMessage = pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE
+	serverMessage = pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE
+	halfClose     = pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HALF_CLOSE
+	trailer       = pb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER
+	cancel        = pb.GrpcLogEntry_EVENT_TYPE_CANCEL
+)
+
+func TestUnaryCall(t *testing.T) {
+	client, server, clientLog := start(t, binlog.Config{})
+	ctx := metadata.AppendToOutgoingContext(grpctest.Context(t), "kn", "vn")
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "202"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pb.GrpcLogEntry_EventType{clientHeader, clientMessage, halfClose, serverHeader, serverMessage, trailer}
+	for side, sink := range map[string]*memSink{"server": server, "client": clientLog} {
+		entries := sink.calls()[1]
+		if got := types(entries); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s logged %v, want %v", side, got, want)
+		}
+		for i, e := range entries {
+			if e.SequenceIdWithinCall != uint64(i+1) {
+				t.Errorf("%s entry %d has sequence id %d", side, i, e.SequenceIdWithinCall)
+			}
+		}
+		h := entries[0].GetClientHeader()
+		if h.MethodName != "/ecommerce.OrderManagement/getOrder" || value(h.Metadata, "kn") != "vn" || h.Timeout == nil {
+			t.Errorf("%s logged client header %v", side, h)
+		}
+		if value(h.Metadata, "content-type") != "" || value(h.Metadata, "user-agent") != "" {
+			t.Errorf("%s logged the metadata grpc sets: %v", side, h.Metadata)
+		}
+		req := new(wrappers.StringValue)
+		if err := proto.Unmarshal(entries[1].GetMessage().Data, req); err != nil || req.Value != "202" {
+			t.Errorf("%s logged request %v, %v", side, req, err)
+		}
+		if got := value(entries[3].GetServerHeader().Metadata, "location"); got != "guangzhou" {
+			t.Errorf("%s logged header location %q", side, got)
+		}
+		order := new(opb.Order)
+		if err := proto.Unmarshal(entries[4].GetMessage().Data, order); err != nil || order.Destination != "Paris" {
+			t.Errorf("%s logged response %v, %v", side, order, err)
+		}
+		tr := entries[5].GetTrailer()
+		if codes.Code(tr.StatusCode) != codes.OK || value(tr.Metadata, "served-by") != "backend" {
+			t.Errorf("%s logged trailer %v", side, tr)
+		}
+	}
+	if server.calls()[1][0].Peer == nil {
+		t.Errorf("server did not log the peer")
+	}
+	if clientLog.calls()[1][3].Peer == nil {
+		t.Errorf("client did not log the peer")
+	}
+}
+
+func TestFailedCall(t *testing.T) {
+	client, server, clientLog := start(t, binlog.Config{})
+	if _, err := client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "999"}); err == nil {
+		t.Fatal("GetOrder of an unknown order succeeded")
+	}
+
+	// A failed unary call sends only trailers.
+	want := []pb.GrpcLogEntry_EventType{clientHeader, clientMessage, halfClose, trailer}
+	for side, sink := range map[string]*memSink{"server": server, "client": clientLog} {
+		entries := sink.calls()[1]
+		if got := types(entries); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s logged %v, want %v", side, got, want)
+		}
+		tr := entries[3].GetTrailer()
+		if codes.Code(tr.StatusCode) != codes.NotFound || tr.StatusMessage != "no such order" {
+			t.Errorf("%s logged status %d %q", side, tr.StatusCode, tr.StatusMessage)
+		}
+		st := new(spb.Status)
+		if err := proto.Unmarshal(tr.StatusDetails, st); err != nil || len(st.Details) != 1 {
+			t.Errorf("%s logged status details %v, %v", side, st, err)
+		}
+	}
+}
+
+func TestStreamingCalls(t *testing.T) {
+	client, server, clientLog := start(t, binlog.Config{})
+	search, err := client.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := search.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	process, err := client.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"201", "202"} {
+		if err := process.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := process.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	process.CloseSend()
+	if _, err := process.Recv(); err != io.EOF {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+
+	wantSearch := []pb.GrpcLogEntry_EventType{clientHeader, clientMessage, halfClose, serverHeader, serverMessage, serverMessage, trailer}
+	wantProcess := []pb.GrpcLogEntry_EventType{clientHeader, clientMessage, serverHeader, serverMessage, clientMessage, serverMessage, halfClose, trailer}
+	for side, sink := range map[string]*memSink{"server": server, "client": clientLog} {
+		calls := sink.calls()
+		if got := types(calls[1]); !reflect.DeepEqual(got, wantSearch) {
+			t.Errorf("%s logged searchOrders as %v, want %v", side, got, wantSearch)
+		}
+		if got := types(calls[2]); !reflect.DeepEqual(got, wantProcess) {
+			t.Errorf("%s logged processOrders as %v, want %v", side, got, wantProcess)
+		}
+	}
+}
+
+func TestCancelledCall(t *testing.T) {
+	client, _, clientLog := start(t, binlog.Config{})
+	ctx, cancelCall := context.WithCancel(grpctest.Context(t))
+	search, err := client.SearchOrders(ctx, &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := search.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	cancelCall()
+	for {
+		if _, err := search.Recv(); err != nil {
+			break
+		}
+	}
+	entries := clientLog.calls()[1]
+	if last := entries[len(entries)-1].Type; last != cancel && last != trailer {
+		t.Errorf("cancelled call ended with %v", last)
+	}
+}
+
+func TestFilterAndTruncation(t *testing.T) {
+	filter, err := binlog.ParseFilter("/ecommerce.OrderManagement/*,-/ecommerce.OrderManagement/searchOrders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server, _ := start(t, binlog.Config{Filter: filter, MaxHeaderBytes: 4, MaxMessageBytes: 3})
+	search, err := client.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := search.Recv(); err != nil {
+			break
+		}
+	}
+	ctx := metadata.AppendToOutgoingContext(grpctest.Context(t), "kn", "a long value")
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "202"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := server.calls()
+	if len(calls) != 1 {
+		t.Fatalf("logged %d calls, want only getOrder", len(calls))
+	}
+	for _, entries := range calls {
+		h := entries[0]
+		if h.GetClientHeader().MethodName != "/ecommerce.OrderManagement/getOrder" || !h.PayloadTruncated || value(h.GetClientHeader().Metadata, "kn") != "" {
+			t.Errorf("logged client header %v", h)
+		}
+		m := entries[1]
+		if !m.PayloadTruncated || len(m.GetMessage().Data) != 3 || m.GetMessage().Length != 5 {
+			t.Errorf("logged request %v", m)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		method string
+		want   bool
+	}{
+		{"*", "/ecommerce.ProductInfo/getProduct", true},
+		{"", "/ecommerce.ProductInfo/getProduct", false},
+		{"/ecommerce.ProductInfo/*", "/ecommerce.ProductInfo/getProduct", true},
+		{"/ecommerce.ProductInfo/*", "/ecommerce.OrderManagement/getOrder", false},
+		{"/ecommerce.ProductInfo/getProduct", "/ecommerce.ProductInfo/addProduct", false},
+		{"*,-/grpc.health.v1.Health/*", "/grpc.health.v1.Health/Check", false},
+		{"*,-/grpc.health.v1.Health/*", "/ecommerce.OrderManagement/getOrder", true},
+	} {
+		f, err := binlog.ParseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tc.filter, err)
+			continue
+		}
+		if got := f.Match(tc.method); got != tc.want {
+			t.Errorf("%q matches %s: %v, want %v", tc.filter, tc.method, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"getOrder", "/ecommerce.ProductInfo", "/ecommerce.*/getProduct", "/ecommerce.ProductInfo/get*"} {
+		if _, err := binlog.ParseFilter(bad); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.binlog")
+	sink, err := binlog.NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		e := &pb.GrpcLogEntry{CallId: uint64(i), Type: clientMessage, Payload: &pb.GrpcLogEntry_Message{Message: &pb.Message{Data: make([]byte, 20)}}}
+		if err := sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, _ := f.Stat()
+		if fi.Size() > 200 {
+			t.Errorf("%s has %d bytes, want at most 200", p, fi.Size())
+		}
+		r := binlog.NewReader(f)
+		for {
+			e, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, e.CallId)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 2 rotated files: %v", err)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] != ids[i-1]+1 {
+			t.Fatalf("read call ids %v, want consecutive ids", ids)
+		}
+	}
+	if ids[len(ids)-1] != 20 {
+		t.Errorf("last entry has call id %d, want 20", ids[len(ids)-1])
+	}
+}
+
+func TestFileSinkKeepsFileWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.binlog")
+	// A non-empty directory in the way of path.1 makes every rotation fail.
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := binlog.NewFileSink(path, 200, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(from, to int) {
+		for i := from; i <= to; i++ {
+			e := &pb.GrpcLogEntry{CallId: uint64(i), Type: clientMessage, Payload: &pb.GrpcLogEntry_Message{Message: &pb.Message{Data: make([]byte, 20)}}}
+			if err := sink.Write(e); err != nil {
+				t.Fatalf("Write %d: %v", i, err)
+			}
+		}
+	}
+	write(1, 20)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := binlog.NewReader(f)
+	n := 0
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("%s holds %d entries, want all 20", path, n)
+	}
+
+	// Rotation resumes once the way is clear.
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(21, 40)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path + ".1"); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("no file was rotated after the failure: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package binlog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+)
+
+// Filter selects methods by full method name.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+// ParseFilter parses a comma separated list of full method names,
+// "/package.Service/*" wildcards or "*". A pattern starting with "-"
+// excludes the methods it matches instead, e.g.
+// "/ecommerce.OrderManagement/*,-/ecommerce.OrderManagement/searchOrders".
+func ParseFilter(s string) (*Filter, error) {
+	f := new(Filter)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		list := &f.include
+		if strings.HasPrefix(p, "-") {
+			p, list = p[1:], &f.exclude
+		}
+		if err := methodmatch.Validate(p); err != nil {
+			return nil, fmt.Errorf("binlog: %v", err)
+		}
+		*list = append(*list, p)
+	}
+	return f, nil
+}
+
+// Match reports whether fullMethod matches an included pattern and no
+// excluded one. A nil filter matches every method.
+func (f *Filter) Match(fullMethod string) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.exclude {
+		if methodmatch.Match(p, fullMethod) {
+			return false
+		}
+	}
+	for _, p := range f.include {
+		if methodmatch.Match(p, fullMethod) {
+			return true
+		}
+	}
+	return false
+}
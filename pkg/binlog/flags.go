@@ -0,0 +1,41 @@
+package binlog
+
+import "flag"
+
+// Flags are the command line flags enabling binary logging to a rotating
+// file.
+type Flags struct {
+	File            string
+	Filter          string
+	MaxHeaderBytes  int
+	MaxMessageBytes int
+	RotateBytes     int64
+	Keep            int
+}
+
+// Register adds the flags to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.File, "binlog_file", "", "write a binary log of the calls to this file; empty disables binary logging")
+	fs.StringVar(&f.Filter, "binlog_filter", "*", "comma separated methods to log: /package.Service/Method, /package.Service/* or *, excluded with a leading -")
+	fs.IntVar(&f.MaxHeaderBytes, "binlog_max_header_bytes", 0, "metadata bytes logged per header or trailer; 0 logs all")
+	fs.IntVar(&f.MaxMessageBytes, "binlog_max_message_bytes", 0, "bytes logged per message; 0 logs whole messages")
+	fs.Int64Var(&f.RotateBytes, "binlog_rotate_bytes", 64<<20, "size at which the binary log is rotated; 0 never rotates")
+	fs.IntVar(&f.Keep, "binlog_keep", 5, "rotated binary logs kept")
+}
+
+// Logger returns the logger the flags configure, or nil when binary logging
+// is disabled.
+func (f *Flags) Logger() (*Logger, error) {
+	if f.File == "" {
+		return nil, nil
+	}
+	filter, err := ParseFilter(f.Filter)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewFileSink(f.File, f.RotateBytes, f.Keep)
+	if err != nil {
+		return nil, err
+	}
+	return New(sink, Config{Filter: filter, MaxHeaderBytes: f.MaxHeaderBytes, MaxMessageBytes: f.MaxMessageBytes}), nil
+}
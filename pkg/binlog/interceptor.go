@@ -0,0 +1,361 @@
+package binlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ServerOptions returns the options logging the calls a server receives.
+// Put them first, so the log shows the calls as they went over the wire.
+// A nil Logger returns none.
+func (l *Logger) ServerOptions() []grpc.ServerOption {
+	if l == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.unaryServer),
+		grpc.ChainStreamInterceptor(l.streamServer),
+	}
+}
+
+// DialOptions returns the options logging the calls a client makes. A nil
+// Logger returns none.
+func (l *Logger) DialOptions() []grpc.DialOption {
+	if l == nil {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(l.unaryClient),
+		grpc.WithChainStreamInterceptor(l.streamClient),
+	}
+}
+
+func peerAddr(ctx context.Context) net.Addr {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr
+	}
+	return nil
+}
+
+func authority(md metadata.MD) string {
+	if a := md.Get(":authority"); len(a) > 0 {
+		return a[0]
+	}
+	return ""
+}
+
+// cancelled reports whether a call ended with err because ctx was
+// cancelled, which is logged as a cancellation instead of a trailer.
+func cancelled(ctx context.Context, err error) bool {
+	return status.Code(err) == codes.Canceled && errors.Is(ctx.Err(), context.Canceled)
+}
+
+func (l *Logger) startServerCall(ctx context.Context, fullMethod string) *call {
+	c := l.newCall(fullMethod, pb.GrpcLogEntry_LOGGER_SERVER)
+	if c != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		c.clientHeader(ctx, md, fullMethod, authority(md), peerAddr(ctx))
+	}
+	return c
+}
+
+func (c *call) endServerCall(ctx context.Context, err error, trailer metadata.MD) {
+	if cancelled(ctx, err) {
+		c.cancel()
+		return
+	}
+	c.trailer(err, trailer, nil)
+}
+
+// serverHeaders tracks the header and trailer a server handler sets, to
+// log the header when it is sent and the trailer at the end.
+type serverHeaders struct {
+	call *call
+
+	mu      sync.Mutex
+	header  metadata.MD
+	trailer metadata.MD
+	sent    bool
+}
+
+func (h *serverHeaders) set(md metadata.MD) {
+	h.mu.Lock()
+	h.header = metadata.Join(h.header, md)
+	h.mu.Unlock()
+}
+
+func (h *serverHeaders) setTrailer(md metadata.MD) {
+	h.mu.Lock()
+	h.trailer = metadata.Join(h.trailer, md)
+	h.mu.Unlock()
+}
+
+// send logs the header, with md, unless it was logged already.
+func (h *serverHeaders) send(md metadata.MD) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sent {
+		return
+	}
+	h.sent = true
+	h.call.serverHeader(metadata.Join(h.header, md), nil)
+}
+
+func (h *serverHeaders) trailers() metadata.MD {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.trailer
+}
+
+// transportStream sees the header and trailer a unary handler sets with
+// grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer.
+type transportStream struct {
+	grpc.ServerTransportStream
+	headers *serverHeaders
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SetHeader(md)
+	if err == nil {
+		s.headers.set(md)
+	}
+	return err
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SendHeader(md)
+	if err == nil {
+		s.headers.send(md)
+	}
+	return err
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	err := s.ServerTransportStream.SetTrailer(md)
+	if err == nil {
+		s.headers.setTrailer(md)
+	}
+	return err
+}
+
+func (l *Logger) unaryServer(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	c := l.startServerCall(ctx, info.FullMethod)
+	if c == nil {
+		return handler(ctx, req)
+	}
+	c.message(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, req)
+	c.halfClose()
+
+	headers := &serverHeaders{call: c}
+	if ts := grpc.ServerTransportStreamFromContext(ctx); ts != nil {
+		ctx = grpc.NewContextWithServerTransportStream(ctx, &transportStream{ServerTransportStream: ts, headers: headers})
+	}
+	resp, err := handler(ctx, req)
+	if err == nil {
+		// The response goes out after the header; a failed call sends
+		// only trailers unless the handler sent the header itself.
+		headers.send(nil)
+		c.message(pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, resp)
+	}
+	c.endServerCall(ctx, err, headers.trailers())
+	return resp, err
+}
+
+// serverStream logs what a streaming handler sends and receives.
+type serverStream struct {
+	grpc.ServerStream
+	call          *call
+	headers       *serverHeaders
+	clientStreams bool
+}
+
+func (s *serverStream) SetHeader(md metadata.MD) error {
+	err := s.ServerStream.SetHeader(md)
+	if err == nil {
+		s.headers.set(md)
+	}
+	return err
+}
+
+func (s *serverStream) SendHeader(md metadata.MD) error {
+	err := s.ServerStream.SendHeader(md)
+	if err == nil {
+		s.headers.send(md)
+	}
+	return err
+}
+
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(md)
+	s.headers.setTrailer(md)
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.headers.send(nil)
+		s.call.message(pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, m)
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	switch err {
+	case nil:
+		s.call.message(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, m)
+		if !s.clientStreams {
+			// The handler of a server streaming call reads its single
+			// request and never waits for the end of the requests.
+			s.call.halfClose()
+		}
+	case io.EOF:
+		s.call.halfClose()
+	}
+	return err
+}
+
+func (l *Logger) streamServer(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := ss.Context()
+	c := l.startServerCall(ctx, info.FullMethod)
+	if c == nil {
+		return handler(srv, ss)
+	}
+	s := &serverStream{ServerStream: ss, call: c, headers: &serverHeaders{call: c}, clientStreams: info.IsClientStream}
+	err := handler(srv, s)
+	c.endServerCall(ctx, err, s.headers.trailers())
+	return err
+}
+
+func (l *Logger) unaryClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	c := l.newCall(method, pb.GrpcLogEntry_LOGGER_CLIENT)
+	if c == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.clientHeader(ctx, md, method, cc.Target(), nil)
+	c.message(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, req)
+	c.halfClose()
+
+	var header, trailer metadata.MD
+	var p peer.Peer
+	opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(&p))
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	// The peer goes with the first entry from the server.
+	trailerPeer := p.Addr
+	if err == nil || header.Len() > 0 {
+		c.serverHeader(header, p.Addr)
+		trailerPeer = nil
+	}
+	if err == nil {
+		c.message(pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, reply)
+	}
+	if cancelled(ctx, err) {
+		c.cancel()
+		return err
+	}
+	c.trailer(err, trailer, trailerPeer)
+	return err
+}
+
+// clientStream logs what a client stream sends and receives.
+type clientStream struct {
+	grpc.ClientStream
+	ctx           context.Context
+	call          *call
+	serverStreams bool
+
+	headerOnce sync.Once
+	endOnce    sync.Once
+}
+
+// logHeader logs the header of the server once it has arrived; a call
+// failing with only trailers has none.
+func (s *clientStream) logHeader(received bool) {
+	s.headerOnce.Do(func() {
+		h, err := s.ClientStream.Header()
+		if err == nil && (received || h.Len() > 0) {
+			s.call.serverHeader(h, nil)
+		}
+	})
+}
+
+func (s *clientStream) end(err error) {
+	s.endOnce.Do(func() {
+		if err == io.EOF {
+			err = nil
+		}
+		if cancelled(s.ctx, err) {
+			s.call.cancel()
+			return
+		}
+		s.call.trailer(err, s.ClientStream.Trailer(), nil)
+	})
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	h, err := s.ClientStream.Header()
+	if err == nil {
+		s.logHeader(false)
+	}
+	return h, err
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.call.message(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE, m)
+	}
+	return err
+}
+
+func (s *clientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	s.call.halfClose()
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.logHeader(err == nil)
+	if err != nil {
+		s.end(err)
+		return err
+	}
+	s.call.message(pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE, m)
+	if !s.serverStreams {
+		// The single response of a client streaming call is only
+		// returned once the status has arrived.
+		s.end(nil)
+	}
+	return nil
+}
+
+func (l *Logger) streamClient(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	c := l.newCall(method, pb.GrpcLogEntry_LOGGER_CLIENT)
+	if c == nil {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.clientHeader(ctx, md, method, cc.Target(), nil)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		if cancelled(ctx, err) {
+			c.cancel()
+		} else {
+			c.trailer(err, nil, nil)
+		}
+		return nil, err
+	}
+	return &clientStream{ClientStream: cs, ctx: ctx, call: c, serverStreams: desc.ServerStreams}, nil
+}
@@ -0,0 +1,197 @@
+// Package binlog writes gRPC binary logs: every header, message, half close,
+// trailer and cancellation of the selected calls, as GrpcLogEntry protos of
+// the gRPC binary logging format, to a Sink such as a rotating FileSink.
+//
+// The binary logging built into grpc is enabled only by an environment
+// variable read at start up and logs to one process wide sink, so a Logger
+// does the same work in interceptors instead, configured like the other
+// server and client options.
+package binlog
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Config selects what a Logger logs.
+type Config struct {
+	// Filter selects the methods logged; nil logs every method.
+	Filter *Filter
+	// MaxHeaderBytes and MaxMessageBytes limit the metadata and message
+	// bytes logged in an entry, marking the entry truncated when they cut
+	// it short. Zero means no limit.
+	MaxHeaderBytes  int
+	MaxMessageBytes int
+}
+
+// Logger logs calls to a Sink.
+type Logger struct {
+	sink   Sink
+	config Config
+	ids    uint64
+}
+
+// New returns a logger writing to sink.
+func New(sink Sink, c Config) *Logger {
+	return &Logger{sink: sink, config: c}
+}
+
+// Close closes the sink.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+// call logs the entries of one call.
+type call struct {
+	l    *Logger
+	id   uint64
+	seq  uint64
+	side pb.GrpcLogEntry_Logger
+}
+
+// newCall returns the logger of a call of fullMethod, nil if the method is
+// not logged.
+func (l *Logger) newCall(fullMethod string, side pb.GrpcLogEntry_Logger) *call {
+	if !l.config.Filter.Match(fullMethod) {
+		return nil
+	}
+	return &call{l: l, id: atomic.AddUint64(&l.ids, 1), side: side}
+}
+
+func (c *call) log(e *pb.GrpcLogEntry) {
+	e.Timestamp = timestamppb.Now()
+	e.CallId = c.id
+	e.SequenceIdWithinCall = atomic.AddUint64(&c.seq, 1)
+	e.Logger = c.side
+	if err := c.l.sink.Write(e); err != nil {
+		logging.Errorf("binlog: call %d: %v", c.id, err)
+	}
+}
+
+func (c *call) clientHeader(ctx context.Context, md metadata.MD, method, authority string, peer net.Addr) {
+	h := &pb.ClientHeader{MethodName: method, Authority: authority}
+	var truncated bool
+	h.Metadata, truncated = c.metadata(md)
+	if d, ok := ctx.Deadline(); ok {
+		h.Timeout = durationpb.New(time.Until(d))
+	}
+	c.log(&pb.GrpcLogEntry{
+		Type:             pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER,
+		Payload:          &pb.GrpcLogEntry_ClientHeader{ClientHeader: h},
+		PayloadTruncated: truncated,
+		Peer:             address(peer),
+	})
+}
+
+func (c *call) serverHeader(md metadata.MD, peer net.Addr) {
+	m, truncated := c.metadata(md)
+	c.log(&pb.GrpcLogEntry{
+		Type:             pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER,
+		Payload:          &pb.GrpcLogEntry_ServerHeader{ServerHeader: &pb.ServerHeader{Metadata: m}},
+		PayloadTruncated: truncated,
+		Peer:             address(peer),
+	})
+}
+
+func (c *call) message(t pb.GrpcLogEntry_EventType, m interface{}) {
+	var data []byte
+	if pm, ok := m.(proto.Message); ok {
+		data, _ = proto.Marshal(pm)
+	}
+	msg := &pb.Message{Length: uint32(len(data)), Data: data}
+	truncated := false
+	if max := c.l.config.MaxMessageBytes; max > 0 && len(data) > max {
+		msg.Data, truncated = data[:max], true
+	}
+	c.log(&pb.GrpcLogEntry{
+		Type:             t,
+		Payload:          &pb.GrpcLogEntry_Message{Message: msg},
+		PayloadTruncated: truncated,
+	})
+}
+
+func (c *call) halfClose() {
+	c.log(&pb.GrpcLogEntry{Type: pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HALF_CLOSE})
+}
+
+func (c *call) trailer(err error, md metadata.MD, peer net.Addr) {
+	st := status.Convert(err)
+	t := &pb.Trailer{StatusCode: uint32(st.Code()), StatusMessage: st.Message()}
+	if p := st.Proto(); len(p.GetDetails()) > 0 {
+		t.StatusDetails, _ = proto.Marshal(p)
+	}
+	var truncated bool
+	t.Metadata, truncated = c.metadata(md)
+	c.log(&pb.GrpcLogEntry{
+		Type:             pb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+		Payload:          &pb.GrpcLogEntry_Trailer{Trailer: t},
+		PayloadTruncated: truncated,
+		Peer:             address(peer),
+	})
+}
+
+func (c *call) cancel() {
+	c.log(&pb.GrpcLogEntry{Type: pb.GrpcLogEntry_EVENT_TYPE_CANCEL})
+}
+
+// metadata converts md, leaving out the keys grpc sets itself as the
+// binary logging of grpc does, and reports whether MaxHeaderBytes cut it
+// short.
+func (c *call) metadata(md metadata.MD) (*pb.Metadata, bool) {
+	m := new(pb.Metadata)
+	size := 0
+	for k, vals := range md {
+		if omitKey(k) {
+			continue
+		}
+		for _, v := range vals {
+			size += len(k) + len(v)
+			if max := c.l.config.MaxHeaderBytes; max > 0 && size > max {
+				return m, true
+			}
+			m.Entry = append(m.Entry, &pb.MetadataEntry{Key: k, Value: []byte(v)})
+		}
+	}
+	return m, false
+}
+
+func omitKey(k string) bool {
+	switch k {
+	case "lb-token", ":path", ":authority", "content-encoding", "content-type", "user-agent", "te":
+		return true
+	case "grpc-trace-bin":
+		return false
+	}
+	return strings.HasPrefix(k, "grpc-")
+}
+
+func address(a net.Addr) *pb.Address {
+	if a == nil {
+		return nil
+	}
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		t := pb.Address_TYPE_IPV6
+		if a.IP.To4() != nil {
+			t = pb.Address_TYPE_IPV4
+		}
+		return &pb.Address{Type: t, Address: a.IP.String(), IpPort: uint32(a.Port)}
+	case *net.UnixAddr:
+		return &pb.Address{Type: pb.Address_TYPE_UNIX, Address: a.Name}
+	}
+	return &pb.Address{Type: pb.Address_TYPE_UNKNOWN, Address: a.String()}
+}
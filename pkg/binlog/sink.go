@@ -0,0 +1,174 @@
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sink receives the log entries. It is the Sink of the grpc binarylog
+// package, so either side can use the other's sinks.
+type Sink interface {
+	// Write writes an entry; it is called concurrently.
+	Write(*pb.GrpcLogEntry) error
+	Close() error
+}
+
+// FileSink writes entries to a file in the format of the sinks of gRPC:
+// each entry is a 4 byte big endian length followed by the marshalled
+// GrpcLogEntry. Once the file would grow past the size limit it is renamed
+// to path.1, path.1 to path.2 and so on, and a new file is started. If that
+// fails, the entries go on to the current file.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64 // bytes written since the file was started or its rotation failed
+	failing bool  // the last rotation failed and was reported
+}
+
+// NewFileSink appends to the file at path, rotating it at maxBytes and
+// keeping keep rotated files. A maxBytes of zero never rotates.
+func NewFileSink(path string, maxBytes int64, keep int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, keep: keep}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("binlog: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("binlog: %v", err)
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+// Write appends e to the file.
+func (s *FileSink) Write(e *pb.GrpcLogEntry) error {
+	b, err := proto.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("binlog: %v", err)
+	}
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return fmt.Errorf("binlog: sink closed")
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		s.rotate()
+	}
+	n, err := s.f.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("binlog: %v", err)
+	}
+	return nil
+}
+
+// rotate moves the current file out of the way and starts a new one. If
+// that fails, the current file is kept and rotation is retried once it has
+// grown by another maxBytes; the error is logged the first time only.
+func (s *FileSink) rotate() {
+	if err := s.startFile(); err != nil {
+		if !s.failing {
+			logging.Errorf("%v; writing on to the current file", err)
+			s.failing = true
+		}
+		s.size = 0
+		return
+	}
+	if s.failing {
+		logging.Infof("binlog: rotated %s again", s.path)
+		s.failing = false
+	}
+}
+
+func (s *FileSink) startFile() error {
+	if s.keep <= 0 {
+		if err := s.f.Truncate(0); err != nil {
+			return fmt.Errorf("binlog: rotate %s: %v", s.path, err)
+		}
+		s.size = 0
+		return nil
+	}
+	for i := s.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("binlog: rotate %s: %v", s.path, err)
+	}
+	old := s.f
+	if err := s.open(); err != nil {
+		// Give the current file its name back.
+		os.Rename(s.path+".1", s.path)
+		return err
+	}
+	if err := old.Close(); err != nil {
+		logging.Warnf("binlog: close rotated file: %v", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Reader reads the entries written by a FileSink.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a reader of the entries in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry, or io.EOF after the last one.
+func (r *Reader) Next() (*pb.GrpcLogEntry, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("binlog: truncated entry length")
+		}
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("binlog: truncated entry: %v", err)
+	}
+	e := new(pb.GrpcLogEntry)
+	if err := proto.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("binlog: %v", err)
+	}
+	return e, nil
+}
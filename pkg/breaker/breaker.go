@@ -0,0 +1,231 @@
+// Package breaker stops clients from hammering a failing backend. Every
+// target and method gets its own circuit breaker: while closed it counts the
+// failed and slow calls in a rolling window; when their rate crosses a
+// threshold it opens and fails calls fast with Unavailable; after a cool-down
+// it half-opens and lets a few probe calls decide whether to close again.
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Config tunes the breakers of a Group.
+type Config struct {
+	Window        time.Duration // length of the rolling window
+	MinRequests   int           // calls in the window before the breaker may open
+	ErrorRate     float64       // failed share of the calls that opens the breaker
+	SlowCall      time.Duration // calls slower than this are slow; 0 disables
+	SlowRate      float64       // slow share of the calls that opens the breaker
+	OpenTimeout   time.Duration // how long the breaker stays open
+	HalfOpenCalls int           // probe calls needed to close the breaker
+
+	// OnStateChange, if set, is called after a breaker changed state.
+	OnStateChange func(name string, from, to State)
+}
+
+// IsFailure reports whether a call outcome counts against the backend.
+// Errors the client caused itself, such as a cancellation, an invalid
+// argument or an exceeded quota, say nothing about the backend's health.
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+const windowBuckets = 10
+
+type bucket struct {
+	epoch                  int64
+	total, failures, slows int
+}
+
+// Breaker is the circuit breaker of a single target and method.
+type Breaker struct {
+	name string
+	cfg  *Config
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64 // bumped on every state change
+	openedAt    time.Time
+	buckets     [windowBuckets]bucket
+	probes      int // probe calls in flight
+	successes   int // successful probe calls
+	rejected    uint64
+	transitions uint64
+}
+
+func newBreaker(name string, cfg *Config) *Breaker {
+	return &Breaker{name: name, cfg: cfg, now: time.Now}
+}
+
+// Allow admits a call or fails fast with Unavailable while the breaker is
+// open. Admitted calls must report their outcome with the returned function.
+func (b *Breaker) Allow() (func(err error, latency time.Duration), error) {
+	b.mu.Lock()
+	var fire func()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		fire = b.setState(HalfOpen)
+	}
+	allowed := b.state == Closed || b.state == HalfOpen && b.probes < b.cfg.HalfOpenCalls
+	if !allowed {
+		b.rejected++
+	} else if b.state == HalfOpen {
+		b.probes++
+	}
+	gen := b.generation
+	b.mu.Unlock()
+	if fire != nil {
+		fire()
+	}
+
+	if !allowed {
+		return nil, status.Errorf(codes.Unavailable, "circuit breaker for %s is open", b.name)
+	}
+	return func(err error, latency time.Duration) {
+		b.report(gen, err, latency)
+	}, nil
+}
+
+func (b *Breaker) report(gen uint64, err error, latency time.Duration) {
+	failed := IsFailure(err)
+	slow := b.cfg.SlowCall > 0 && latency > b.cfg.SlowCall
+
+	b.mu.Lock()
+	var fire func()
+	// Calls admitted before the last state change describe the backend as
+	// it was then; the current state already accounts for them.
+	if gen == b.generation {
+		switch b.state {
+		case Closed:
+			fire = b.record(failed, slow)
+		case HalfOpen:
+			b.probes--
+			switch {
+			case failed:
+				fire = b.setState(Open)
+			case err != nil:
+				// The caller ended the probe, e.g. by cancelling it; it
+				// proves nothing either way and only frees its slot.
+			case slow:
+				fire = b.setState(Open)
+			default:
+				if b.successes++; b.successes >= b.cfg.HalfOpenCalls {
+					fire = b.setState(Closed)
+				}
+			}
+		}
+	}
+	b.mu.Unlock()
+	if fire != nil {
+		fire()
+	}
+}
+
+func (b *Breaker) record(failed, slow bool) func() {
+	width := b.cfg.Window / windowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	epoch := b.now().UnixNano() / int64(width)
+	cur := &b.buckets[epoch%windowBuckets]
+	if cur.epoch != epoch {
+		*cur = bucket{epoch: epoch}
+	}
+	cur.total++
+	if failed {
+		cur.failures++
+	}
+	if slow {
+		cur.slows++
+	}
+
+	var total, failures, slows int
+	for _, bk := range b.buckets {
+		if epoch-bk.epoch < windowBuckets {
+			total += bk.total
+			failures += bk.failures
+			slows += bk.slows
+		}
+	}
+	if total < b.cfg.MinRequests || total == 0 {
+		return nil
+	}
+	if float64(failures)/float64(total) >= b.cfg.ErrorRate ||
+		b.cfg.SlowCall > 0 && float64(slows)/float64(total) >= b.cfg.SlowRate {
+		return b.setState(Open)
+	}
+	return nil
+}
+
+// setState switches the state and returns the function notifying the
+// callback, to be called once b.mu is released.
+func (b *Breaker) setState(to State) func() {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.transitions++
+	b.probes = 0
+	b.successes = 0
+	switch to {
+	case Open:
+		b.openedAt = b.now()
+	case Closed:
+		b.buckets = [windowBuckets]bucket{}
+	}
+	cb := b.cfg.OnStateChange
+	if cb == nil {
+		return nil
+	}
+	return func() { cb(b.name, from, to) }
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats is a snapshot of a breaker.
+type Stats struct {
+	State       string `json:"state"`
+	Rejected    uint64 `json:"rejected"`
+	Transitions uint64 `json:"transitions"`
+}
+
+// Stats returns the state and counters of the breaker.
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stats{State: b.state.String(), Rejected: b.rejected, Transitions: b.transitions}
+}
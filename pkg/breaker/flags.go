@@ -0,0 +1,45 @@
+package breaker
+
+import (
+	"flag"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+)
+
+// ClientFlags are the command line flags enabling circuit breakers on a
+// client.
+type ClientFlags struct {
+	Enabled bool
+	Config  Config
+}
+
+// Register adds the flags to fs.
+func (f *ClientFlags) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.Enabled, "circuit_breaker", false, "fail calls fast while a method of the server keeps failing")
+	fs.DurationVar(&f.Config.Window, "breaker_window", 10*time.Second, "rolling window the failure and slow call rates are measured over")
+	fs.IntVar(&f.Config.MinRequests, "breaker_min_requests", 10, "calls in the window before a breaker may open")
+	fs.Float64Var(&f.Config.ErrorRate, "breaker_error_rate", 0.5, "share of failed calls that opens a breaker")
+	fs.DurationVar(&f.Config.SlowCall, "breaker_slow_call", 0, "unary calls slower than this count as slow; 0 ignores latency")
+	fs.Float64Var(&f.Config.SlowRate, "breaker_slow_rate", 0.5, "share of slow calls that opens a breaker")
+	fs.DurationVar(&f.Config.OpenTimeout, "breaker_open_timeout", 5*time.Second, "how long a breaker stays open before it lets probe calls through")
+	fs.IntVar(&f.Config.HalfOpenCalls, "breaker_half_open_calls", 1, "successful probe calls needed to close a breaker")
+}
+
+// DialOptions returns the options installing circuit breakers that log their
+// state changes, or none when they are disabled.
+func (f *ClientFlags) DialOptions() ([]grpc.DialOption, error) {
+	if !f.Enabled {
+		return nil, nil
+	}
+	c := f.Config
+	c.OnStateChange = func(name string, from, to State) {
+		logging.Warnf("circuit breaker %s: %v -> %v", name, from, to)
+	}
+	g, err := NewGroup(c)
+	if err != nil {
+		return nil, err
+	}
+	return g.DialOptions(), nil
+}
@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// metrics publishes every breaker as "circuit_breaker": {"<target> <method>":
+// {"state": "open", ...}}.
+var metrics = expvar.NewMap("circuit_breaker")
+
+// Group holds the breakers of every target and method a client calls.
+type Group struct {
+	cfg Config
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup returns an empty group whose breakers use c.
+func NewGroup(c Config) (*Group, error) {
+	switch {
+	case c.Window <= 0 || c.OpenTimeout <= 0:
+		return nil, fmt.Errorf("breaker: window and open timeout must be positive")
+	case c.ErrorRate <= 0 || c.ErrorRate > 1 || c.SlowRate <= 0 || c.SlowRate > 1:
+		return nil, fmt.Errorf("breaker: error and slow rates must be in (0, 1]")
+	case c.MinRequests < 1 || c.HalfOpenCalls < 1:
+		return nil, fmt.Errorf("breaker: min requests and half-open calls must be at least 1")
+	}
+	return &Group{cfg: c, breakers: make(map[string]*Breaker)}, nil
+}
+
+// Breaker returns the breaker of method on target, creating it if needed.
+func (g *Group) Breaker(target, method string) *Breaker {
+	name := target + " " + method
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[name]
+	if !ok {
+		b = newBreaker(name, &g.cfg)
+		g.breakers[name] = b
+		metrics.Set(name, expvar.Func(func() interface{} { return b.Stats() }))
+	}
+	return b
+}
+
+// UnaryClientInterceptor guards unary calls.
+func (g *Group) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := g.Breaker(cc.Target(), method).Allow()
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err, time.Since(start))
+		return err
+	}
+}
+
+// StreamClientInterceptor guards streams. A stream counts as one call that
+// succeeds with its first received message or clean end, and fails with the
+// error that ends it before that, whether RecvMsg, SendMsg or CloseSend
+// returns it or the caller's context is done first. Its duration is not a
+// latency and is never slow.
+func (g *Group) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := g.Breaker(cc.Target(), method).Allow()
+		if err != nil {
+			return nil, err
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err, 0)
+			return nil, err
+		}
+		s := &reportingStream{ClientStream: cs, done: done, reported: make(chan struct{})}
+		go s.watch(ctx)
+		return s, nil
+	}
+}
+
+type reportingStream struct {
+	grpc.ClientStream
+	once     sync.Once
+	done     func(err error, latency time.Duration)
+	reported chan struct{}
+}
+
+// report reports the outcome of the stream once.
+func (s *reportingStream) report(err error) {
+	s.once.Do(func() {
+		close(s.reported)
+		s.done(err, 0)
+	})
+}
+
+// watch reports a stream whose context is done before its outcome is
+// known: a cancelled stream is often never read again, and in the half-open
+// state it would hold a probe slot forever.
+func (s *reportingStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		code := codes.Canceled
+		if ctx.Err() == context.DeadlineExceeded {
+			code = codes.DeadlineExceeded
+		}
+		s.report(status.Error(code, ctx.Err().Error()))
+	case <-s.reported:
+	}
+}
+
+func (s *reportingStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	// io.EOF means the stream has ended; RecvMsg returns its status.
+	if err != nil && err != io.EOF {
+		s.report(err)
+	}
+	return err
+}
+
+func (s *reportingStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.report(err)
+	}
+	return err
+}
+
+func (s *reportingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.report(nil)
+	} else {
+		s.report(err)
+	}
+	return err
+}
+
+// DialOptions returns the options installing both interceptors.
+func (g *Group) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(g.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(g.StreamClientInterceptor()),
+	}
+}
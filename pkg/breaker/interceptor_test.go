@@ -0,0 +1,66 @@
+package breaker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/breaker"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCancelledProbeStreamFreesSlot(t *testing.T) {
+	fake := fakeserver.New()
+	err := fake.Add(fakeserver.Rule{
+		Method:    "searchOrders",
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.Order{Id: "202"}), Delay: fakeserver.Duration(time.Minute)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := breaker.NewGroup(breaker.Config{
+		Window:        time.Second,
+		MinRequests:   1,
+		ErrorRate:     1,
+		SlowRate:      1,
+		OpenTimeout:   10 * time.Millisecond,
+		HalfOpenCalls: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := grpctest.NewServer(t, fake.Register).Dial(t, g.DialOptions()...)
+
+	// Open the breaker and let it half-open.
+	b := g.Breaker(conn.Target(), "/ecommerce.OrderManagement/searchOrders")
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(status.Error(codes.Unavailable, "down"), 0)
+	if b.State() != breaker.Open {
+		t.Fatalf("breaker is %v after a failure, want open", b.State())
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The probe stream is cancelled and never read: it frees its slot
+	// without telling anything about the backend.
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := opb.NewOrderManagementClient(conn).SearchOrders(ctx, &wrappers.StringValue{Value: "Kindle"}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	grpctest.Wait(t, "the probe slot to be freed", func() bool {
+		done, err = b.Allow()
+		return err == nil
+	})
+	defer done(status.Error(codes.Canceled, "test over"), 0)
+	if b.State() != breaker.HalfOpen {
+		t.Errorf("breaker is %v after a cancelled probe, want half-open", b.State())
+	}
+}
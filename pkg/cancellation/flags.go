@@ -0,0 +1,143 @@
+package cancellation
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/protobuf/proto"
+)
+
+// ServerFlags are the command line flags choosing what happens to the
+// partial work of cancelled calls.
+type ServerFlags struct {
+	Policy      string
+	PendingFile string
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Policy, "cancel_policy", "rollback", "what happens to the partial batch of a cancelled stream: rollback or persist")
+	fs.StringVar(&f.PendingFile, "pending_file", "pending.jsonl", "journal the persisted partial batches are appended to, replayed at startup")
+}
+
+// Abandoner applies a policy to partial work. Policy and Store may be set
+// before first use; afterwards, use SetPolicy.
+type Abandoner struct {
+	Policy Policy
+	Store  Store
+
+	mu sync.Mutex
+}
+
+// Abandoner returns the abandoner described by the flags. The pending
+// store is only opened for the persist policy.
+func (f *ServerFlags) Abandoner() (*Abandoner, error) {
+	p, err := ParsePolicy(f.Policy)
+	if err != nil {
+		return nil, err
+	}
+	a := &Abandoner{Policy: p}
+	if p == Persist {
+		if a.Store, err = OpenFileStore(f.PendingFile); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Validate checks the policy.
+func (f *ServerFlags) Validate() error {
+	_, err := ParsePolicy(f.Policy)
+	return err
+}
+
+// Reload switches a to the policy of the flags.
+func (f *ServerFlags) Reload(a *Abandoner) error {
+	p, err := ParsePolicy(f.Policy)
+	if err != nil {
+		return err
+	}
+	return a.SetPolicy(p, f.PendingFile)
+}
+
+// SetPolicy switches the policy for the work abandoned from now on. The
+// pending store at path is opened when the persist policy needs one; an open
+// store stays open when switching back to rollback.
+func (a *Abandoner) SetPolicy(p Policy, path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p == Persist && a.Store == nil {
+		s, err := OpenFileStore(path)
+		if err != nil {
+			return err
+		}
+		a.Store = s
+	}
+	a.Policy = p
+	return nil
+}
+
+func (a *Abandoner) current() (Policy, Store) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Policy, a.Store
+}
+
+// Check reports whether the pending store, if any, can take new batches.
+func (a *Abandoner) Check(ctx context.Context) error {
+	_, store := a.current()
+	if c, ok := store.(interface{ Check(context.Context) error }); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Stats reports the policy and the number of pending batches, if they are
+// kept.
+func (a *Abandoner) Stats() interface{} {
+	policy, store := a.current()
+	st := map[string]interface{}{"policy": policy.String()}
+	if l, ok := store.(interface{ Len() (int, error) }); ok {
+		n, err := l.Len()
+		if err != nil {
+			st["pending_error"] = err.Error()
+		} else {
+			st["pending_batches"] = n
+		}
+	}
+	return st
+}
+
+// Close closes the pending store, if any.
+func (a *Abandoner) Close() error {
+	_, store := a.current()
+	if c, ok := store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Abandon discards msgs or keeps them as a pending batch, according to the
+// policy. Failing to persist is logged, since the call has already ended.
+func (a *Abandoner) Abandon(method string, cause error, msgs ...proto.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	policy, store := a.current()
+	if policy == Rollback || store == nil {
+		logging.Infof("%s: rolled back %d unfinished items", method, len(msgs))
+		return
+	}
+	b, err := NewBatch(method, cause, msgs...)
+	if err == nil {
+		err = store.Put(b)
+	}
+	if err != nil {
+		logging.Errorf("%s: failed to persist %d unfinished items: %v", method, len(msgs), err)
+		return
+	}
+	logging.Infof("%s: persisted %d unfinished items as pending work", method, len(msgs))
+}
@@ -0,0 +1,96 @@
+package cancellation
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// metrics counts the outcomes of every method: "ok", "canceled",
+// "deadline_exceeded" and "error".
+var metrics = expvar.NewMap("call_outcomes")
+
+var (
+	outcomesMu sync.Mutex
+	outcomes   = make(map[string]*expvar.Map)
+)
+
+func record(method, outcome string) {
+	outcomesMu.Lock()
+	m, ok := outcomes[method]
+	if !ok {
+		m = new(expvar.Map).Init()
+		outcomes[method] = m
+		metrics.Set(method, m)
+	}
+	outcomesMu.Unlock()
+	m.Add(outcome, 1)
+}
+
+// finish classifies the end of a call, running the cleanup hooks of calls
+// cut short by their context. It returns the error to hand to gRPC.
+func finish(ctx context.Context, s *Scope, start time.Time, err error) error {
+	cause := Cause(ctx, err)
+	if cause == nil {
+		if err != nil {
+			record(s.method, "error")
+		} else {
+			record(s.method, "ok")
+		}
+		return err
+	}
+	n := s.run(cause)
+	code := status.Code(cause)
+	if code == codes.Canceled {
+		record(s.method, "canceled")
+	} else {
+		record(s.method, "deadline_exceeded")
+	}
+	logging.Infof("%s ended by %v after %v, ran %d cleanup hooks", s.method, code, time.Since(start).Round(time.Millisecond), n)
+	return cause
+}
+
+// UnaryServerInterceptor gives unary calls a scope.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		s := NewScope(info.FullMethod)
+		start := time.Now()
+		resp, err := handler(ContextWithScope(ctx, s), req)
+		if err = finish(ctx, s, start, err); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor gives streams a scope.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		s := NewScope(info.FullMethod)
+		start := time.Now()
+		ctx := ss.Context()
+		err := handler(srv, &scopedStream{ServerStream: ss, ctx: ContextWithScope(ctx, s)})
+		return finish(ctx, s, start, err)
+	}
+}
+
+type scopedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *scopedStream) Context() context.Context { return s.ctx }
+
+// ServerOptions returns the options installing both interceptors.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor()),
+	}
+}
@@ -0,0 +1,273 @@
+package cancellation
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Policy decides what happens to the partial work of a cancelled call.
+type Policy int
+
+const (
+	// Rollback discards the partial work, leaving no trace of the call.
+	Rollback Policy = iota
+	// Persist keeps the partial work as a pending batch to be finished
+	// later.
+	Persist
+)
+
+func (p Policy) String() string {
+	if p == Persist {
+		return "persist"
+	}
+	return "rollback"
+}
+
+// ParsePolicy parses "rollback" or "persist".
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "rollback":
+		return Rollback, nil
+	case "persist":
+		return Persist, nil
+	}
+	return Rollback, fmt.Errorf("unknown cancellation policy %q, want rollback or persist", s)
+}
+
+// Batch is the partial work of a cancelled call.
+type Batch struct {
+	Time   time.Time         `json:"time"`
+	Method string            `json:"method"`
+	Cause  string            `json:"cause"`
+	Items  []json.RawMessage `json:"items"`
+}
+
+// NewBatch returns the batch of msgs, encoded as protobuf JSON.
+func NewBatch(method string, cause error, msgs ...proto.Message) (*Batch, error) {
+	b := &Batch{Time: time.Now(), Method: method}
+	if cause != nil {
+		b.Cause = cause.Error()
+	}
+	for _, m := range msgs {
+		j, err := protojson.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		b.Items = append(b.Items, j)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes the item i of b into m.
+func (b *Batch) Unmarshal(i int, m proto.Message) error {
+	return protojson.Unmarshal(b.Items[i], m)
+}
+
+// Store keeps pending batches.
+type Store interface {
+	Put(b *Batch) error
+}
+
+// MemoryStore keeps pending batches in memory, e.g. for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	batches []*Batch
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(b *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+// Batches returns the batches put so far.
+func (s *MemoryStore) Batches() []*Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Batch(nil), s.batches...)
+}
+
+// FileStore appends pending batches to a file, one JSON object per line:
+// the Batch fields with the items encoded as protobuf JSON, e.g.
+//
+//	{"time":"...","method":"updateOrders","cause":"...","items":[{"id":"102",...}]}
+type FileStore struct {
+	mu sync.Mutex
+	f  *os.File
+	n  int // batches in the journal
+}
+
+// OpenFileStore opens or creates the journal at path.
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cancellation: open pending store: %v", err)
+	}
+	n, err := countLines(path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cancellation: open pending store: %v", err)
+	}
+	return &FileStore{f: f, n: n}, nil
+}
+
+// countLines returns the number of non-empty lines of the file at path.
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			n++
+		}
+	}
+	return n, sc.Err()
+}
+
+// Put appends b and syncs the file, so that the batch survives a crash.
+func (s *FileStore) Put(b *Batch) error {
+	line, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.n++
+	return s.f.Sync()
+}
+
+// Check reports whether new batches can be written, by creating and removing
+// a file next to the journal.
+func (s *FileStore) Check(ctx context.Context) error {
+	f, err := ioutil.TempFile(filepath.Dir(s.f.Name()), ".pending-check-")
+	if err != nil {
+		return fmt.Errorf("pending store not writable: %v", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Len returns the number of batches in the journal.
+func (s *FileStore) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n, nil
+}
+
+// Close syncs and closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// ReadBatches returns the batches journaled at path; a missing file holds
+// none.
+func ReadBatches(path string) ([]*Batch, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var bs []*Batch
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var b Batch
+		if err := json.Unmarshal(sc.Bytes(), &b); err != nil {
+			return nil, fmt.Errorf("cancellation: %s: %v", path, err)
+		}
+		bs = append(bs, &b)
+	}
+	return bs, sc.Err()
+}
+
+// Replay hands the batches journaled at path to apply, oldest first, and
+// removes the applied ones from the journal. It stops at the first batch
+// apply fails on, keeping that batch and the later ones for the next replay.
+// It returns the number of batches applied. Replay rewrites the journal, so
+// it must run before a FileStore is opened on path.
+func Replay(path string, apply func(*Batch) error) (int, error) {
+	bs, err := ReadBatches(path)
+	if err != nil || len(bs) == 0 {
+		return 0, err
+	}
+	n := 0
+	for ; n < len(bs); n++ {
+		if err = apply(bs[n]); err != nil {
+			err = fmt.Errorf("cancellation: replay %s batch of %s: %v", bs[n].Method, bs[n].Time.Format(time.RFC3339), err)
+			break
+		}
+	}
+	if n == 0 {
+		return 0, err
+	}
+	if werr := rewrite(path, bs[n:]); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// rewrite replaces the journal at path with bs.
+func rewrite(path string, bs []*Batch) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".pending-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, b := range bs {
+		line, err := json.Marshal(b)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
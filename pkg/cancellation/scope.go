@@ -0,0 +1,89 @@
+// Package cancellation makes the end of a call by cancellation or deadline
+// something handlers can act on. The interceptors give every call a Scope
+// in which handlers register cleanup hooks; the hooks run when the call ends
+// because its context did, e.g. to roll back or persist a partial batch.
+// Cancelled calls are logged and counted apart from failed ones.
+package cancellation
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scope collects the cleanup hooks of one call.
+type Scope struct {
+	method string
+
+	mu    sync.Mutex
+	hooks []func(cause error)
+	ran   bool
+}
+
+type scopeKey struct{}
+
+// NewScope returns an empty scope for a call to method.
+func NewScope(method string) *Scope {
+	return &Scope{method: method}
+}
+
+// ContextWithScope returns a copy of ctx carrying s.
+func ContextWithScope(ctx context.Context, s *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// FromContext returns the scope of the call ctx belongs to. Without the
+// interceptors installed it returns a scope whose hooks never run, so
+// handlers need not check.
+func FromContext(ctx context.Context) *Scope {
+	if s, ok := ctx.Value(scopeKey{}).(*Scope); ok {
+		return s
+	}
+	return &Scope{}
+}
+
+// OnCancel registers f to run if the call ends by cancellation or deadline.
+// Hooks run in reverse order of registration with the status error ending
+// the call.
+func (s *Scope) OnCancel(f func(cause error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, f)
+}
+
+// run runs the hooks once and returns how many ran.
+func (s *Scope) run(cause error) int {
+	s.mu.Lock()
+	hooks := s.hooks
+	if s.ran {
+		hooks = nil
+	}
+	s.ran = true
+	s.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i](cause)
+	}
+	return len(hooks)
+}
+
+// Cause returns the status error describing why a call ended early: the
+// handler's error if it is a Canceled or DeadlineExceeded status, else the
+// error of ctx if the handler returned nil or a context error. A handler
+// returning any other error ended the call itself, so like a call that was
+// not cut short it gets nil and keeps its error.
+func Cause(ctx context.Context, err error) error {
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded:
+		return status.Convert(err).Err()
+	}
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return nil
+}
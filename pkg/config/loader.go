@@ -0,0 +1,374 @@
+// Package config loads the settings of a command in layers, each overriding
+// the one before:
+//
+//  1. the defaults of the command line flags,
+//  2. a JSON or YAML file named by -config or $ECOM_CONFIG,
+//  3. environment variables, $ECOM_<FLAG NAME> in upper case,
+//  4. the command line.
+//
+// Every setting is a flag, so the file and the environment use the flag
+// names, e.g.
+//
+//	listen: ":50051"
+//	order_batch_size: 5
+//	rate_limit_config: ratelimit.json
+//	log_level: warn
+//
+// Lists are given as YAML or JSON lists or as comma separated strings. The
+// loaded settings are validated before the command starts.
+//
+// On SIGHUP the file and the environment are read again. Settings marked as
+// reloadable, such as the log level, the rate limits and the batch policy,
+// take effect right away; changes to the others are reported and ignored
+// until the next start. Settings given on the command line never change.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables.
+const EnvPrefix = "ECOM_"
+
+// Loader loads the flags of a flag set from all layers.
+type Loader struct {
+	fs   *flag.FlagSet
+	file string
+
+	mu         sync.Mutex
+	cmdline    map[string]bool
+	sources    map[string]string
+	reloadable map[string]func() error
+	checks     []func() error
+}
+
+var (
+	loadersMu sync.Mutex
+	loaders   = make(map[*flag.FlagSet]*Loader)
+)
+
+// NewLoader returns a loader for the flags of fs. It adds the -config and
+// -log_level flags; the log level is reloadable.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		fs:         fs,
+		cmdline:    make(map[string]bool),
+		sources:    make(map[string]string),
+		reloadable: make(map[string]func() error),
+	}
+	loadersMu.Lock()
+	loaders[fs] = l
+	loadersMu.Unlock()
+	fs.StringVar(&l.file, "config", "", "JSON or YAML file with flag values, overridden by $"+EnvPrefix+"<FLAG> environment variables and the command line")
+	fs.Var(logging.LevelFlag{}, "log_level", "lowest level of the messages logged by the shared packages: debug, info, warn or error")
+	l.Reloadable("log_level", nil)
+	return l
+}
+
+// Reloadable marks the flag name as reloadable. After a reload has set the
+// flag, apply, if not nil, is called to put the value into effect; it is
+// called on every reload, since a file named by the flag may have changed
+// while the name did not. An apply that fails must leave the setting in
+// effect as it was.
+func (l *Loader) Reloadable(name string, apply func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reloadable[name] = apply
+}
+
+// VisitAll calls fn for every flag of fs like fs.VisitAll. If fs has a
+// loader, reloads wait until VisitAll returns, so that fn sees no value
+// change underneath it.
+func VisitAll(fs *flag.FlagSet, fn func(*flag.Flag)) {
+	loadersMu.Lock()
+	l := loaders[fs]
+	loadersMu.Unlock()
+	if l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	fs.VisitAll(fn)
+}
+
+// Check adds a validation run after loading and reloading.
+func (l *Loader) Check(f func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checks = append(l.checks, f)
+}
+
+// Source returns the layer the value of the flag name came from: "default",
+// "file", "env" or "flag".
+func (l *Loader) Source(name string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.sources[name]; ok {
+		return s
+	}
+	return "default"
+}
+
+// Load parses args and layers the file and the environment underneath.
+func (l *Loader) Load(args []string) error {
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fs.Visit(func(f *flag.Flag) {
+		l.cmdline[f.Name] = true
+		l.sources[f.Name] = "flag"
+	})
+	if l.file == "" {
+		l.file = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	values, err := l.layered()
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		if err := l.fs.Set(name, v.value); err != nil {
+			return fmt.Errorf("config: %s from %s: %v", name, v.source, err)
+		}
+		l.sources[name] = v.source
+	}
+	return l.check()
+}
+
+type layeredValue struct {
+	value  string
+	source string
+}
+
+// layered returns the values of the flags not set on the command line from
+// the file and the environment. Flags absent from both are left out.
+func (l *Loader) layered() (map[string]layeredValue, error) {
+	file := make(map[string]string)
+	if l.file != "" {
+		var err error
+		if file, err = readFile(l.file); err != nil {
+			return nil, err
+		}
+	}
+	var unknown []string
+	for name := range file {
+		if name == "config" || l.fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("config: %s: unknown settings %s", l.file, strings.Join(unknown, ", "))
+	}
+
+	values := make(map[string]layeredValue)
+	l.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || l.cmdline[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			values[f.Name] = layeredValue{v, "env"}
+		} else if v, ok := file[f.Name]; ok {
+			values[f.Name] = layeredValue{v, "file"}
+		}
+	})
+	return values, nil
+}
+
+func (l *Loader) check() error {
+	for _, c := range l.checks {
+		if err := c(); err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable of the flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Reload reads the file and the environment again and applies the
+// reloadable settings. Other changed settings are logged and ignored. If
+// the new settings are invalid or fail to apply, the previous values are
+// restored and applied again.
+func (l *Loader) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	values, err := l.layered()
+	if err != nil {
+		return err
+	}
+
+	// Settings no longer in the file or the environment fall back to their
+	// defaults.
+	l.fs.VisitAll(func(f *flag.Flag) {
+		src := l.sources[f.Name]
+		if _, ok := values[f.Name]; !ok && (src == "file" || src == "env") {
+			values[f.Name] = layeredValue{f.DefValue, "default"}
+		}
+	})
+
+	old := make(map[string]string)
+	var names []string
+	for name, v := range values {
+		f := l.fs.Lookup(name)
+		if f.Value.String() == v.value {
+			l.sources[name] = v.source
+			continue
+		}
+		if _, ok := l.reloadable[name]; !ok {
+			logging.Warnf("config: %s changed to %q, restart to apply", name, v.value)
+			continue
+		}
+		old[name] = f.Value.String()
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rollback := func() {
+		for name, v := range old {
+			l.fs.Set(name, v)
+		}
+	}
+	for _, name := range names {
+		if err := l.fs.Set(name, values[name].value); err != nil {
+			rollback()
+			return fmt.Errorf("config: %s from %s: %v", name, values[name].source, err)
+		}
+	}
+	if err := l.check(); err != nil {
+		rollback()
+		return err
+	}
+
+	var applies []string
+	for name, apply := range l.reloadable {
+		if apply != nil {
+			applies = append(applies, name)
+		}
+	}
+	sort.Strings(applies)
+	var applied, errs []string
+	for _, name := range applies {
+		if err := l.reloadable[name](); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		} else {
+			applied = append(applied, name)
+		}
+	}
+	if len(errs) > 0 {
+		// Put the settings that did take effect back to the old values;
+		// the failed ones kept theirs.
+		rollback()
+		for _, name := range applied {
+			if err := l.reloadable[name](); err != nil {
+				logging.Errorf("config: restoring %s: %v", name, err)
+			}
+		}
+		return fmt.Errorf("config: %s", strings.Join(errs, "; "))
+	}
+	for _, name := range names {
+		l.sources[name] = values[name].source
+		logging.Infof("config: %s set to %q", name, values[name].value)
+	}
+	return nil
+}
+
+// Parse loads the command line flags from os.Args like flag.Parse, exiting
+// on errors, and reloads them on SIGHUP.
+func (l *Loader) Parse() {
+	if err := l.Load(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	l.ReloadOnSignal()
+}
+
+// ReloadOnSignal reloads the settings whenever the process receives SIGHUP.
+func (l *Loader) ReloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := l.Reload(); err != nil {
+				logging.Errorf("keeping previous settings: %v", err)
+			} else {
+				logging.Infof("config: reloaded")
+			}
+		}
+	}()
+}
+
+// readFile reads a flat map of flag values from a JSON or YAML file. YAML
+// is assumed for the .yaml and .yml extensions.
+func readFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %v", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		s, err := flagString(v)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: %s: %v", path, name, err)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
+
+// flagString formats a scalar or a list of scalars as a flag value.
+func flagString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			s, err := flagString(e)
+			if err != nil {
+				return "", err
+			}
+			if _, isList := e.([]interface{}); isList {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported value %v, want a scalar or a list", v)
+}
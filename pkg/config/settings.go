@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Server are the settings of a server command.
+type Server struct {
+	Listen string
+}
+
+// Register adds the -listen flag with the default address def.
+func (s *Server) Register(l *Loader, def string) {
+	l.fs.StringVar(&s.Listen, "listen", def, "address the server listens on")
+	l.Check(func() error { return checkAddr("listen", s.Listen) })
+}
+
+// Client are the settings of a client command.
+type Client struct {
+	Address string
+}
+
+// Register adds the -address flag with the default server address def.
+func (c *Client) Register(l *Loader, def string) {
+	l.fs.StringVar(&c.Address, "address", def, "address of the server")
+	l.Check(func() error {
+		if c.Address == "" {
+			return fmt.Errorf("address: must not be empty")
+		}
+		return nil
+	})
+}
+
+// Backends is a list of backend addresses.
+type Backends struct {
+	Addrs []string
+}
+
+// Register adds the -backends flag with the default addresses def.
+func (b *Backends) Register(l *Loader, def ...string) {
+	b.Addrs = def
+	l.fs.Var((*addrList)(&b.Addrs), "backends", "comma separated backend addresses")
+	l.Check(func() error {
+		if len(b.Addrs) == 0 {
+			return fmt.Errorf("backends: at least one address is needed")
+		}
+		seen := make(map[string]bool)
+		for _, a := range b.Addrs {
+			if err := checkAddr("backends", a); err != nil {
+				return err
+			}
+			if seen[a] {
+				return fmt.Errorf("backends: %s listed twice", a)
+			}
+			seen[a] = true
+		}
+		return nil
+	})
+}
+
+type addrList []string
+
+func (a *addrList) String() string { return strings.Join(*a, ",") }
+
+func (a *addrList) Set(s string) error {
+	var addrs []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			addrs = append(addrs, p)
+		}
+	}
+	*a = addrs
+	return nil
+}
+
+// Batch is the reloadable batch policy of the order servers: how many
+// orders of a processOrders stream are shipped together.
+type Batch struct {
+	size batchSize
+}
+
+// MaxBatchSize bounds the batch size.
+const MaxBatchSize = 1000
+
+// Register adds the reloadable -order_batch_size flag with the default size
+// def.
+func (b *Batch) Register(l *Loader, def int) {
+	b.size = batchSize(def)
+	l.fs.Var(&b.size, "order_batch_size", "number of orders shipped together by processOrders; reloadable")
+	l.Reloadable("order_batch_size", nil)
+}
+
+// NewBatch returns a batch policy of size without a flag, for programs and
+// tests that do not load settings.
+func NewBatch(size int) *Batch {
+	return &Batch{size: batchSize(size)}
+}
+
+// Size returns the current batch size.
+func (b *Batch) Size() int {
+	return int(atomic.LoadInt32((*int32)(&b.size)))
+}
+
+type batchSize int32
+
+func (s *batchSize) String() string {
+	return strconv.Itoa(int(atomic.LoadInt32((*int32)(s))))
+}
+
+func (s *batchSize) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > MaxBatchSize {
+		return fmt.Errorf("must be between 1 and %d", MaxBatchSize)
+	}
+	atomic.StoreInt32((*int32)(s), int32(n))
+	return nil
+}
+
+func checkAddr(name, addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%s: invalid address %q: %v", name, addr, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%s: invalid port in %q", name, addr)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package deadline makes servers respect the time their callers are willing
+// to wait. An interceptor turns away calls whose remaining deadline is too
+// short to do the work and caps deadlines that are too long; handlers derive
+// the contexts of downstream work with a safety margin and stop waiting as
+// soon as the deadline passes.
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Downstream returns a context for work done on behalf of ctx whose deadline
+// is margin earlier, leaving the handler time to answer before its caller
+// gives up. Without a deadline on ctx, the result only inherits its
+// cancellation.
+func Downstream(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc) {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, dl.Add(-margin))
+}
+
+// Remaining returns the time left until the deadline of ctx, and false if
+// ctx has none.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(dl), true
+}
+
+// Sleep waits for d like time.Sleep but gives up when ctx ends, returning
+// the matching DeadlineExceeded or Canceled status error.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// Err returns the status error for ctx having ended, or nil while it is
+// still live. Long running handlers check it between steps.
+func Err(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// tooShort is the error for calls whose remaining deadline is below min.
+func tooShort(fullMethod string, remaining, min time.Duration) error {
+	return status.Errorf(codes.DeadlineExceeded, "%s needs at least %v but only %v of the deadline remain", fullMethod, min, remaining.Round(time.Millisecond))
+}
@@ -0,0 +1,35 @@
+package deadline
+
+import (
+	"flag"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ServerFlags are the command line flags enabling deadline admission control
+// on a server.
+type ServerFlags struct {
+	MinDeadlines string
+	MaxDeadline  time.Duration
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.MinDeadlines, "min_deadlines", "", "comma separated method=duration minimum remaining deadlines, e.g. /ecommerce.OrderManagement/addOrder=1s,*=50ms")
+	fs.DurationVar(&f.MaxDeadline, "max_deadline", 0, "longest any call may run, whatever deadline its caller set; 0 for no limit")
+}
+
+// ServerOptions returns the options installing the admission control, or
+// none when neither flag is set.
+func (f *ServerFlags) ServerOptions() ([]grpc.ServerOption, error) {
+	if f.MinDeadlines == "" && f.MaxDeadline == 0 {
+		return nil, nil
+	}
+	mins, err := ParseMethodMinimums(f.MinDeadlines)
+	if err != nil {
+		return nil, err
+	}
+	a := &Admission{Minimums: mins, Max: f.MaxDeadline}
+	return a.ServerOptions(), nil
+}
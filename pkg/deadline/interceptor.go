@@ -0,0 +1,113 @@
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+	"google.golang.org/grpc"
+)
+
+// MethodMinimum is the least remaining deadline a call to the methods it
+// matches needs to be admitted. Method is a full method name, a
+// "/package.Service/*" wildcard or "*".
+type MethodMinimum struct {
+	Method  string
+	Minimum time.Duration
+}
+
+// ParseMethodMinimums parses a comma separated list of method=duration
+// pairs, e.g. "/ecommerce.OrderManagement/addOrder=1s,*=50ms".
+func ParseMethodMinimums(s string) ([]MethodMinimum, error) {
+	var ms []MethodMinimum
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.LastIndex(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid method minimum %q, want method=duration", kv)
+		}
+		if err := methodmatch.Validate(kv[:i]); err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(kv[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid method minimum %q: %v", kv, err)
+		}
+		ms = append(ms, MethodMinimum{Method: kv[:i], Minimum: d})
+	}
+	return ms, nil
+}
+
+// Admission rejects calls that cannot finish in time and bounds how long
+// any call may run.
+type Admission struct {
+	// Minimums are checked in order; the first match applies.
+	Minimums []MethodMinimum
+	// Max caps the deadline of every call, including calls without one.
+	// Zero leaves deadlines alone.
+	Max time.Duration
+}
+
+// admit returns the context the handler runs with, or the rejection.
+func (a *Admission) admit(ctx context.Context, fullMethod string) (context.Context, context.CancelFunc, error) {
+	remaining, ok := Remaining(ctx)
+	if ok {
+		for i := range a.Minimums {
+			if m := &a.Minimums[i]; methodmatch.Match(m.Method, fullMethod) {
+				if remaining < m.Minimum {
+					return nil, nil, tooShort(fullMethod, remaining, m.Minimum)
+				}
+				break
+			}
+		}
+	}
+	if a.Max > 0 && (!ok || remaining > a.Max) {
+		ctx, cancel := context.WithTimeout(ctx, a.Max)
+		return ctx, cancel, nil
+	}
+	return ctx, func() {}, nil
+}
+
+// UnaryServerInterceptor applies the admission control to unary calls.
+func (a *Admission) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel, err := a.admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor applies the admission control to streams.
+func (a *Admission) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, err := a.admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// ServerOptions returns the options installing both interceptors.
+func (a *Admission) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(a.StreamServerInterceptor()),
+	}
+}
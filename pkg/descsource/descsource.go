@@ -0,0 +1,295 @@
+// Package descsource finds the descriptors of the services of a server,
+// either by asking the server through the reflection service or from
+// descriptor set files, such as those written by protoc
+// --descriptor_set_out or descdump -o. Clients use them to call methods they
+// have no generated code for.
+package descsource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Source is a resolved set of files and the services they describe.
+type Source struct {
+	// Services are the full names of the services, sorted.
+	Services []string
+	// Set holds the files, each after its dependencies.
+	Set   *descriptorpb.FileDescriptorSet
+	Files *protoregistry.Files
+	// Types are dynamic types of the messages, enums and extensions of
+	// Files, for resolving Any fields.
+	Types *protoregistry.Types
+}
+
+func newSource(services []string, set *descriptorpb.FileDescriptorSet) (*Source, error) {
+	// Resolving the set checks that it is complete and consistent.
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("descsource: invalid descriptors: %v", err)
+	}
+	types := new(protoregistry.Types)
+	var err2 error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err2 = registerTypes(types, fd.Messages(), fd.Enums(), fd.Extensions())
+		return err2 == nil
+	})
+	if err2 != nil {
+		return nil, fmt.Errorf("descsource: %v", err2)
+	}
+	return &Source{Services: services, Set: set, Files: files, Types: types}, nil
+}
+
+func registerTypes(types *protoregistry.Types, msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, exts protoreflect.ExtensionDescriptors) error {
+	for i := 0; i < enums.Len(); i++ {
+		if err := types.RegisterEnum(dynamicpb.NewEnumType(enums.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < exts.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(exts.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < msgs.Len(); i++ {
+		md := msgs.Get(i)
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+			return err
+		}
+		if err := registerTypes(types, md.Messages(), md.Enums(), md.Extensions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FromReflection asks the server behind conn for its services and the files
+// defining them together with all of their dependencies.
+func FromReflection(ctx context.Context, conn grpc.ClientConnInterface) (*Source, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	ask := func(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection error %d: %s", e.ErrorCode, e.ErrorMessage)
+		}
+		return resp, nil
+	}
+
+	resp, err := ask(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %v", err)
+	}
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+	sort.Strings(services)
+
+	byName := make(map[string]*descriptorpb.FileDescriptorProto)
+	add := func(resp *rpb.ServerReflectionResponse) error {
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := new(descriptorpb.FileDescriptorProto)
+			if err := proto.Unmarshal(b, fd); err != nil {
+				return err
+			}
+			byName[fd.GetName()] = fd
+		}
+		return nil
+	}
+	for _, s := range services {
+		resp, err := ask(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: s}})
+		if err != nil {
+			return nil, fmt.Errorf("file containing %s: %v", s, err)
+		}
+		if err := add(resp); err != nil {
+			return nil, err
+		}
+	}
+	// Servers may leave out dependencies they already sent or consider
+	// well known; ask for the missing ones by name.
+	for {
+		var missing []string
+		for _, fd := range byName {
+			for _, dep := range fd.GetDependency() {
+				if _, ok := byName[dep]; !ok {
+					missing = append(missing, dep)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		for _, name := range missing {
+			if _, ok := byName[name]; ok {
+				continue
+			}
+			resp, err := ask(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name}})
+			if err != nil {
+				return nil, fmt.Errorf("file %s: %v", name, err)
+			}
+			if err := add(resp); err != nil {
+				return nil, err
+			}
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("server did not return file %s", name)
+			}
+		}
+	}
+	return newSource(services, &descriptorpb.FileDescriptorSet{File: sortFiles(byName)})
+}
+
+// FromFiles reads the binary FileDescriptorSets at paths. The services are
+// all those the files define.
+func FromFiles(paths ...string) (*Source, error) {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("descsource: %v", err)
+		}
+		set := new(descriptorpb.FileDescriptorSet)
+		if err := proto.Unmarshal(b, set); err != nil {
+			return nil, fmt.Errorf("descsource: %s: %v", p, err)
+		}
+		for _, fd := range set.File {
+			byName[fd.GetName()] = fd
+		}
+	}
+	var services []string
+	for _, fd := range byName {
+		for _, s := range fd.GetService() {
+			name := s.GetName()
+			if pkg := fd.GetPackage(); pkg != "" {
+				name = pkg + "." + name
+			}
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	return newSource(services, &descriptorpb.FileDescriptorSet{File: sortFiles(byName)})
+}
+
+// sortFiles orders the files so that each follows its dependencies, as
+// protoc does in a descriptor set.
+func sortFiles(byName map[string]*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var sorted []*descriptorpb.FileDescriptorProto
+	done := make(map[string]bool)
+	var visit func(string)
+	visit = func(n string) {
+		if done[n] {
+			return
+		}
+		done[n] = true
+		fd, ok := byName[n]
+		if !ok {
+			// Left for protodesc.NewFiles to report.
+			return
+		}
+		for _, dep := range fd.GetDependency() {
+			visit(dep)
+		}
+		sorted = append(sorted, fd)
+	}
+	for _, n := range names {
+		visit(n)
+	}
+	return sorted
+}
+
+// Service returns the service with the full name.
+func (s *Source) Service(name string) (protoreflect.ServiceDescriptor, error) {
+	d, err := s.Files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %v", name, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+	return sd, nil
+}
+
+// Method returns the method named as in grpcurl, pkg.Service/Method or
+// pkg.Service.Method, optionally with a leading slash.
+func (s *Source) Method(name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	i := strings.LastIndexAny(name, "/.")
+	if i < 0 {
+		return nil, fmt.Errorf("method %q: want service/method", name)
+	}
+	sd, err := s.Service(name[:i])
+	if err != nil {
+		return nil, err
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", sd.FullName(), name[i+1:])
+	}
+	return md, nil
+}
+
+// MethodPath returns the path the method is called by, /pkg.Service/Method.
+func MethodPath(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// WriteSummary writes the services with their methods.
+func (s *Source) WriteSummary(w io.Writer) {
+	for _, name := range s.Services {
+		sd, err := s.Service(name)
+		if err != nil {
+			fmt.Fprintf(w, "%s (not described: %v)\n", name, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s (%s)\n", name, sd.ParentFile().Path())
+		methods := sd.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			fmt.Fprintf(w, "  %s\n", MethodSignature(methods.Get(i)))
+		}
+	}
+}
+
+// MethodSignature returns the rpc line of the method as in a .proto file,
+// without the trailing semicolon.
+func MethodSignature(m protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("rpc %s(%s%s) returns (%s%s)", m.Name(),
+		streamPrefix(m.IsStreamingClient()), m.Input().FullName(),
+		streamPrefix(m.IsStreamingServer()), m.Output().FullName())
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+// Package ecommerce implements the ProductInfo and OrderManagement services
+// on a shared store, so that a single server can host both of them.
+package ecommerce
+
+import (
+	"flag"
+	"fmt"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/store"
+	"google.golang.org/grpc"
+)
+
+// The names of the services.
+var (
+	ProductServiceName = ppb.ProductInfo_ServiceDesc.ServiceName
+	OrderServiceName   = opb.OrderManagement_ServiceDesc.ServiceName
+)
+
+// Flags are the command line flags choosing the subsystems of the server.
+type Flags struct {
+	Products     bool
+	Orders       bool
+	Health       bool
+	Reflection   bool
+	SampleOrders bool
+}
+
+// Register adds the flags to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.Products, "enable_products", true, "serve the ProductInfo service")
+	fs.BoolVar(&f.Orders, "enable_orders", true, "serve the OrderManagement service")
+	fs.BoolVar(&f.Health, "enable_health", true, "serve the gRPC health service")
+	fs.BoolVar(&f.Reflection, "enable_reflection", true, "serve the server reflection service")
+	fs.BoolVar(&f.SampleOrders, "sample_orders", true, "start with the sample orders in the store")
+}
+
+// Validate checks that at least one of the ecommerce services is enabled.
+func (f *Flags) Validate() error {
+	if !f.Products && !f.Orders {
+		return fmt.Errorf("enable_products, enable_orders: at least one service must be enabled")
+	}
+	return nil
+}
+
+// RegisterServices registers the enabled ecommerce services on gs and
+// returns their names. batch and abandoner configure the order service and
+// may be nil.
+func (f *Flags) RegisterServices(gs *grpc.Server, st *store.Store, batch *config.Batch, abandoner *cancellation.Abandoner) []string {
+	var names []string
+	if f.Products {
+		ppb.RegisterProductInfoServer(gs, NewProductService(st))
+		names = append(names, ProductServiceName)
+	}
+	if f.Orders {
+		opb.RegisterOrderManagementServer(gs, NewOrderService(st, batch, abandoner))
+		names = append(names, OrderServiceName)
+	}
+	return names
+}
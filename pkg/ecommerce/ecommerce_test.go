@@ -0,0 +1,339 @@
+package ecommerce_test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/ecommerce"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/eadydb/grpc-samples/pkg/store"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type clients struct {
+	products ppb.ProductInfoClient
+	orders   opb.OrderManagementClient
+	store    *store.Store
+}
+
+// fixtureStore returns a store holding the fixture orders and products.
+func fixtureStore(t *testing.T) *store.Store {
+	st := store.New()
+	for _, o := range grpctest.Orders {
+		st.PutOrders(&opb.Order{Id: o.ID, Items: o.Items, Description: o.Description, Price: o.Price, Destination: o.Destination})
+	}
+	for _, p := range grpctest.Products {
+		if _, err := st.AddProduct(&ppb.Product{Name: p.Name, Description: p.Description, Price: p.Price}); err != nil {
+			t.Fatalf("AddProduct: %v", err)
+		}
+	}
+	return st
+}
+
+// start serves both services on the fixture store, shipping batch orders
+// together.
+func start(t *testing.T, batch int, abandoner *cancellation.Abandoner, opts ...grpc.ServerOption) *clients {
+	st := fixtureStore(t)
+	f := ecommerce.Flags{Products: true, Orders: true}
+	conn := grpctest.Start(t, func(s *grpc.Server) {
+		f.RegisterServices(s, st, config.NewBatch(batch), abandoner)
+	}, opts...)
+	return &clients{products: ppb.NewProductInfoClient(conn), orders: opb.NewOrderManagementClient(conn), store: st}
+}
+
+func wantCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("got error %v, want code %v", err, want)
+	}
+}
+
+func TestProducts(t *testing.T) {
+	c := start(t, 1, nil)
+	ctx := grpctest.Context(t)
+
+	id, err := c.products.AddProduct(ctx, &ppb.Product{Name: "Nest Hub", Description: "display", Price: 99})
+	if err != nil {
+		t.Fatalf("AddProduct: %v", err)
+	}
+	p, err := c.products.GetProduct(ctx, id)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if p.Id != id.Value || p.Name != "Nest Hub" || p.Price != 99 {
+		t.Errorf("GetProduct returned %v", p)
+	}
+
+	_, err = c.products.GetProduct(ctx, &ppb.ProductID{Value: "missing"})
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestListProducts(t *testing.T) {
+	c := start(t, 1, nil)
+	stream, err := c.products.ListProducts(grpctest.Context(t), &ppb.ProductFilter{Name: "Pixel"})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	var names []string
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		names = append(names, p.Name)
+	}
+	if want := []string{"Pixel 7", "Pixel Buds"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %q, want %q", names, want)
+	}
+}
+
+func TestOrders(t *testing.T) {
+	c := start(t, 1, nil)
+	ctx := grpctest.Context(t)
+
+	got, err := c.orders.GetOrder(ctx, &wrappers.StringValue{Value: "202"})
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if want, _ := c.store.Order("202"); !proto.Equal(got, want) {
+		t.Errorf("GetOrder returned %v, want %v", got, want)
+	}
+
+	order := &opb.Order{Id: "301", Items: []string{"Chromecast"}, Destination: "Rome", Price: 30}
+	if _, err := c.orders.AddOrder(ctx, order); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if got, ok := c.store.Order("301"); !ok || !proto.Equal(got, order) {
+		t.Errorf("stored order is %v, want %v", got, order)
+	}
+
+	_, err = c.orders.GetOrder(ctx, &wrappers.StringValue{Value: "999"})
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestSearchOrders(t *testing.T) {
+	c := start(t, 1, nil)
+	stream, err := c.orders.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Pixel"})
+	if err != nil {
+		t.Fatalf("SearchOrders: %v", err)
+	}
+	var ids []string
+	for {
+		o, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		ids = append(ids, o.Id)
+	}
+	if want := []string{"201", "203"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("found orders %q, want %q", ids, want)
+	}
+}
+
+func TestUpdateOrders(t *testing.T) {
+	c := start(t, 1, nil)
+	stream, err := c.orders.UpdateOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("UpdateOrders: %v", err)
+	}
+	updates := []*opb.Order{
+		{Id: "201", Items: []string{"Pixel 7"}, Destination: "Madrid", Price: 599},
+		{Id: "202", Items: []string{"Kindle"}, Destination: "Madrid", Price: 120},
+	}
+	for _, o := range updates {
+		if err := stream.Send(o); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if res.Value == "" {
+		t.Error("UpdateOrders returned an empty summary")
+	}
+	for _, o := range updates {
+		if got, _ := c.store.Order(o.Id); got.GetDestination() != "Madrid" {
+			t.Errorf("order %s goes to %q after the update, want Madrid", o.Id, got.GetDestination())
+		}
+	}
+}
+
+func TestProcessOrders(t *testing.T) {
+	c := start(t, 2, nil)
+	stream, err := c.orders.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"201", "202", "203", "204"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	stream.CloseSend()
+
+	// Two batches of two orders, each shipped to both destinations.
+	shipped := make(map[string][]string)
+	n := 0
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		n++
+		for _, o := range s.OrderList {
+			shipped[s.Id] = append(shipped[s.Id], o.Id)
+		}
+	}
+	for _, ids := range shipped {
+		sort.Strings(ids)
+	}
+	want := map[string][]string{"cmb-Berlin": {"201", "203"}, "cmb-Paris": {"202", "204"}}
+	if n != 4 || !reflect.DeepEqual(shipped, want) {
+		t.Errorf("got %d shipments %v, want 4 shipments %v", n, shipped, want)
+	}
+}
+
+func TestProcessUnknownOrder(t *testing.T) {
+	c := start(t, 2, nil)
+	stream, err := c.orders.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	if err := stream.Send(&wrappers.StringValue{Value: "999"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	_, err = stream.Recv()
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	c := start(t, 1, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err := c.orders.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	wantCode(t, err, codes.DeadlineExceeded)
+}
+
+// recvCalls reports every RecvMsg call of the handlers on calls. Since the
+// order service reads one message at a time, a call means the handler is done
+// with the previous message.
+func recvCalls(calls chan<- struct{}) grpc.ServerOption {
+	return grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &notifyingStream{ServerStream: ss, calls: calls})
+	})
+}
+
+type notifyingStream struct {
+	grpc.ServerStream
+	calls chan<- struct{}
+}
+
+func (s *notifyingStream) RecvMsg(m interface{}) error {
+	s.calls <- struct{}{}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func TestCancelledProcessOrdersPersistsBatch(t *testing.T) {
+	pending := new(cancellation.MemoryStore)
+	abandoner := &cancellation.Abandoner{Policy: cancellation.Persist, Store: pending}
+	calls := make(chan struct{}, 10)
+	c := start(t, 3, abandoner, append(cancellation.ServerOptions(), recvCalls(calls))...)
+
+	ctx, cancel := context.WithCancel(grpctest.Context(t))
+	stream, err := c.orders.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"201", "202"} {
+		<-calls
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	// The handler asking for the third order has taken the first two into
+	// the batch.
+	<-calls
+	cancel()
+	_, err = stream.Recv()
+	wantCode(t, err, codes.Canceled)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pending.Batches()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no batch was persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b := pending.Batches()[0]
+	var items []string
+	for _, item := range b.Items {
+		items = append(items, string(item))
+	}
+	if want := []string{`"201"`, `"202"`}; b.Method != "processOrders" || !reflect.DeepEqual(items, want) {
+		t.Errorf("persisted %s with %q, want processOrders with %q", b.Method, items, want)
+	}
+}
+
+func TestReplayPendingBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending.jsonl")
+	fs, err := cancellation.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := cancellation.NewBatch("updateOrders", nil, &opb.Order{Id: "102", Destination: "Tokyo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := cancellation.NewBatch("cancelOrders", nil, &wrappers.StringValue{Value: "103"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*cancellation.Batch{update, unknown} {
+		if err := fs.Put(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := fixtureStore(t)
+	n, err := cancellation.Replay(path, ecommerce.NewOrderService(st, nil, nil).Replay)
+	if n != 1 || err == nil {
+		t.Fatalf("Replay = %d, %v; want 1 batch and an error for the unknown method", n, err)
+	}
+	if ord, _ := st.Order("102"); ord.GetDestination() != "Tokyo" {
+		t.Errorf("order 102 goes to %q after the replay, want Tokyo", ord.GetDestination())
+	}
+	left, err := cancellation.ReadBatches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Method != "cancelOrders" {
+		t.Errorf("journal keeps %d batches after the replay, want the cancelOrders one", len(left))
+	}
+}
@@ -0,0 +1,212 @@
+package ecommerce
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	pb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/cancellation"
+	"github.com/eadydb/grpc-samples/pkg/config"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"github.com/eadydb/grpc-samples/pkg/runner"
+	"github.com/eadydb/grpc-samples/pkg/store"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// OrderService implements OrderManagement on a store. The partial work of
+// cancelled streams goes to the abandoner, if any, and processOrders ships
+// the orders in batches of the batch policy, one order at a time without.
+type OrderService struct {
+	store     *store.Store
+	batch     *config.Batch
+	abandoner *cancellation.Abandoner
+	pb.UnimplementedOrderManagementServer
+}
+
+// NewOrderService returns the OrderManagement service of st. batch and
+// abandoner may be nil.
+func NewOrderService(st *store.Store, batch *config.Batch, abandoner *cancellation.Abandoner) *OrderService {
+	return &OrderService{store: st, batch: batch, abandoner: abandoner}
+}
+
+func (s *OrderService) batchSize() int {
+	if s.batch == nil {
+		return 1
+	}
+	return s.batch.Size()
+}
+
+func (s *OrderService) abandon(method string, cause error, msgs ...proto.Message) {
+	if s.abandoner != nil {
+		s.abandoner.Abandon(method, cause, msgs...)
+	}
+}
+
+func (s *OrderService) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.StringValue, error) {
+	if err := deadline.Err(ctx); err != nil {
+		return nil, err
+	}
+	s.store.PutOrders(orderReq)
+	logging.Debugf("Order : %s -> Added", orderReq.Id)
+	return &wrappers.StringValue{Value: "Order Added: " + orderReq.Id}, nil
+}
+
+func (s *OrderService) GetOrder(_ context.Context, orderId *wrappers.StringValue) (*pb.Order, error) {
+	if ord, ok := s.store.Order(orderId.Value); ok {
+		return ord, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "order does not exist. : %s", orderId.GetValue())
+}
+
+// Server-side Streaming RPC
+func (s *OrderService) SearchOrders(searchQuery *wrappers.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
+	for _, order := range s.store.SearchOrders(searchQuery.Value) {
+		// Stop searching as soon as nobody waits for the results.
+		if err := deadline.Err(stream.Context()); err != nil {
+			return err
+		}
+		if err := stream.Send(order); err != nil {
+			return fmt.Errorf("error sending message to stream : %v", err)
+		}
+		logging.Debugf("Matching Order Found: , %s", order.Id)
+	}
+	return nil
+}
+
+// Client-side streaming RPC
+func (s *OrderService) UpdateOrders(stream pb.OrderManagement_UpdateOrdersServer) error {
+	// The updates are applied together once the client has sent them all,
+	// so a cancelled stream leaves the orders untouched.
+	var updates []*pb.Order
+	cancellation.FromContext(stream.Context()).OnCancel(func(cause error) {
+		msgs := make([]proto.Message, len(updates))
+		for i, u := range updates {
+			msgs[i] = u
+		}
+		s.abandon("updateOrders", cause, msgs...)
+	})
+
+	orderStr := "Updated Order IDs: "
+	for {
+		order, err := stream.Recv()
+		if err == io.EOF {
+			s.store.PutOrders(updates...)
+			logging.Debugf("%d orders updated", len(updates))
+			return stream.SendAndClose(&wrappers.StringValue{Value: "Orders processed " + orderStr})
+		}
+		if err != nil {
+			return err
+		}
+		updates = append(updates, order)
+		orderStr += order.Id + ", "
+	}
+}
+
+// Bi-Directional Streaming RPC
+func (s *OrderService) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
+	batchMarker := 1
+	combinedShipmentMap := make(map[string]*pb.CombinedShipment)
+	// The order IDs of the batch not shipped yet.
+	var batchIds []proto.Message
+	processed := 0
+	cancellation.FromContext(stream.Context()).OnCancel(func(cause error) {
+		s.abandon("processOrders", cause, batchIds...)
+	})
+	ship := func() error {
+		for _, shipment := range combinedShipmentMap {
+			logging.Debugf("Shipping : %v -> %v", shipment.Id, len(shipment.OrderList))
+			if err := stream.Send(shipment); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	recv := runner.NewReceiver(stream.Context(), func() (interface{}, error) { return stream.Recv() })
+	defer recv.Stop()
+	for {
+		msg, err := recv.Recv()
+		if err == runner.ErrDraining {
+			// Ship what has been combined so far before the server goes away.
+			if err := ship(); err != nil {
+				return err
+			}
+			return runner.Drained(processed)
+		}
+		if err == io.EOF {
+			return ship()
+		}
+		if err != nil {
+			return err
+		}
+		// Messages may still arrive after the client cancelled; don't
+		// start on them.
+		if err := deadline.Err(stream.Context()); err != nil {
+			return err
+		}
+		orderId := msg.(*wrappers.StringValue)
+		ord, ok := s.store.Order(orderId.GetValue())
+		if !ok {
+			return status.Errorf(codes.NotFound, "order does not exist. : %s", orderId.GetValue())
+		}
+		batchIds = append(batchIds, orderId)
+		processed++
+
+		if shipment, found := combinedShipmentMap[ord.Destination]; found {
+			shipment.OrderList = append(shipment.OrderList, ord)
+		} else {
+			combinedShipmentMap[ord.Destination] = &pb.CombinedShipment{
+				Id:        "cmb-" + ord.Destination,
+				Status:    "Processed!",
+				OrderList: []*pb.Order{ord},
+			}
+		}
+
+		if batchMarker >= s.batchSize() {
+			if err := ship(); err != nil {
+				return err
+			}
+			batchMarker = 0
+			combinedShipmentMap = make(map[string]*pb.CombinedShipment)
+			batchIds = nil
+		}
+		batchMarker++
+	}
+}
+
+// Replay finishes a batch persisted by a cancelled call, e.g. one left in
+// the pending journal before a restart. The updates of an updateOrders batch
+// are applied; the orders of a processOrders batch cannot be shipped to a
+// client that is gone, so they are logged as left unshipped.
+func (s *OrderService) Replay(b *cancellation.Batch) error {
+	switch b.Method {
+	case "updateOrders":
+		updates := make([]*pb.Order, len(b.Items))
+		for i := range b.Items {
+			updates[i] = new(pb.Order)
+			if err := b.Unmarshal(i, updates[i]); err != nil {
+				return err
+			}
+		}
+		s.store.PutOrders(updates...)
+		logging.Infof("updateOrders: applied %d pending updates", len(updates))
+	case "processOrders":
+		ids := make([]string, len(b.Items))
+		for i := range b.Items {
+			var id wrappers.StringValue
+			if err := b.Unmarshal(i, &id); err != nil {
+				return err
+			}
+			ids[i] = id.Value
+		}
+		logging.Infof("processOrders: orders %v of a cancelled batch were never shipped", ids)
+	default:
+		return fmt.Errorf("unknown method %q", b.Method)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package ecommerce
+
+import (
+	"context"
+
+	pb "github.com/eadydb/grpc-samples/ch02/proto"
+	"github.com/eadydb/grpc-samples/pkg/deadline"
+	"github.com/eadydb/grpc-samples/pkg/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ProductService implements ProductInfo on a store.
+type ProductService struct {
+	store *store.Store
+	pb.UnimplementedProductInfoServer
+}
+
+// NewProductService returns the ProductInfo service of st.
+func NewProductService(st *store.Store) *ProductService {
+	return &ProductService{store: st}
+}
+
+func (s *ProductService) AddProduct(_ context.Context, in *pb.Product) (*pb.ProductID, error) {
+	id, err := s.store.AddProduct(in)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error while generating Product ID: %v", err)
+	}
+	return &pb.ProductID{Value: id}, nil
+}
+
+func (s *ProductService) GetProduct(_ context.Context, in *pb.ProductID) (*pb.Product, error) {
+	if p, ok := s.store.Product(in.Value); ok {
+		return p, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "Product does not exist. : %s", in.Value)
+}
+
+// Server-side Streaming RPC
+func (s *ProductService) ListProducts(filter *pb.ProductFilter, stream pb.ProductInfo_ListProductsServer) error {
+	for _, p := range s.store.Products(filter.Name) {
+		if err := deadline.Err(stream.Context()); err != nil {
+			return err
+		}
+		if err := stream.Send(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
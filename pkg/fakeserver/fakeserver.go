@@ -0,0 +1,477 @@
+// Package fakeserver fakes the ecommerce services, or any services given by
+// their descriptors, for testing clients. Rules script the answer to each
+// method: responses matched to the request, errors with status details,
+// latency and streamed sequences. The calls received, with their metadata
+// and messages, are recorded for assertions.
+//
+// In a Go test, the fake is registered on a server like a generated
+// service, e.g. with grpctest:
+//
+//	fake := fakeserver.New()
+//	err := fake.Add(fakeserver.Rule{
+//		Method:    "getOrder",
+//		Match:     fakeserver.Message(&wrappers.StringValue{Value: "202"}),
+//		Responses: []fakeserver.Response{{Message: fakeserver.Message(order)}},
+//	})
+//	conn := grpctest.Start(t, fake.Register)
+//
+// The fakeserver command serves a JSON scenario file instead.
+package fakeserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/descsource"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Server is a fake of a set of services. Rules are tried in the order they
+// were added and the first one matching answers; calls no rule answers fail
+// with Unimplemented, except for the messages of bidirectional streams,
+// which are just recorded.
+type Server struct {
+	services []protoreflect.ServiceDescriptor
+	types    resolver
+
+	mu    sync.Mutex
+	rules []*rule
+	calls []*Call
+	log   func(*Call)
+}
+
+// Call is a call received by the server.
+type Call struct {
+	// Method is the path of the method, /pkg.Service/Method.
+	Method   string
+	Metadata metadata.MD
+	// Requests are the messages received so far.
+	Requests []proto.Message
+	// Status is the status the call ended with, nil while it runs.
+	Status *status.Status
+}
+
+// New returns a fake of ProductInfo and OrderManagement.
+func New() *Server {
+	return &Server{
+		services: []protoreflect.ServiceDescriptor{
+			ppb.File_product_info_proto.Services().ByName("ProductInfo"),
+			opb.File_order_management_proto.Services().ByName("OrderManagement"),
+		},
+		types: resolver{protoregistry.GlobalTypes},
+	}
+}
+
+// NewFromSource returns a fake of the services of src, such as those of a
+// descriptor set file. Their messages are dynamic.
+func NewFromSource(src *descsource.Source) (*Server, error) {
+	s := &Server{types: resolver{src.Types, protoregistry.GlobalTypes}}
+	for _, name := range src.Services {
+		sd, err := src.Service(name)
+		if err != nil {
+			return nil, fmt.Errorf("fakeserver: %v", err)
+		}
+		s.services = append(s.services, sd)
+	}
+	return s, nil
+}
+
+// Add appends rules after those of the server.
+func (s *Server) Add(rules ...Rule) error {
+	compiled, err := s.compileAll(rules)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, compiled...)
+	return nil
+}
+
+// Load replaces the rules of the server with those of sc. The rules are
+// left alone if any of the new ones is invalid.
+func (s *Server) Load(sc *Scenario) error {
+	compiled, err := s.compileAll(sc.Rules)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = compiled
+	return nil
+}
+
+func (s *Server) compileAll(rules []Rule) ([]*rule, error) {
+	var compiled []*rule
+	for i, r := range rules {
+		c, err := s.compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("fakeserver: rule %d: %v", i+1, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// Calls returns the calls received so far, in the order they started.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]Call, len(s.calls))
+	for i, c := range s.calls {
+		calls[i] = *c
+		calls[i].Requests = append([]proto.Message(nil), c.Requests...)
+	}
+	return calls
+}
+
+// Reset forgets the calls received so far.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// callLine is the JSON form of a call written by LogCalls.
+type callLine struct {
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Metadata metadata.MD       `json:"metadata,omitempty"`
+	Requests []json.RawMessage `json:"requests"`
+	Code     string            `json:"code"`
+	Message  string            `json:"message,omitempty"`
+}
+
+// LogCalls writes every call to w as a line of JSON once it ends.
+func (s *Server) LogCalls(w io.Writer) {
+	enc := json.NewEncoder(w)
+	opts := protojson.MarshalOptions{Resolver: s.types}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = func(c *Call) {
+		line := callLine{
+			Time:     time.Now(),
+			Method:   c.Method,
+			Metadata: c.Metadata,
+			Requests: []json.RawMessage{},
+			Code:     c.Status.Code().String(),
+			Message:  c.Status.Message(),
+		}
+		for _, m := range c.Requests {
+			b, err := opts.Marshal(m)
+			if err != nil {
+				b, _ = json.Marshal(err.Error())
+			}
+			line.Requests = append(line.Requests, b)
+		}
+		// Called with s.mu held, which keeps the lines whole.
+		enc.Encode(line)
+	}
+}
+
+// Register registers the fake services on gs.
+func (s *Server) Register(gs *grpc.Server) {
+	for _, sd := range s.services {
+		gs.RegisterService(s.serviceDesc(sd), s)
+	}
+}
+
+func (s *Server) serviceDesc(sd protoreflect.ServiceDescriptor) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    sd.ParentFile().Path(),
+	}
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if !md.IsStreamingClient() && !md.IsStreamingServer() {
+			desc.Methods = append(desc.Methods, grpc.MethodDesc{
+				MethodName: string(md.Name()),
+				Handler:    s.unaryHandler(md),
+			})
+			continue
+		}
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    string(md.Name()),
+			Handler:       s.streamHandler(md),
+			ServerStreams: md.IsStreamingServer(),
+			ClientStreams: md.IsStreamingClient(),
+		})
+	}
+	return desc
+}
+
+func (s *Server) unaryHandler(md protoreflect.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := s.newMessage(md.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			c := s.begin(ctx, md)
+			s.record(c, req.(proto.Message))
+			var res proto.Message
+			err := s.answer(ctx, md, s.pick(md, "", []proto.Message{req.(proto.Message)}),
+				func(h metadata.MD) error { return grpc.SetHeader(ctx, h) },
+				func(t metadata.MD) { grpc.SetTrailer(ctx, t) },
+				func(m proto.Message) error { res = m; return nil })
+			s.end(c, err)
+			if err != nil {
+				return nil, err
+			}
+			return res, nil
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: descsource.MethodPath(md)}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func (s *Server) streamHandler(md protoreflect.MethodDescriptor) grpc.StreamHandler {
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		c := s.begin(stream.Context(), md)
+		err := s.serveStream(md, stream, c)
+		s.end(c, err)
+		return err
+	}
+}
+
+func (s *Server) serveStream(md protoreflect.MethodDescriptor, stream grpc.ServerStream, c *Call) error {
+	ctx := stream.Context()
+	var reqs []proto.Message
+	recv := func() error {
+		req := s.newMessage(md.Input())
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		s.record(c, req)
+		reqs = append(reqs, req)
+		return nil
+	}
+	answer := func(r *rule) error {
+		return s.answer(ctx, md, r, stream.SetHeader, stream.SetTrailer,
+			func(m proto.Message) error { return stream.SendMsg(m) })
+	}
+
+	if !md.IsStreamingClient() {
+		if err := recv(); err != nil {
+			return err
+		}
+		return answer(s.pick(md, "", reqs))
+	}
+	for {
+		err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !md.IsStreamingServer() {
+			continue
+		}
+		if r := s.pick(md, OnMessage, reqs[len(reqs)-1:]); r != nil {
+			if err := answer(r); err != nil {
+				return err
+			}
+		}
+	}
+	if !md.IsStreamingServer() {
+		return answer(s.pick(md, "", reqs))
+	}
+	if r := s.pick(md, OnClose, reqs); r != nil {
+		return answer(r)
+	}
+	return nil
+}
+
+// pick returns the first rule of md answering the event on for one of
+// reqs, or nil.
+func (s *Server) pick(md protoreflect.MethodDescriptor, on string, reqs []proto.Message) *rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rules {
+		if r.method.FullName() != md.FullName() || r.on != on || (r.times > 0 && r.used >= r.times) {
+			continue
+		}
+		ok := r.match == nil
+		for _, req := range reqs {
+			if ok = ok || r.matches(req); ok {
+				break
+			}
+		}
+		if ok {
+			r.used++
+			return r
+		}
+	}
+	return nil
+}
+
+// answer plays r on a call of md, sending its responses with send.
+func (s *Server) answer(ctx context.Context, md protoreflect.MethodDescriptor, r *rule, setHeader func(metadata.MD) error, setTrailer func(metadata.MD), send func(proto.Message) error) error {
+	if r == nil {
+		return status.Errorf(codes.Unimplemented, "fakeserver: no rule answers this call of %s", descsource.MethodPath(md))
+	}
+	if err := sleep(ctx, r.delay); err != nil {
+		return err
+	}
+	if r.header.Len() > 0 {
+		// Fails once a stream has sent its header; there is nothing better
+		// to do with the rule's then.
+		setHeader(r.header)
+	}
+	if r.trailer.Len() > 0 {
+		setTrailer(r.trailer)
+	}
+	for _, res := range r.responses {
+		if err := sleep(ctx, res.delay); err != nil {
+			return err
+		}
+		if err := send(res.msg); err != nil {
+			return err
+		}
+	}
+	return r.err
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (s *Server) begin(ctx context.Context, md protoreflect.MethodDescriptor) *Call {
+	meta, _ := metadata.FromIncomingContext(ctx)
+	c := &Call{Method: descsource.MethodPath(md), Metadata: meta.Copy()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, c)
+	return c
+}
+
+func (s *Server) record(c *Call, req proto.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Requests = append(c.Requests, req)
+}
+
+func (s *Server) end(c *Call, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Status = status.Convert(err)
+	if s.log != nil {
+		s.log(c)
+	}
+}
+
+// findMethod returns the method named pkg.Service/Method, or just Method
+// if only one service has it.
+func (s *Server) findMethod(name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	service := ""
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		service, name = name[:i], name[i+1:]
+	}
+	var found []protoreflect.MethodDescriptor
+	for _, sd := range s.services {
+		if service != "" && string(sd.FullName()) != service {
+			continue
+		}
+		if md := sd.Methods().ByName(protoreflect.Name(name)); md != nil {
+			found = append(found, md)
+		}
+	}
+	switch len(found) {
+	case 0:
+		if service != "" {
+			name = service + "/" + name
+		}
+		return nil, fmt.Errorf("no method %s", name)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("several services have a method %s; name the service too", name)
+}
+
+// newMessage returns a new message of md, of the generated type if there is
+// one.
+func (s *Server) newMessage(md protoreflect.MessageDescriptor) proto.Message {
+	if mt, err := s.types.FindMessageByName(md.FullName()); err == nil {
+		return mt.New().Interface()
+	}
+	return dynamicpb.NewMessage(md)
+}
+
+// typeResolver finds message and extension types, as protojson needs.
+type typeResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// resolver looks types up in each of its resolvers in turn.
+type resolver []typeResolver
+
+func (r resolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	for _, t := range r {
+		if mt, err := t.FindMessageByName(name); err == nil {
+			return mt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	for _, t := range r {
+		if mt, err := t.FindMessageByURL(url); err == nil {
+			return mt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	for _, t := range r {
+		if xt, err := t.FindExtensionByName(name); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+func (r resolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	for _, t := range r {
+		if xt, err := t.FindExtensionByNumber(message, field); err == nil {
+			return xt, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
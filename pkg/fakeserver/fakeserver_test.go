@@ -0,0 +1,308 @@
+package fakeserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func start(t *testing.T, rules ...fakeserver.Rule) (opb.OrderManagementClient, *fakeserver.Server) {
+	fake := fakeserver.New()
+	if err := fake.Add(rules...); err != nil {
+		t.Fatal(err)
+	}
+	conn := grpctest.Start(t, fake.Register)
+	return opb.NewOrderManagementClient(conn), fake
+}
+
+func id(v string) json.RawMessage {
+	return fakeserver.Message(&wrappers.StringValue{Value: v})
+}
+
+func reply(m proto.Message) []fakeserver.Response {
+	return []fakeserver.Response{{Message: fakeserver.Message(m)}}
+}
+
+func TestUnaryMatch(t *testing.T) {
+	order := &opb.Order{Id: "202", Destination: "Paris"}
+	client, _ := start(t,
+		fakeserver.Rule{Method: "getOrder", Match: id("202"), Responses: reply(order)},
+		fakeserver.Rule{Method: "getOrder", Error: &fakeserver.Error{Code: codes.NotFound, Message: "no such order"}},
+	)
+	ctx := grpctest.Context(t)
+
+	got, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "202"})
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if !proto.Equal(got, order) {
+		t.Errorf("GetOrder returned %v, want %v", got, order)
+	}
+	_, err = client.GetOrder(ctx, &wrappers.StringValue{Value: "999"})
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "no such order" {
+		t.Errorf("GetOrder of another order returned %v, want NotFound", err)
+	}
+}
+
+func TestMatchIgnoresUnsetFields(t *testing.T) {
+	client, _ := start(t, fakeserver.Rule{
+		Method:    "addOrder",
+		Match:     json.RawMessage(`{"destination": "Paris"}`),
+		Responses: reply(&wrappers.StringValue{Value: "to Paris"}),
+	})
+	res, err := client.AddOrder(grpctest.Context(t), &opb.Order{Id: "301", Items: []string{"Kindle"}, Destination: "Paris"})
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if res.Value != "to Paris" {
+		t.Errorf("AddOrder returned %q", res.Value)
+	}
+	_, err = client.AddOrder(grpctest.Context(t), &opb.Order{Id: "302", Destination: "Rome"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("AddOrder without a rule returned %v, want Unimplemented", err)
+	}
+}
+
+func TestErrorDetailsAndTimes(t *testing.T) {
+	var sc fakeserver.Scenario
+	err := json.Unmarshal([]byte(`{"rules": [
+		{"method": "ecommerce.OrderManagement/addOrder", "times": 1, "error": {
+			"code": "UNAVAILABLE", "message": "try again",
+			"details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}]
+		}},
+		{"method": "addOrder", "responses": [{"message": "added"}]}
+	]}`), &sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := fakeserver.New()
+	if err := fake.Load(&sc); err != nil {
+		t.Fatal(err)
+	}
+	client := opb.NewOrderManagementClient(grpctest.Start(t, fake.Register))
+
+	_, err = client.AddOrder(grpctest.Context(t), &opb.Order{Id: "301"})
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable {
+		t.Fatalf("first AddOrder returned %v, want Unavailable", err)
+	}
+	if d := st.Details(); len(d) != 1 {
+		t.Errorf("got details %v, want RetryInfo", d)
+	} else if info, ok := d[0].(*epb.RetryInfo); !ok || info.RetryDelay.AsDuration() != 2*time.Second {
+		t.Errorf("got detail %v, want a retry delay of 2s", d[0])
+	}
+	res, err := client.AddOrder(grpctest.Context(t), &opb.Order{Id: "301"})
+	if err != nil || res.Value != "added" {
+		t.Errorf("second AddOrder returned %v, %v", res, err)
+	}
+}
+
+func TestServerStreamSequence(t *testing.T) {
+	client, _ := start(t, fakeserver.Rule{
+		Method: "searchOrders",
+		Responses: []fakeserver.Response{
+			{Message: fakeserver.Message(&opb.Order{Id: "1"})},
+			{Message: fakeserver.Message(&opb.Order{Id: "2"}), Delay: fakeserver.Duration(50 * time.Millisecond)},
+		},
+		Error: &fakeserver.Error{Code: codes.Aborted, Message: "stream broken"},
+	})
+	stream, err := client.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatalf("SearchOrders: %v", err)
+	}
+	start := time.Now()
+	var ids []string
+	for {
+		o, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.Aborted {
+				t.Errorf("stream ended with %v, want Aborted", err)
+			}
+			break
+		}
+		ids = append(ids, o.Id)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got orders %q, want %q", ids, want)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("the second order came after %v, want at least 50ms", d)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	client, fake := start(t,
+		fakeserver.Rule{Method: "updateOrders", Match: fakeserver.Message(&opb.Order{Id: "999"}),
+			Error: &fakeserver.Error{Code: codes.NotFound, Message: "order 999"}},
+		fakeserver.Rule{Method: "updateOrders", Responses: reply(&wrappers.StringValue{Value: "updated"})},
+	)
+	update := func(ids ...string) (*wrappers.StringValue, error) {
+		stream, err := client.UpdateOrders(grpctest.Context(t))
+		if err != nil {
+			t.Fatalf("UpdateOrders: %v", err)
+		}
+		for _, id := range ids {
+			if err := stream.Send(&opb.Order{Id: id}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+	if res, err := update("201", "202"); err != nil || res.Value != "updated" {
+		t.Errorf("UpdateOrders returned %v, %v", res, err)
+	}
+	if _, err := update("201", "999"); status.Code(err) != codes.NotFound {
+		t.Errorf("UpdateOrders with order 999 returned %v, want NotFound", err)
+	}
+	if calls := fake.Calls(); len(calls) != 2 || len(calls[0].Requests) != 2 {
+		t.Errorf("recorded %v, want two calls of two orders", calls)
+	}
+}
+
+func TestBidiStream(t *testing.T) {
+	shipment := func(id string) []fakeserver.Response { return reply(&opb.CombinedShipment{Id: id}) }
+	client, _ := start(t,
+		fakeserver.Rule{Method: "processOrders", Match: id("201"), Responses: shipment("cmb-Berlin")},
+		fakeserver.Rule{Method: "processOrders", On: fakeserver.OnClose, Responses: shipment("cmb-rest")},
+	)
+	stream, err := client.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	if err := stream.Send(&wrappers.StringValue{Value: "201"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if s, err := stream.Recv(); err != nil || s.Id != "cmb-Berlin" {
+		t.Fatalf("Recv after order 201 returned %v, %v", s, err)
+	}
+	// Unmatched messages get no answer.
+	if err := stream.Send(&wrappers.StringValue{Value: "202"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	stream.CloseSend()
+	var ids []string
+	for {
+		s, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		ids = append(ids, s.Id)
+	}
+	if want := []string{"cmb-rest"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got shipments %q after closing, want %q", ids, want)
+	}
+}
+
+func TestRecordsMetadataAndHeaders(t *testing.T) {
+	client, fake := start(t, fakeserver.Rule{
+		Method:    "getOrder",
+		Header:    map[string]string{"location": "guangzhou"},
+		Trailer:   map[string]string{"served-by": "fake"},
+		Responses: reply(&opb.Order{Id: "201"}),
+	})
+	ctx := metadata.AppendToOutgoingContext(grpctest.Context(t), "kn", "vn")
+	var header, trailer metadata.MD
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "201"}, grpc.Header(&header), grpc.Trailer(&trailer)); err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if got := header.Get("location"); !reflect.DeepEqual(got, []string{"guangzhou"}) {
+		t.Errorf("header location is %q", got)
+	}
+	if got := trailer.Get("served-by"); !reflect.DeepEqual(got, []string{"fake"}) {
+		t.Errorf("trailer served-by is %q", got)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("recorded %d calls, want 1", len(calls))
+	}
+	c := calls[0]
+	if c.Method != "/ecommerce.OrderManagement/getOrder" || c.Status.Code() != codes.OK {
+		t.Errorf("recorded %s ending with %v", c.Method, c.Status)
+	}
+	if got := c.Metadata.Get("kn"); !reflect.DeepEqual(got, []string{"vn"}) {
+		t.Errorf("recorded kn %q, want vn", got)
+	}
+	if len(c.Requests) != 1 || !proto.Equal(c.Requests[0], &wrappers.StringValue{Value: "201"}) {
+		t.Errorf("recorded requests %v", c.Requests)
+	}
+	fake.Reset()
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("recorded %d calls after Reset", len(calls))
+	}
+}
+
+func TestDelayHonoursDeadline(t *testing.T) {
+	client, _ := start(t, fakeserver.Rule{
+		Method:    "getOrder",
+		Delay:     fakeserver.Duration(5 * time.Second),
+		Responses: reply(&opb.Order{Id: "201"}),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("GetOrder returned %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("GetOrder took %v", d)
+	}
+}
+
+func TestLogCalls(t *testing.T) {
+	client, fake := start(t, fakeserver.Rule{Method: "getOrder", Error: &fakeserver.Error{Code: codes.NotFound}})
+	var buf bytes.Buffer
+	fake.LogCalls(&buf)
+	client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "201"})
+	var line struct {
+		Method   string
+		Requests []json.RawMessage
+		Code     string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	if line.Method != "/ecommerce.OrderManagement/getOrder" || line.Code != "NotFound" ||
+		len(line.Requests) != 1 || string(line.Requests[0]) != `"201"` {
+		t.Errorf("logged %s", buf.String())
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, tc := range []struct {
+		rule fakeserver.Rule
+		want string
+	}{
+		{fakeserver.Rule{Method: "cancelOrder"}, "no method cancelOrder"},
+		{fakeserver.Rule{Method: "getOrder"}, "needs a response or an error"},
+		{fakeserver.Rule{Method: "getOrder", Responses: append(reply(&opb.Order{}), reply(&opb.Order{})...)}, "one message"},
+		{fakeserver.Rule{Method: "getOrder", On: fakeserver.OnClose, Responses: reply(&opb.Order{})}, "not bidirectional"},
+		{fakeserver.Rule{Method: "getOrder", Match: json.RawMessage(`{"id": "1"}`), Responses: reply(&opb.Order{})}, "match"},
+		{fakeserver.Rule{Method: "searchOrders", Error: &fakeserver.Error{Code: codes.OK}}, "not an error"},
+	} {
+		err := fakeserver.New().Add(tc.rule)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Add(%+v) returned %v, want an error about %q", tc.rule, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package fakeserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Scenario is the script of a fake server, as read from a JSON file.
+type Scenario struct {
+	Rules []Rule `json:"rules"`
+}
+
+// ReadScenario reads a JSON scenario file.
+func ReadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fakeserver: %v", err)
+	}
+	var sc Scenario
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("fakeserver: parse %s: %v", path, err)
+	}
+	return &sc, nil
+}
+
+// The events a rule of a bidirectional streaming method answers.
+const (
+	OnMessage = "message"
+	OnClose   = "close"
+)
+
+// Rule answers the calls of a method. Messages are given in their JSON
+// form; Message converts a Go message.
+type Rule struct {
+	// Method is the method, as "ecommerce.OrderManagement/getOrder" or just
+	// "getOrder" when no other service has a method of that name.
+	Method string `json:"method"`
+	// Match selects the requests the rule answers: every field set in it
+	// must be equal in the request. A rule without Match answers every
+	// request. The request of a client streaming call is matched if any of
+	// its messages is.
+	Match json.RawMessage `json:"match,omitempty"`
+	// On is the event a rule of a bidirectional streaming method answers:
+	// each message from the client, the default, or the client closing its
+	// side of the stream. The other kinds answer the whole request.
+	On string `json:"on,omitempty"`
+	// Times limits how often the rule answers; zero means no limit. The
+	// rules after it answer once it is used up.
+	Times int `json:"times,omitempty"`
+	// Delay is waited before answering.
+	Delay Duration `json:"delay,omitempty"`
+	// Header and Trailer are sent with the answer.
+	Header  map[string]string `json:"header,omitempty"`
+	Trailer map[string]string `json:"trailer,omitempty"`
+	// Responses are sent in order. Unary and client streaming methods
+	// take one response or an error.
+	Responses []Response `json:"responses,omitempty"`
+	// Error, if set, fails the call after the responses.
+	Error *Error `json:"error,omitempty"`
+}
+
+// Response is a scripted response message.
+type Response struct {
+	Message json.RawMessage `json:"message"`
+	// Delay is waited before sending the message.
+	Delay Duration `json:"delay,omitempty"`
+}
+
+// Error is the status a call fails with.
+type Error struct {
+	// Code is a code name such as "NOT_FOUND" or a number.
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message,omitempty"`
+	// Details are google.protobuf.Any messages in their JSON form, e.g.
+	// {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}.
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// Duration is a duration such as "250ms" or "2s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Message returns the JSON form of m for a rule.
+func Message(m proto.Message) json.RawMessage {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		panic(fmt.Sprintf("fakeserver: %v", err))
+	}
+	return b
+}
+
+// rule is a Rule resolved against the services of a server.
+type rule struct {
+	method    protoreflect.MethodDescriptor
+	on        string
+	match     proto.Message
+	times     int
+	delay     time.Duration
+	header    metadata.MD
+	trailer   metadata.MD
+	responses []response
+	err       error
+
+	// used counts the answers, guarded by the server.
+	used int
+}
+
+type response struct {
+	msg   proto.Message
+	delay time.Duration
+}
+
+// compile resolves r against the methods and types of s.
+func (s *Server) compile(r Rule) (*rule, error) {
+	md, err := s.findMethod(r.Method)
+	if err != nil {
+		return nil, err
+	}
+	c := &rule{
+		method:  md,
+		on:      r.On,
+		times:   r.Times,
+		delay:   time.Duration(r.Delay),
+		header:  metadata.New(r.Header),
+		trailer: metadata.New(r.Trailer),
+	}
+	bidi := md.IsStreamingClient() && md.IsStreamingServer()
+	switch {
+	case !bidi && c.on != "":
+		return nil, fmt.Errorf("%s is not bidirectional and answers no %q events", r.Method, c.on)
+	case bidi && c.on == "":
+		c.on = OnMessage
+	case c.on != "" && c.on != OnMessage && c.on != OnClose:
+		return nil, fmt.Errorf("%s: unknown event %q, want %q or %q", r.Method, c.on, OnMessage, OnClose)
+	}
+	if r.Times < 0 {
+		return nil, fmt.Errorf("%s: negative times %d", r.Method, r.Times)
+	}
+
+	if len(r.Match) > 0 {
+		if c.match, err = s.unmarshal(md.Input(), r.Match); err != nil {
+			return nil, fmt.Errorf("%s: match: %v", r.Method, err)
+		}
+	}
+	for i, res := range r.Responses {
+		msg, err := s.unmarshal(md.Output(), res.Message)
+		if err != nil {
+			return nil, fmt.Errorf("%s: response %d: %v", r.Method, i+1, err)
+		}
+		c.responses = append(c.responses, response{msg: msg, delay: time.Duration(res.Delay)})
+	}
+	if r.Error != nil {
+		if c.err, err = s.statusError(r.Error); err != nil {
+			return nil, fmt.Errorf("%s: error: %v", r.Method, err)
+		}
+	}
+	if !md.IsStreamingServer() {
+		switch n := len(c.responses); {
+		case n > 1:
+			return nil, fmt.Errorf("%s returns one message, not %d", r.Method, n)
+		case n == 1 && c.err != nil:
+			return nil, fmt.Errorf("%s returns a message or an error, not both", r.Method)
+		case n == 0 && c.err == nil:
+			return nil, fmt.Errorf("%s needs a response or an error", r.Method)
+		}
+	}
+	return c, nil
+}
+
+func (s *Server) unmarshal(md protoreflect.MessageDescriptor, b json.RawMessage) (proto.Message, error) {
+	m := s.newMessage(md)
+	if err := (protojson.UnmarshalOptions{Resolver: s.types}).Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *Server) statusError(e *Error) (error, error) {
+	if e.Code == codes.OK {
+		return nil, fmt.Errorf("code OK is not an error")
+	}
+	st := status.New(e.Code, e.Message)
+	if len(e.Details) == 0 {
+		return st.Err(), nil
+	}
+	p := st.Proto()
+	for i, d := range e.Details {
+		a := new(anypb.Any)
+		if err := (protojson.UnmarshalOptions{Resolver: s.types}).Unmarshal(d, a); err != nil {
+			return nil, fmt.Errorf("detail %d: %v", i+1, err)
+		}
+		p.Details = append(p.Details, a)
+	}
+	return status.FromProto(p).Err(), nil
+}
+
+// matches reports whether every field set in r.match is equal in req.
+func (r *rule) matches(req proto.Message) bool {
+	if r.match == nil {
+		return true
+	}
+	// Compare the fields of the match with a copy of the request cut down
+	// to them.
+	got := proto.Clone(req).ProtoReflect()
+	want := r.match.ProtoReflect()
+	var extra []protoreflect.FieldDescriptor
+	got.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if !want.Has(fd) {
+			extra = append(extra, fd)
+		}
+		return true
+	})
+	for _, fd := range extra {
+		got.Clear(fd)
+	}
+	return proto.Equal(got.Interface(), r.match)
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// The standard error details are known to the gateway, so that they can
+	// be written as JSON.
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// HTTPStatus returns the HTTP status matching the gRPC code c.
+func HTTPStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// errorJSON is the body of a failed call.
+type errorJSON struct {
+	Code    codes.Code        `json:"code"`
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// errorBody returns the JSON body and the HTTP status reporting err.
+func errorBody(err error) ([]byte, int) {
+	st := status.Convert(err)
+	body := errorJSON{
+		Code:    st.Code(),
+		Status:  codeName(st.Code()),
+		Message: st.Message(),
+	}
+	for _, d := range st.Proto().GetDetails() {
+		b, err := marshaler.Marshal(d)
+		if err != nil {
+			// An unknown detail type is passed on undecoded.
+			b, _ = json.Marshal(map[string]interface{}{"@type": d.GetTypeUrl(), "value": d.GetValue()})
+		}
+		body.Details = append(body.Details, b)
+	}
+	b, _ := json.Marshal(body)
+	return b, HTTPStatus(st.Code())
+}
+
+// codeName returns the name of c as in the google.rpc.Code enum, e.g.
+// NOT_FOUND.
+func codeName(c codes.Code) string {
+	var b strings.Builder
+	lower := false
+	for _, r := range c.String() {
+		upper := r >= 'A' && r <= 'Z'
+		if upper && lower {
+			b.WriteByte('_')
+		}
+		lower = !upper
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// writeError answers the request with the status of err. The metadata of
+// the call, if any, is sent along.
+func writeError(w http.ResponseWriter, md *callMetadata, err error) {
+	b, code := errorBody(err)
+	md.writeHeader(w)
+	md.writeTrailer(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+// writeStreamError ends a streamed response that has already started with
+// a line reporting err.
+func writeStreamError(w http.ResponseWriter, err error) {
+	b, _ := errorBody(err)
+	w.Write([]byte(`{"error":` + string(b) + "}\n"))
+}
@@ -0,0 +1,229 @@
+// Package gateway translates HTTP/JSON requests into calls of the
+// OrderManagement and ProductInfo services, for clients that cannot speak
+// gRPC:
+//
+//	POST /v1/orders            addOrder, the body is an Order
+//	GET  /v1/orders/{id}       getOrder
+//	GET  /v1/orders:search?q=  searchOrders, answered as newline delimited JSON
+//	POST /v1/products          addProduct, the body is a Product
+//	GET  /v1/products/{id}     getProduct
+//
+// Messages are encoded with protojson. Failed calls are answered with the
+// HTTP status matching the gRPC code and a JSON body holding the status and
+// its details. The Authorization header and headers prefixed with
+// Grpc-Metadata- are forwarded as request metadata; the response metadata
+// comes back as Grpc-Metadata- headers and, for trailers, Grpc-Trailer-
+// headers. A search failing after the first results ends with a line
+// {"error": {...}}.
+package gateway
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBody bounds the size of request bodies.
+const maxBody = 1 << 20
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true}
+	unmarshaler = protojson.UnmarshalOptions{}
+)
+
+// Gateway is an http.Handler calling the services behind a connection.
+type Gateway struct {
+	orders   opb.OrderManagementClient
+	products ppb.ProductInfoClient
+	mux      *http.ServeMux
+}
+
+// New returns a gateway to the services behind conn.
+func New(conn grpc.ClientConnInterface) *Gateway {
+	g := &Gateway{
+		orders:   opb.NewOrderManagementClient(conn),
+		products: ppb.NewProductInfoClient(conn),
+		mux:      http.NewServeMux(),
+	}
+	g.mux.HandleFunc("/v1/orders", g.addOrder)
+	g.mux.HandleFunc("/v1/orders/", g.getOrder)
+	g.mux.HandleFunc("/v1/orders:search", g.searchOrders)
+	g.mux.HandleFunc("/v1/products", g.addProduct)
+	g.mux.HandleFunc("/v1/products/", g.getProduct)
+	return g
+}
+
+// ServeHTTP serves the REST routes.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gateway) addOrder(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	order := &opb.Order{}
+	if !readBody(w, r, order) {
+		return
+	}
+	var md callMetadata
+	resp, err := g.orders.AddOrder(outgoing(r), order, md.options()...)
+	writeResponse(w, &md, resp, err)
+}
+
+func (g *Gateway) getOrder(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	id, ok := pathID(w, r, "/v1/orders/")
+	if !ok {
+		return
+	}
+	var md callMetadata
+	resp, err := g.orders.GetOrder(outgoing(r), &wrappers.StringValue{Value: id}, md.options()...)
+	writeResponse(w, &md, resp, err)
+}
+
+func (g *Gateway) searchOrders(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	var md callMetadata
+	stream, err := g.orders.SearchOrders(outgoing(r), &wrappers.StringValue{Value: r.URL.Query().Get("q")}, md.options()...)
+	if err != nil {
+		writeError(w, &md, err)
+		return
+	}
+	// The status is only known once the first result or the end of the
+	// stream arrives.
+	order, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		writeError(w, &md, err)
+		return
+	}
+	md.header, _ = stream.Header()
+	md.writeHeader(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for err == nil {
+		if !writeLine(w, order) {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		order, err = stream.Recv()
+	}
+	md.trailer = stream.Trailer()
+	md.writeStreamTrailer(w)
+	if err != io.EOF {
+		// The response has started; the error becomes the last line.
+		writeStreamError(w, err)
+	}
+}
+
+func (g *Gateway) addProduct(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	product := &ppb.Product{}
+	if !readBody(w, r, product) {
+		return
+	}
+	var md callMetadata
+	resp, err := g.products.AddProduct(outgoing(r), product, md.options()...)
+	writeResponse(w, &md, resp, err)
+}
+
+func (g *Gateway) getProduct(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	id, ok := pathID(w, r, "/v1/products/")
+	if !ok {
+		return
+	}
+	var md callMetadata
+	resp, err := g.products.GetProduct(outgoing(r), &ppb.ProductID{Value: id}, md.options()...)
+	writeResponse(w, &md, resp, err)
+}
+
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	b, _ := errorBody(status.Errorf(codes.Unimplemented, "method %s not allowed on %s", r.Method, r.URL.Path))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write(append(b, '\n'))
+	return false
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	id, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+	if err != nil || id == "" || strings.Contains(id, "/") {
+		writeError(w, nil, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+		return "", false
+	}
+	return id, true
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, m proto.Message) bool {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err == nil {
+		err = unmarshaler.Unmarshal(b, m)
+	}
+	if err != nil {
+		writeError(w, nil, status.Errorf(codes.InvalidArgument, "invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, md *callMetadata, resp proto.Message, err error) {
+	if err != nil {
+		writeError(w, md, err)
+		return
+	}
+	b, err := marshaler.Marshal(resp)
+	if err != nil {
+		writeError(w, md, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return
+	}
+	md.writeHeader(w)
+	md.writeTrailer(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(b, '\n'))
+}
+
+func writeLine(w http.ResponseWriter, m proto.Message) bool {
+	b, err := marshaler.Marshal(m)
+	if err != nil {
+		writeStreamError(w, status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		return false
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err == nil
+}
+
+// outgoing returns the context of the call made for r, carrying the
+// forwarded headers as metadata.
+func outgoing(r *http.Request) context.Context {
+	return metadata.NewOutgoingContext(r.Context(), requestMetadata(r.Header))
+}
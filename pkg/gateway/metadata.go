@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	metadataPrefix = "Grpc-Metadata-"
+	trailerPrefix  = "Grpc-Trailer-"
+)
+
+// requestMetadata returns the metadata forwarded from the request headers.
+// Values of binary keys, ending in -bin, are base64 in the headers.
+func requestMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range h {
+		var key string
+		switch {
+		case name == "Authorization":
+			key = "authorization"
+		case strings.HasPrefix(name, metadataPrefix) && len(name) > len(metadataPrefix):
+			key = strings.ToLower(name[len(metadataPrefix):])
+		default:
+			continue
+		}
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				b, err := decodeBinary(v)
+				if err != nil {
+					continue
+				}
+				v = string(b)
+			}
+			md.Append(key, v)
+		}
+	}
+	return md
+}
+
+func decodeBinary(v string) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// callMetadata collects the response metadata of a call.
+type callMetadata struct {
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (md *callMetadata) options() []grpc.CallOption {
+	return []grpc.CallOption{grpc.Header(&md.header), grpc.Trailer(&md.trailer)}
+}
+
+// writeHeader sets the response header metadata as headers.
+func (md *callMetadata) writeHeader(w http.ResponseWriter) {
+	if md != nil {
+		setMetadata(w.Header(), metadataPrefix, md.header)
+	}
+}
+
+// writeTrailer sets the trailer metadata of a call answered in one piece as
+// headers.
+func (md *callMetadata) writeTrailer(w http.ResponseWriter) {
+	if md != nil {
+		setMetadata(w.Header(), trailerPrefix, md.trailer)
+	}
+}
+
+// writeStreamTrailer sends the trailer metadata as HTTP trailers, after a
+// streamed body.
+func (md *callMetadata) writeStreamTrailer(w http.ResponseWriter) {
+	if md != nil {
+		setMetadata(w.Header(), http.TrailerPrefix+trailerPrefix, md.trailer)
+	}
+}
+
+func setMetadata(h http.Header, prefix string, md metadata.MD) {
+	for key, values := range md {
+		// The transport's own headers are no metadata of the call.
+		if key == "content-type" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		name := prefix + textproto.CanonicalMIMEHeaderKey(key)
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			h.Add(name, v)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package grpctest
+
+// The fixtures are plain values rather than messages: every chapter has its
+// own copy of the ecommerce protos, and a test binary may link only one of
+// them. Tests turn them into the messages of their chapter.
+
+// Order is a fixture order.
+type Order struct {
+	ID          string
+	Items       []string
+	Description string
+	Price       float32
+	Destination string
+}
+
+// Orders are the fixture orders, ordered by ID. Orders 201 and 203 go to
+// Berlin, 202 and 204 to Paris; 201 and 203 have Pixel items, 202 and 204
+// Kindle ones.
+var Orders = []Order{
+	{ID: "201", Items: []string{"Pixel 7", "Pixel Case"}, Description: "phone", Price: 899, Destination: "Berlin"},
+	{ID: "202", Items: []string{"Kindle"}, Price: 120, Destination: "Paris"},
+	{ID: "203", Items: []string{"Pixel Buds"}, Price: 199, Destination: "Berlin"},
+	{ID: "204", Items: []string{"Echo Dot", "Kindle Cover"}, Price: 80, Destination: "Paris"},
+}
+
+// Product is a fixture product.
+type Product struct {
+	Name        string
+	Description string
+	Price       float32
+}
+
+// Products are the fixture products, ordered by name.
+var Products = []Product{
+	{Name: "Kindle", Description: "e-reader", Price: 120},
+	{Name: "Pixel 7", Description: "Android phone", Price: 599},
+	{Name: "Pixel Buds", Description: "earbuds", Price: 199},
+}
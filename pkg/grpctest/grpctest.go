@@ -0,0 +1,131 @@
+// Package grpctest runs gRPC servers on in-memory listeners for tests. A
+// test registers the services it wants, gets a connected client back and
+// leaves the cleanup to the test:
+//
+//	conn := grpctest.Start(t, func(s *grpc.Server) {
+//		pb.RegisterOrderManagementServer(s, &server{orderMap: orders})
+//	})
+//	client := pb.NewOrderManagementClient(conn)
+//
+// The fixtures of this package stand in for the sample data the servers
+// load at startup.
+package grpctest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+// Wait polls cond until it holds, failing the test if it does not within
+// five seconds. what describes the awaited condition in the failure.
+func Wait(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grpctest: timed out waiting for %s", what)
+		}
+	}
+}
+
+// Context returns a context for the calls of a test, timing out after five
+// seconds so that a hanging call fails the test instead of blocking it. It
+// is cancelled when the test ends.
+func Context(t testing.TB) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// Server is a gRPC server listening in memory.
+type Server struct {
+	*grpc.Server
+	Listener *bufconn.Listener
+}
+
+// NewServer starts a server with opts and the services added by register.
+// The server is stopped when the test ends.
+func NewServer(t testing.TB, register func(*grpc.Server), opts ...grpc.ServerOption) *Server {
+	t.Helper()
+	s := &Server{Server: grpc.NewServer(opts...), Listener: bufconn.Listen(bufSize)}
+	register(s.Server)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// A test over before Serve starts stops the server first.
+		if err := s.Serve(s.Listener); err != nil && err != grpc.ErrServerStopped {
+			t.Errorf("grpctest: serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		<-done
+	})
+	return s
+}
+
+// Dial connects to the server with opts; the connection is insecure unless
+// opts say otherwise. It is closed when the test ends.
+func (s *Server) Dial(t testing.TB, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return s.Listener.Dial()
+	}
+	opts = append([]grpc.DialOption{grpc.WithInsecure(), grpc.WithContextDialer(dialer)}, opts...)
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatalf("grpctest: dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Start starts a server with opts and the services added by register and
+// returns a connection to it.
+func Start(t testing.TB, register func(*grpc.Server), opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	return NewServer(t, register, opts...).Dial(t)
+}
+
+// DialBalanced connects to all the servers, spreading the calls over them
+// round robin. The servers are known by their keys, which are the addresses
+// the balancer sees.
+func DialBalanced(t testing.TB, servers map[string]*Server, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	var addrs []resolver.Address
+	for name := range servers {
+		addrs = append(addrs, resolver.Address{Addr: name})
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Addr < addrs[j].Addr })
+	r := manual.NewBuilderWithScheme("grpctest")
+	r.InitialState(resolver.State{Addresses: addrs})
+	dialer := func(_ context.Context, addr string) (net.Conn, error) {
+		s, ok := servers[addr]
+		if !ok {
+			return nil, fmt.Errorf("grpctest: no server %q", addr)
+		}
+		return s.Listener.Dial()
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(dialer),
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+	}, opts...)
+	conn, err := grpc.Dial(r.Scheme()+":///balanced", opts...)
+	if err != nil {
+		t.Fatalf("grpctest: dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,419 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// ClientOptions configure a ClientConn.
+type ClientOptions struct {
+	// HTTPClient makes the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Text selects the base64 text framing instead of the binary one.
+	Text bool
+	// Header is added to every request, e.g. an Origin header to try the
+	// cross-origin rules of a server.
+	Header http.Header
+}
+
+// ClientConn calls the services of a gRPC-Web server. It implements
+// grpc.ClientConnInterface, so the generated clients can use it, e.g.
+//
+//	conn := grpcweb.NewClientConn("http://localhost:8080", grpcweb.ClientOptions{})
+//	client := pb.NewOrderManagementClient(conn)
+//
+// Only the grpc.Header and grpc.Trailer call options are supported.
+type ClientConn struct {
+	baseURL string
+	opts    ClientOptions
+}
+
+// NewClientConn returns a connection to the server at baseURL.
+func NewClientConn(baseURL string, opts ClientOptions) *ClientConn {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	return &ClientConn{baseURL: strings.TrimSuffix(baseURL, "/"), opts: opts}
+}
+
+// Invoke makes a unary call.
+func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	s := c.newStream(ctx, method)
+	defer s.close()
+	err := s.SendMsg(args)
+	if err == nil {
+		if err = s.RecvMsg(reply); err == io.EOF {
+			err = status.Error(codes.Internal, "grpcweb: no response to a unary call")
+		}
+	}
+	if err == nil {
+		// Read up to the trailers.
+		if err = s.RecvMsg(reply); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = status.Error(codes.Internal, "grpcweb: more than one response to a unary call")
+		}
+	}
+	applyCallOptions(s, opts)
+	return err
+}
+
+// NewStream starts a server streaming call. gRPC-Web has no client
+// streaming.
+func (c *ClientConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return nil, status.Errorf(codes.Unimplemented, "grpcweb: %s streams from the client, which gRPC-Web does not support", method)
+	}
+	s := c.newStream(ctx, method)
+	s.callOpts = opts
+	return s, nil
+}
+
+func applyCallOptions(s *clientStream, opts []grpc.CallOption) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr, _ = s.Header()
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = s.Trailer()
+		}
+	}
+}
+
+func (c *ClientConn) newStream(ctx context.Context, method string) *clientStream {
+	ctx, cancel := context.WithCancel(ctx)
+	return &clientStream{conn: c, ctx: ctx, cancel: cancel, method: method, headerReady: make(chan struct{})}
+}
+
+// clientStream is a call; the request goes out with the first message.
+type clientStream struct {
+	conn     *ClientConn
+	ctx      context.Context
+	cancel   context.CancelFunc
+	method   string
+	callOpts []grpc.CallOption
+
+	sent        bool
+	body        io.ReadCloser
+	headerReady chan struct{}
+	header      metadata.MD
+	headerErr   error
+
+	mu      sync.Mutex
+	trailer metadata.MD
+	done    bool
+	err     error
+}
+
+func (s *clientStream) Context() context.Context { return s.ctx }
+
+// Header waits for the response headers.
+func (s *clientStream) Header() (metadata.MD, error) {
+	select {
+	case <-s.headerReady:
+		return s.header, s.headerErr
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+func (s *clientStream) Trailer() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trailer
+}
+
+// CloseSend does nothing: the request is complete with its one message.
+func (s *clientStream) CloseSend() error { return nil }
+
+// SendMsg sends the request. It may only be called once; only problems with
+// the message itself are returned.
+func (s *clientStream) SendMsg(m interface{}) error {
+	if s.sent {
+		return status.Error(codes.Internal, "grpcweb: only one request message can be sent")
+	}
+	s.sent = true
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "grpcweb: %T is not a proto message", m)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return status.Errorf(codes.Internal, "grpcweb: failed to encode the request: %v", err)
+	}
+	body := encodeFrame(0, b)
+	ct := contentTypeBinary + "+proto"
+	if s.conn.opts.Text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+		ct = contentTypeText + "+proto"
+	}
+
+	s.start(body, ct)
+	return nil
+}
+
+// start makes the request. A failure is returned by the next RecvMsg, as
+// with grpc.
+func (s *clientStream) start(body []byte, ct string) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.conn.baseURL+s.method, bytes.NewReader(body))
+	if err != nil {
+		s.fail(status.Errorf(codes.Internal, "grpcweb: %v", err))
+		return
+	}
+	for name, values := range s.conn.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", ct)
+	req.Header.Set("Accept", ct)
+	req.Header.Set("X-Grpc-Web", "1")
+	if dl, ok := s.ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", encodeTimeout(time.Until(dl)))
+	}
+	md, _ := metadata.FromOutgoingContext(s.ctx)
+	for key, values := range md {
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			req.Header.Add(key, v)
+		}
+	}
+
+	resp, err := s.conn.opts.HTTPClient.Do(req)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			s.fail(status.FromContextError(s.ctx.Err()).Err())
+			return
+		}
+		s.fail(status.Errorf(codes.Unavailable, "grpcweb: %v", err))
+		return
+	}
+	s.header = responseMetadata(resp.Header)
+	close(s.headerReady)
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if st := headerStatus(resp.Header); st != nil {
+			s.fail(st.Err())
+			return
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		s.fail(status.Errorf(httpCode(resp.StatusCode), "grpcweb: HTTP status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg))))
+		return
+	}
+	s.body = resp.Body
+	if text, _, _ := webContentType(resp.Header.Get("Content-Type")); text {
+		s.body = struct {
+			*textReader
+			closer
+		}{newTextReader(resp.Body), closer{resp.Body}}
+	}
+	// A call ending right away may carry its status in the headers.
+	if st := headerStatus(resp.Header); st != nil {
+		err := st.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		s.end(err, s.header)
+	}
+}
+
+// RecvMsg receives the next response message. It returns io.EOF after the
+// last one of a successful call.
+func (s *clientStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	done, err := s.done, s.err
+	s.mu.Unlock()
+	if done {
+		return err
+	}
+	if !s.sent {
+		return s.fail(status.Error(codes.Internal, "grpcweb: RecvMsg before SendMsg"))
+	}
+	flag, payload, err := readFrame(s.body)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return s.end(status.FromContextError(s.ctx.Err()).Err(), nil)
+		}
+		if err == io.EOF {
+			return s.end(status.Error(codes.Internal, "grpcweb: response ended without a status"), nil)
+		}
+		return s.end(status.Errorf(codes.Internal, "grpcweb: %v", err), nil)
+	}
+	if flag&trailerFlag != 0 {
+		h, err := decodeTrailers(payload)
+		if err != nil {
+			return s.end(status.Error(codes.Internal, err.Error()), nil)
+		}
+		st := headerStatus(h)
+		if st == nil {
+			return s.end(status.Error(codes.Internal, "grpcweb: trailers without a status"), nil)
+		}
+		if st.Code() == codes.OK {
+			return s.end(io.EOF, responseMetadata(h))
+		}
+		return s.end(st.Err(), responseMetadata(h))
+	}
+	if flag != 0 {
+		return s.end(status.Error(codes.Internal, "grpcweb: compressed messages are not supported"), nil)
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return s.end(status.Errorf(codes.Internal, "grpcweb: %T is not a proto message", m), nil)
+	}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return s.end(status.Errorf(codes.Internal, "grpcweb: failed to decode the response: %v", err), nil)
+	}
+	return nil
+}
+
+// fail ends a call that got no response.
+func (s *clientStream) fail(err error) error {
+	select {
+	case <-s.headerReady:
+	default:
+		s.headerErr = err
+		close(s.headerReady)
+	}
+	return s.end(err, nil)
+}
+
+// end records the outcome of the call, applies the call options of a
+// stream and releases the response.
+func (s *clientStream) end(err error, trailer metadata.MD) error {
+	s.mu.Lock()
+	if s.done {
+		err = s.err
+		s.mu.Unlock()
+		return err
+	}
+	s.done, s.err, s.trailer = true, err, trailer
+	s.mu.Unlock()
+	if s.callOpts != nil {
+		applyCallOptions(s, s.callOpts)
+	}
+	s.close()
+	return err
+}
+
+func (s *clientStream) close() {
+	if s.body != nil {
+		s.body.Close()
+	}
+	s.cancel()
+}
+
+// headerStatus returns the status in h, if any.
+func headerStatus(h http.Header) *status.Status {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil {
+		return status.Newf(codes.Internal, "grpcweb: malformed grpc-status %q", v)
+	}
+	if b, err := decodeBinary(h.Get("Grpc-Status-Details-Bin")); err == nil && len(b) > 0 {
+		p := &spb.Status{}
+		if proto.Unmarshal(b, p) == nil && p.Code == int32(code) {
+			return status.FromProto(p)
+		}
+	}
+	return status.New(codes.Code(code), decodeMessage(h.Get("Grpc-Message")))
+}
+
+// responseMetadata returns the metadata of the call in the response
+// headers or trailers.
+func responseMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range h {
+		key := strings.ToLower(name)
+		switch {
+		case key == "content-type", key == "content-length", key == "date", key == "vary", key == "trailer",
+			strings.HasPrefix(key, "grpc-"), strings.HasPrefix(key, "access-control-"):
+			continue
+		}
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				b, err := decodeBinary(v)
+				if err != nil {
+					continue
+				}
+				v = string(b)
+			}
+			md.Append(key, v)
+		}
+	}
+	return md
+}
+
+func decodeBinary(v string) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// decodeMessage undoes the percent-encoding of grpc-message.
+func decodeMessage(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '%' && i+2 < len(v) {
+			if n, err := strconv.ParseUint(v[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+// encodeTimeout formats d as a grpc-timeout value.
+func encodeTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	if ms := d.Milliseconds(); ms < 1e8 {
+		if ms == 0 {
+			return strconv.FormatInt(d.Nanoseconds(), 10) + "n"
+		}
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+	return fmt.Sprintf("%dS", int64(d/time.Second))
+}
+
+// httpCode maps the HTTP status of a response that is no gRPC-Web answer
+// to a code, as gRPC does.
+func httpCode(s int) codes.Code {
+	switch s {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
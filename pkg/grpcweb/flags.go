@@ -0,0 +1,88 @@
+package grpcweb
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+)
+
+// ServerFlags are the command line flags enabling gRPC-Web.
+type ServerFlags struct {
+	Addr           string
+	AllowedOrigins string
+	AllowedHeaders string
+	MaxAge         time.Duration
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Addr, "grpcweb_addr", "", "address serving the services to gRPC-Web clients over HTTP/1.1, e.g. :8080; off when empty")
+	fs.StringVar(&f.AllowedOrigins, "grpcweb_allowed_origins", "", "comma separated origins of the pages allowed to call over gRPC-Web, or * for any")
+	fs.StringVar(&f.AllowedHeaders, "grpcweb_allowed_headers", "", "comma separated request headers, such as custom metadata, allowed in cross-origin calls")
+	fs.DurationVar(&f.MaxAge, "grpcweb_cors_max_age", 10*time.Minute, "how long browsers may cache the answers to preflight requests")
+}
+
+// Options returns the handler options of the flags.
+func (f *ServerFlags) Options() Options {
+	return Options{
+		AllowedOrigins: splitList(f.AllowedOrigins),
+		AllowedHeaders: splitList(f.AllowedHeaders),
+		MaxAge:         f.MaxAge,
+	}
+}
+
+// Server serves gRPC-Web next to a gRPC server.
+type Server struct {
+	hs *http.Server
+}
+
+// Start serves s to gRPC-Web clients in the background, over TLS when
+// tlsConfig is not nil. It returns nil when gRPC-Web is disabled.
+func (f *ServerFlags) Start(s *grpc.Server, tlsConfig *tls.Config) (*Server, error) {
+	if f.Addr == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", f.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("grpcweb: %v", err)
+	}
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+	}
+	hs := &http.Server{
+		Handler:           NewHandler(s, f.Options()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logging.Infof("Serving gRPC-Web on %s", lis.Addr())
+	go func() {
+		if err := hs.Serve(lis); err != http.ErrServerClosed {
+			logging.Errorf("grpcweb: %v", err)
+		}
+	}()
+	return &Server{hs: hs}, nil
+}
+
+// Stop closes the listener and the open connections.
+func (w *Server) Stop() {
+	if w == nil {
+		return
+	}
+	w.hs.Close()
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			l = append(l, p)
+		}
+	}
+	return l
+}
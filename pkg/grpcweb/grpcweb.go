@@ -0,0 +1,160 @@
+// Package grpcweb serves a grpc.Server to browsers over gRPC-Web, on plain
+// HTTP/1.1, and calls such servers from Go.
+//
+// Both framings of the protocol are supported: binary, with the content type
+// application/grpc-web+proto, and base64 text, application/grpc-web-text,
+// for clients that cannot read binary response bodies. Unary and server
+// streaming methods work; gRPC-Web has no client streaming. Since browsers
+// cannot read HTTP trailers, the status and the trailer metadata follow the
+// messages in the body as a frame of their own.
+//
+// Cross-origin calls are allowed for the configured origins only.
+package grpcweb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+const (
+	contentTypeBinary = "application/grpc-web"
+	contentTypeText   = "application/grpc-web-text"
+
+	// trailerFlag marks the frame holding the trailers.
+	trailerFlag = 0x80
+	// maxFrame bounds the size of frames read by the client.
+	maxFrame = 16 << 20
+)
+
+// webContentType splits a gRPC-Web content type into whether it is the text
+// framing and the codec suffix, e.g. "+proto". ok is false for other content
+// types.
+func webContentType(ct string) (text bool, codec string, ok bool) {
+	ct = strings.ToLower(strings.TrimSpace(strings.SplitN(ct, ";", 2)[0]))
+	switch {
+	case strings.HasPrefix(ct, contentTypeText):
+		text, codec = true, ct[len(contentTypeText):]
+	case strings.HasPrefix(ct, contentTypeBinary):
+		codec = ct[len(contentTypeBinary):]
+	default:
+		return false, "", false
+	}
+	if codec != "" && codec[0] != '+' {
+		return false, "", false
+	}
+	return text, codec, true
+}
+
+// encodeFrame returns the frame of a message, or of the trailers.
+func encodeFrame(flag byte, payload []byte) []byte {
+	b := make([]byte, 5+len(payload))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(payload)))
+	copy(b[5:], payload)
+	return b
+}
+
+// readFrame reads the next frame from r.
+func readFrame(r io.Reader) (flag byte, payload []byte, err error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxFrame {
+		return 0, nil, fmt.Errorf("grpcweb: frame of %d bytes exceeds the limit of %d", n, maxFrame)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// encodeTrailers returns the payload of the trailer frame, an HTTP/1 header
+// block with lower case names.
+func encodeTrailers(h http.Header) []byte {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range h[name] {
+			b.WriteString(strings.ToLower(name))
+			b.WriteString(": ")
+			b.WriteString(v)
+			b.WriteString("\r\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// decodeTrailers parses the payload of the trailer frame.
+func decodeTrailers(payload []byte) (http.Header, error) {
+	r := textproto.NewReader(bufio.NewReader(io.MultiReader(
+		strings.NewReader(string(payload)), strings.NewReader("\r\n"))))
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("grpcweb: malformed trailers: %v", err)
+	}
+	return http.Header(h), nil
+}
+
+// textReader decodes the base64 text framing. The encoder may pad each
+// chunk it flushes, so the input is decoded in independent quanta of four
+// characters.
+type textReader struct {
+	r   io.Reader
+	in  []byte
+	out []byte
+	err error
+}
+
+func newTextReader(r io.Reader) *textReader {
+	return &textReader{r: r}
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		if t.err != nil {
+			if t.err == io.EOF && len(t.in) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, t.err
+		}
+		buf := make([]byte, 4096)
+		n, err := t.r.Read(buf)
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				t.in = append(t.in, c)
+			}
+		}
+		t.err = err
+		whole := len(t.in) / 4 * 4
+		for i := 0; i < whole; i += 4 {
+			var dec [3]byte
+			m, err := base64.StdEncoding.Decode(dec[:], t.in[i:i+4])
+			if err != nil {
+				t.err = fmt.Errorf("grpcweb: malformed base64 body: %v", err)
+				break
+			}
+			t.out = append(t.out, dec[:m]...)
+		}
+		t.in = t.in[whole:]
+	}
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
@@ -0,0 +1,224 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// defaultAllowedHeaders are the request headers the gRPC-Web clients send.
+var defaultAllowedHeaders = []string{
+	"authorization", "content-type", "grpc-timeout", "x-grpc-web", "x-user-agent",
+}
+
+// Options configure the cross-origin calls of a Handler.
+type Options struct {
+	// AllowedOrigins are the origins of the pages that may call the
+	// services, e.g. https://shop.example.com, or "*" for any origin.
+	// Calls without an Origin header, which do not come from a browser,
+	// are always served.
+	AllowedOrigins []string
+	// AllowedHeaders are the request headers, such as custom metadata,
+	// allowed besides the ones of the gRPC-Web protocol.
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request.
+	MaxAge time.Duration
+}
+
+// Handler serves the services of a grpc.Server to gRPC-Web clients.
+type Handler struct {
+	server *grpc.Server
+	opts   Options
+}
+
+// NewHandler returns a handler for s.
+func NewHandler(s *grpc.Server, opts Options) *Handler {
+	return &Handler{server: s, opts: opts}
+}
+
+// IsGRPCWebRequest reports whether r is a gRPC-Web call or the preflight
+// request of one.
+func IsGRPCWebRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return isPreflight(r) && strings.Contains(strings.ToLower(r.Header.Get("Access-Control-Request-Headers")), "x-grpc-web")
+	}
+	_, _, ok := webContentType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && ok
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// ServeHTTP translates a gRPC-Web call into a gRPC call of the server.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin != "" && !h.originAllowed(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if isPreflight(r) {
+		h.preflight(w, r)
+		return
+	}
+	text, codec, ok := webContentType(r.Header.Get("Content-Type"))
+	if r.Method != http.MethodPost || !ok {
+		http.Error(w, "not a gRPC-Web request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// The server takes the call for one made over HTTP/2.
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2"
+	req.Header.Set("Content-Type", "application/grpc"+codec)
+	req.Header.Del("Content-Length")
+	if text {
+		req.Body = struct {
+			*textReader
+			closer
+		}{newTextReader(r.Body), closer{r.Body}}
+		req.ContentLength = -1
+	}
+
+	ct := contentTypeBinary + codec
+	if text {
+		ct = contentTypeText + codec
+	}
+	resp := &response{w: w, header: make(http.Header), contentType: ct, text: text, cors: origin != ""}
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	h.server.ServeHTTP(resp, req)
+	resp.finish()
+}
+
+type closer struct{ c interface{ Close() error } }
+
+func (c closer) Close() error { return c.c.Close() }
+
+func (h *Handler) originAllowed(origin string) bool {
+	for _, o := range h.opts.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	hdr.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	hdr.Add("Vary", "Origin")
+	hdr.Set("Access-Control-Allow-Methods", "POST")
+	hdr.Set("Access-Control-Allow-Headers", strings.Join(append(defaultAllowedHeaders, h.opts.AllowedHeaders...), ", "))
+	if h.opts.MaxAge > 0 {
+		hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(h.opts.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// response is the http.ResponseWriter handed to the server. It keeps the
+// server's headers apart, so that the trailers it adds after the body can be
+// sent as the trailer frame, and encodes the body for the text framing.
+type response struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	text        bool
+	cors        bool
+
+	wroteHeader bool
+	declared    []string // the trailers announced by the server
+	pending     []byte   // body not yet encoded for the text framing
+}
+
+func (r *response) Header() http.Header { return r.header }
+
+func (r *response) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	if code != http.StatusOK {
+		// A plain HTTP error, not a call; it is passed on as it is.
+		r.contentType = r.header.Get("Content-Type")
+		r.text = false
+	}
+	out := r.w.Header()
+	var exposed []string
+	for name, values := range r.header {
+		switch {
+		case name == "Trailer":
+			for _, v := range values {
+				r.declared = append(r.declared, http.CanonicalHeaderKey(v))
+			}
+			continue
+		case name == "Content-Type", strings.HasPrefix(name, http.TrailerPrefix), len(values) == 0:
+			continue
+		}
+		out[name] = values
+		exposed = append(exposed, strings.ToLower(name))
+	}
+	out.Set("Content-Type", r.contentType)
+	if r.cors {
+		sort.Strings(exposed)
+		out.Set("Access-Control-Expose-Headers", strings.Join(append([]string{"grpc-status", "grpc-message", "grpc-status-details-bin"}, exposed...), ", "))
+	}
+	r.w.WriteHeader(code)
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.text {
+		r.pending = append(r.pending, b...)
+		return len(b), nil
+	}
+	return r.w.Write(b)
+}
+
+// Flush sends what has been written so far; in the text framing, the
+// pending bytes are encoded as a padded chunk of their own.
+func (r *response) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.text && len(r.pending) > 0 {
+		r.w.Write([]byte(base64.StdEncoding.EncodeToString(r.pending)))
+		r.pending = r.pending[:0]
+	}
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the trailers the server set as the trailer frame.
+func (r *response) finish() {
+	trailers := make(http.Header)
+	for _, name := range r.declared {
+		if v, ok := r.header[name]; ok {
+			trailers[name] = v
+		}
+	}
+	for name, v := range r.header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			trailers[http.CanonicalHeaderKey(name[len(http.TrailerPrefix):])] = v
+		}
+	}
+	if len(trailers) == 0 && r.wroteHeader {
+		// The server failed before reaching the call, e.g. while stopping,
+		// and has answered with a plain HTTP error.
+		r.Flush()
+		return
+	}
+	r.Write(encodeFrame(trailerFlag, encodeTrailers(trailers)))
+	r.Flush()
+}
@@ -0,0 +1,44 @@
+package healthcheck
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ServerFlags are the command line flags configuring the health service.
+type ServerFlags struct {
+	Interval   time.Duration
+	Downstream string
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.DurationVar(&f.Interval, "health_check_interval", 5*time.Second, "how often the dependencies of the services are checked")
+	fs.StringVar(&f.Downstream, "health_downstream", "", "comma separated address/service pairs of downstream servers the services depend on, e.g. localhost:50052/ecommerce.ProductInfo; checked with TLS when the server uses TLS")
+}
+
+// Dependencies returns the downstream dependencies. Their connections are
+// dialed with opts and stay open for the life of the process.
+func (f *ServerFlags) Dependencies(opts ...grpc.DialOption) ([]Dependency, error) {
+	var deps []Dependency
+	for _, d := range strings.Split(f.Downstream, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		i := strings.Index(d, "/")
+		if i <= 0 {
+			return nil, fmt.Errorf("healthcheck: invalid downstream %q, want address/service", d)
+		}
+		conn, err := grpc.Dial(d[:i], opts...)
+		if err != nil {
+			return nil, fmt.Errorf("healthcheck: dial %s: %v", d[:i], err)
+		}
+		deps = append(deps, Remote(d, conn, d[i+1:]))
+	}
+	return deps, nil
+}
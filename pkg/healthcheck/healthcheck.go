@@ -0,0 +1,177 @@
+// Package healthcheck serves the standard grpc.health.v1.Health service with
+// a status per service name that follows the health of the service's
+// dependencies, such as a writable store or a reachable downstream server.
+// The dependencies are checked periodically; a service is SERVING while all
+// of its dependencies pass and NOT_SERVING otherwise.
+package healthcheck
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Dependency is something a service needs to work.
+type Dependency struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type service struct {
+	name    string
+	deps    []Dependency
+	failing map[string]error // failing dependencies by name
+}
+
+// Server tracks and serves the health of the services of a gRPC server.
+type Server struct {
+	interval time.Duration
+	timeout  time.Duration
+	hs       *health.Server
+
+	mu       sync.Mutex
+	services []*service
+	shutdown bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a server checking the dependencies every interval. A check
+// taking longer than interval fails.
+func New(interval time.Duration) *Server {
+	return &Server{
+		interval: interval,
+		timeout:  interval,
+		hs:       health.NewServer(),
+		stop:     make(chan struct{}),
+	}
+}
+
+// AddService tracks the health of name, which depends on deps. The service
+// is NOT_SERVING until its first check passed.
+func (s *Server) AddService(name string, deps ...Dependency) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services = append(s.services, &service{name: name, deps: deps, failing: make(map[string]error)})
+	s.hs.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Register registers the health service on gs.
+func (s *Server) Register(gs *grpc.Server) {
+	healthpb.RegisterHealthServer(gs, s.hs)
+}
+
+// Start checks the dependencies in the background, now and then every
+// interval until Stop. The services stay NOT_SERVING until the first check
+// passed, so a slow dependency does not hold up the start of the server.
+func (s *Server) Start() {
+	go func() {
+		s.checkAll()
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.checkAll()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking the dependencies.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Shutdown reports every service as NOT_SERVING for good, telling the
+// clients to go elsewhere while the server drains.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
+	s.hs.Shutdown()
+}
+
+func (s *Server) checkAll() {
+	s.mu.Lock()
+	services := append([]*service(nil), s.services...)
+	s.mu.Unlock()
+
+	// The empty service name stands for the server as a whole.
+	overall := healthpb.HealthCheckResponse_SERVING
+	for _, svc := range services {
+		if st := s.check(svc); st != healthpb.HealthCheckResponse_SERVING {
+			overall = st
+		}
+	}
+	s.setStatus("", overall)
+}
+
+func (s *Server) check(svc *service) healthpb.HealthCheckResponse_ServingStatus {
+	for _, d := range svc.deps {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err := d.Check(ctx)
+		cancel()
+
+		prev, wasFailing := svc.failing[d.Name]
+		switch {
+		case err != nil && (!wasFailing || prev.Error() != err.Error()):
+			logging.Warnf("health: %s: dependency %s failing: %v", svc.name, d.Name, err)
+			svc.failing[d.Name] = err
+		case err == nil && wasFailing:
+			logging.Infof("health: %s: dependency %s recovered", svc.name, d.Name)
+			delete(svc.failing, d.Name)
+		}
+	}
+	st := healthpb.HealthCheckResponse_SERVING
+	if len(svc.failing) > 0 {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	s.setStatus(svc.name, st)
+	return st
+}
+
+func (s *Server) setStatus(name string, st healthpb.HealthCheckResponse_ServingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// After Shutdown the health server ignores updates anyway; skipping
+	// them keeps it that way should that ever change.
+	if !s.shutdown {
+		s.hs.SetServingStatus(name, st)
+	}
+}
+
+// Remote returns a dependency on service of the server behind conn, which
+// must itself serve the health service.
+func Remote(name string, conn *grpc.ClientConn, service string) Dependency {
+	client := healthpb.NewHealthClient(conn)
+	return Dependency{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				return err
+			}
+			if resp.Status != healthpb.HealthCheckResponse_SERVING {
+				return &notServingError{service: service, status: resp.Status}
+			}
+			return nil
+		},
+	}
+}
+
+type notServingError struct {
+	service string
+	status  healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (e *notServingError) Error() string {
+	return e.service + " is " + e.status.String()
+}
@@ -0,0 +1,71 @@
+package loadshed
+
+import (
+	"expvar"
+	"flag"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// metrics publishes the state of every limiter under its name, e.g.
+// "loadshed": {"order-server": {"limit": 42, ...}}.
+var metrics = expvar.NewMap("loadshed")
+
+// Publish exports the limiter's Stats as metrics under name.
+func (l *Limiter) Publish(name string) {
+	metrics.Set(name, expvar.Func(func() interface{} { return l.Stats() }))
+}
+
+// DefaultPriorities sheds order and product reads before writes, and keeps
+// order processing streams until the very end.
+const DefaultPriorities = "/ecommerce.OrderManagement/getOrder=low," +
+	"/ecommerce.OrderManagement/searchOrders=low," +
+	"/ecommerce.ProductInfo/getProduct=low," +
+	"/ecommerce.OrderManagement/processOrders=critical"
+
+// ServerFlags are the command line flags enabling adaptive concurrency
+// limiting on a server.
+type ServerFlags struct {
+	InitialLimit  int
+	MinLimit      int
+	MaxLimit      int
+	TargetLatency time.Duration
+	Backoff       float64
+	Priorities    string
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.IntVar(&f.InitialLimit, "concurrency_limit", 0, "initial adaptive concurrency limit; load shedding is off when 0")
+	fs.IntVar(&f.MinLimit, "concurrency_min_limit", 4, "lowest the adaptive concurrency limit may drop to")
+	fs.IntVar(&f.MaxLimit, "concurrency_max_limit", 1000, "highest the adaptive concurrency limit may grow to")
+	fs.DurationVar(&f.TargetLatency, "concurrency_target_latency", 100*time.Millisecond, "unary calls slower than this shrink the concurrency limit")
+	fs.Float64Var(&f.Backoff, "concurrency_backoff", 0.9, "factor applied to the concurrency limit after a slow call")
+	fs.StringVar(&f.Priorities, "method_priorities", DefaultPriorities, "comma separated method=priority defaults; callers may only lower a call's priority with "+PriorityKey+" metadata")
+}
+
+// ServerOptions returns the options installing a limiter whose metrics are
+// published under name, or none when load shedding is disabled.
+func (f *ServerFlags) ServerOptions(name string) ([]grpc.ServerOption, error) {
+	if f.InitialLimit == 0 {
+		return nil, nil
+	}
+	priorities, err := ParseMethodPriorities(f.Priorities)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLimiter(Config{
+		InitialLimit:  f.InitialLimit,
+		MinLimit:      f.MinLimit,
+		MaxLimit:      f.MaxLimit,
+		TargetLatency: f.TargetLatency,
+		Backoff:       f.Backoff,
+		Priorities:    priorities,
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.Publish(name)
+	return l.ServerOptions(), nil
+}
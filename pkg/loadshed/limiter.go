@@ -0,0 +1,169 @@
+// Package loadshed protects servers from overload with an adaptive
+// concurrency limit. The limit follows the latency of unary calls with AIMD:
+// it grows by one for every limit calls answered within the target latency
+// and shrinks by a constant factor when calls get slow or time out. Calls
+// beyond the share of the limit their priority may use are rejected with
+// Unavailable, so low priority reads are shed before critical streams.
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Config tunes a Limiter.
+type Config struct {
+	InitialLimit  int
+	MinLimit      int
+	MaxLimit      int
+	TargetLatency time.Duration // unary calls slower than this shrink the limit
+	Backoff       float64       // factor applied to the limit on a slow call
+	Priorities    []MethodPriority
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.MinLimit < 1:
+		return fmt.Errorf("loadshed: min limit %d must be at least 1", c.MinLimit)
+	case c.MaxLimit < c.MinLimit:
+		return fmt.Errorf("loadshed: max limit %d is below min limit %d", c.MaxLimit, c.MinLimit)
+	case c.InitialLimit < c.MinLimit || c.InitialLimit > c.MaxLimit:
+		return fmt.Errorf("loadshed: initial limit %d is outside [%d, %d]", c.InitialLimit, c.MinLimit, c.MaxLimit)
+	case c.TargetLatency <= 0:
+		return fmt.Errorf("loadshed: target latency must be positive")
+	case c.Backoff <= 0 || c.Backoff >= 1:
+		return fmt.Errorf("loadshed: backoff %g must be between 0 and 1", c.Backoff)
+	}
+	return nil
+}
+
+// Limiter is an AIMD concurrency limiter.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	admitted uint64
+	rejected [Critical + 1]uint64
+	lastDrop time.Time
+}
+
+// NewLimiter returns a limiter starting at the initial limit of c.
+func NewLimiter(c Config) (*Limiter, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{cfg: c, limit: float64(c.InitialLimit), now: time.Now}, nil
+}
+
+// Acquire admits a call of priority p or returns an Unavailable status error.
+// Admitted calls must call the returned function when they complete.
+func (l *Limiter) Acquire(fullMethod string, p Priority) (func(sample bool, err error), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(l.inflight) >= l.limit*p.share() {
+		l.rejected[p]++
+		return nil, status.Errorf(codes.Unavailable, "server overloaded: %d calls in flight, %s priority call to %s shed", l.inflight, p, fullMethod)
+	}
+	l.inflight++
+	l.admitted++
+	start := l.now()
+	return func(sample bool, err error) {
+		l.release(start, sample, err)
+	}, nil
+}
+
+func (l *Limiter) release(start time.Time, sample bool, err error) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	if !sample {
+		return
+	}
+	slow := now.Sub(start) > l.cfg.TargetLatency || status.Code(err) == codes.DeadlineExceeded
+	switch {
+	case slow:
+		// Calls started before the last decrease still reflect the old
+		// limit; shrinking again for each of them would collapse it.
+		if start.Before(l.lastDrop) {
+			return
+		}
+		l.lastDrop = now
+		l.limit *= l.cfg.Backoff
+		if l.limit < float64(l.cfg.MinLimit) {
+			l.limit = float64(l.cfg.MinLimit)
+		}
+	case float64(inflight)*2 >= l.limit:
+		// Only grow while the limit is actually in use.
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.cfg.MaxLimit) {
+			l.limit = float64(l.cfg.MaxLimit)
+		}
+	}
+}
+
+// Stats is a snapshot of the limiter state.
+type Stats struct {
+	Limit    int               `json:"limit"`
+	InFlight int               `json:"in_flight"`
+	Admitted uint64            `json:"admitted"`
+	Rejected map[string]uint64 `json:"rejected"`
+}
+
+// Stats returns the current limit and counters.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := Stats{Limit: int(l.limit), InFlight: l.inflight, Admitted: l.admitted, Rejected: make(map[string]uint64)}
+	for p, n := range l.rejected {
+		s.Rejected[Priority(p).String()] = n
+	}
+	return s
+}
+
+// UnaryServerInterceptor limits unary calls and feeds their latency into
+// the limit.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := l.Acquire(info.FullMethod, priority(ctx, info.FullMethod, l.cfg.Priorities))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		done(true, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor limits streams. A stream occupies a slot while it
+// is open, but its lifetime says nothing about the server's latency, so it
+// does not move the limit.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := l.Acquire(info.FullMethod, priority(ss.Context(), info.FullMethod, l.cfg.Priorities))
+		if err != nil {
+			return err
+		}
+		err = handler(srv, ss)
+		done(false, err)
+		return err
+	}
+}
+
+// ServerOptions returns the options installing both interceptors.
+func (l *Limiter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(l.StreamServerInterceptor()),
+	}
+}
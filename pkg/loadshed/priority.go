@@ -0,0 +1,117 @@
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+	"google.golang.org/grpc/metadata"
+)
+
+// PriorityKey is the metadata key carrying the priority of a call.
+const PriorityKey = "x-priority"
+
+// Priority orders calls for shedding: under overload the lowest priorities
+// are rejected first.
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+	Critical
+)
+
+var priorityNames = [...]string{"low", "normal", "high", "critical"}
+
+func (p Priority) String() string {
+	if p < Low || p > Critical {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// share is the fraction of the concurrency limit calls of priority p may
+// fill. Low priority calls are turned away while there is still room left
+// for the more important ones.
+func (p Priority) share() float64 {
+	switch p {
+	case Critical:
+		return 1
+	case High:
+		return 0.9
+	case Normal:
+		return 0.75
+	}
+	return 0.5
+}
+
+// ParsePriority parses a priority name.
+func ParsePriority(s string) (Priority, error) {
+	for i, n := range priorityNames {
+		if strings.EqualFold(s, n) {
+			return Priority(i), nil
+		}
+	}
+	return Normal, fmt.Errorf("unknown priority %q, want low, normal, high or critical", s)
+}
+
+// WithPriority returns a context whose outgoing calls carry p. Servers only
+// honour it below the default priority of the method called.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PriorityKey, p.String())
+}
+
+// MethodPriority assigns a default priority to the methods it matches.
+// Method is a full method name, a "/package.Service/*" wildcard or "*".
+type MethodPriority struct {
+	Method   string
+	Priority Priority
+}
+
+// ParseMethodPriorities parses a comma separated list of method=priority
+// pairs, e.g. "/ecommerce.OrderManagement/getOrder=low,*=normal".
+func ParseMethodPriorities(s string) ([]MethodPriority, error) {
+	var mps []MethodPriority
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.LastIndex(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid method priority %q, want method=priority", kv)
+		}
+		if err := methodmatch.Validate(kv[:i]); err != nil {
+			return nil, err
+		}
+		p, err := ParsePriority(kv[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		mps = append(mps, MethodPriority{Method: kv[:i], Priority: p})
+	}
+	return mps, nil
+}
+
+// priority returns the priority of an incoming call: the first matching
+// method default, else Normal. A caller may lower the priority of its call
+// with PriorityKey metadata but never raise it above that default, or any
+// caller could claim critical and skip shedding.
+func priority(ctx context.Context, fullMethod string, defaults []MethodPriority) Priority {
+	p := Normal
+	for i := range defaults {
+		if methodmatch.Match(defaults[i].Method, fullMethod) {
+			p = defaults[i].Priority
+			break
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(PriorityKey); len(v) > 0 {
+		if claimed, err := ParsePriority(v[0]); err == nil && claimed < p {
+			return claimed
+		}
+	}
+	return p
+}
@@ -0,0 +1,90 @@
+// Package logging adds levels to the standard logger for the messages of the
+// shared packages, so that a busy server can be made quieter, or more
+// verbose, without a restart. The commands' own output is not affected.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a message.
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q, want one of %s", s, strings.Join(levelNames, ", "))
+}
+
+var current = int32(Info)
+
+// SetLevel sets the lowest level that is logged.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&current, int32(l))
+}
+
+// CurrentLevel returns the lowest level that is logged.
+func CurrentLevel() Level {
+	return Level(atomic.LoadInt32(&current))
+}
+
+// Enabled reports whether messages of level l are logged.
+func Enabled(l Level) bool {
+	return l >= CurrentLevel()
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	if Enabled(l) {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
+
+// Debugf logs a debug message.
+func Debugf(format string, args ...interface{}) { logf(Debug, format, args...) }
+
+// Infof logs an informational message.
+func Infof(format string, args ...interface{}) { logf(Info, format, args...) }
+
+// Warnf logs a warning.
+func Warnf(format string, args ...interface{}) { logf(Warn, format, args...) }
+
+// Errorf logs an error.
+func Errorf(format string, args ...interface{}) { logf(Error, format, args...) }
+
+// LevelFlag is a flag.Value setting the level.
+type LevelFlag struct{}
+
+func (LevelFlag) String() string { return CurrentLevel().String() }
+
+// Set parses and sets the level.
+func (LevelFlag) Set(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	SetLevel(l)
+	return nil
+}
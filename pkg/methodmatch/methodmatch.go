@@ -0,0 +1,35 @@
+// Package methodmatch matches full gRPC method names against the method
+// patterns the policies and flags of the shared packages take: a full
+// method name "/package.Service/Method", a "/package.Service/*" wildcard
+// matching every method of one service, or "*" matching every method.
+package methodmatch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Match reports whether fullMethod, "/package.Service/Method", matches
+// pattern.
+func Match(pattern, fullMethod string) bool {
+	if pattern == "*" || pattern == fullMethod {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(fullMethod, strings.TrimSuffix(pattern, "*"))
+}
+
+// Validate returns an error unless pattern is a full method name, a
+// "/package.Service/*" wildcard or "*".
+func Validate(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	parts := strings.Split(pattern, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid method pattern %q, want /package.Service/Method, /package.Service/* or *", pattern)
+	}
+	if strings.Contains(parts[1], "*") || (parts[2] != "*" && strings.Contains(parts[2], "*")) {
+		return fmt.Errorf("invalid method pattern %q: only a whole method may be a wildcard", pattern)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package methodmatch_test
+
+import (
+	"testing"
+
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, method string
+		want            bool
+	}{
+		{"*", "/ecommerce.ProductInfo/getProduct", true},
+		{"/ecommerce.ProductInfo/getProduct", "/ecommerce.ProductInfo/getProduct", true},
+		{"/ecommerce.ProductInfo/getProduct", "/ecommerce.ProductInfo/addProduct", false},
+		{"/ecommerce.ProductInfo/*", "/ecommerce.ProductInfo/getProduct", true},
+		{"/ecommerce.ProductInfo/*", "/ecommerce.ProductInfoV2/getProduct", false},
+		{"/ecommerce.ProductInfo/*", "/ecommerce.OrderManagement/getOrder", false},
+	} {
+		if got := methodmatch.Match(tc.pattern, tc.method); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.method, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, ok := range []string{"*", "/ecommerce.ProductInfo/*", "/ecommerce.ProductInfo/getProduct"} {
+		if err := methodmatch.Validate(ok); err != nil {
+			t.Errorf("Validate(%q): %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "getOrder", "/*", "/ecommerce.ProductInfo", "/ecommerce.ProductInfo/", "/ecommerce.*/getProduct", "/ecommerce.ProductInfo/get*", "/a/b/c"} {
+		if err := methodmatch.Validate(bad); err == nil {
+			t.Errorf("Validate(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryDelay returns the delay requested by a ResourceExhausted error with
+// RetryInfo details.
+func RetryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*epb.RetryInfo); ok && ri.RetryDelay != nil {
+			return ri.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// wait sleeps for d unless ctx ends first or the deadline of ctx would pass
+// before the retry could even start.
+func wait(ctx context.Context, d time.Duration) bool {
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// UnaryClientInterceptor retries unary calls rejected with RetryInfo after
+// the requested delay, at most maxRetries times.
+func UnaryClientInterceptor(maxRetries int) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			delay, ok := RetryDelay(err)
+			if !ok || attempt >= maxRetries {
+				return err
+			}
+			logging.Infof("%s rate limited, retrying in %v", method, delay)
+			if !wait(ctx, delay) {
+				return err
+			}
+		}
+	}
+}
+
+// maxReplayMessages bounds the messages a stream keeps for a replay.
+const maxReplayMessages = 64
+
+// StreamClientInterceptor retries streams rejected with RetryInfo. The
+// server rejects a stream before reading from it, so the rejection surfaces
+// on the first RecvMsg. If the client has finished sending by then and has
+// not received anything, the stream is re-opened after the requested delay
+// and the messages sent so far are replayed.
+func StreamClientInterceptor(maxRetries int) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &retryStream{
+			cs:  cs,
+			ctx: ctx,
+			open: func() (grpc.ClientStream, error) {
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+			method:     method,
+			retriesMax: maxRetries,
+			replay:     true,
+		}, nil
+	}
+}
+
+type retryStream struct {
+	ctx        context.Context
+	open       func() (grpc.ClientStream, error)
+	method     string
+	retriesMax int
+
+	mu      sync.Mutex
+	cs      grpc.ClientStream // replaced by RecvMsg on a retry
+	sent    []interface{}
+	replay  bool // nothing received and few enough messages to replay
+	closed  bool // CloseSend was called
+	broken  bool // the current stream rejected a send
+	retries int
+}
+
+func (s *retryStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replay {
+		if len(s.sent) < maxReplayMessages {
+			s.sent = append(s.sent, m)
+		} else {
+			s.replay = false
+			s.sent = nil
+		}
+	}
+	if s.broken {
+		if !s.replay {
+			return io.EOF
+		}
+		// Keep collecting messages for the replay; the status is reported
+		// by RecvMsg.
+		return nil
+	}
+	err := s.cs.SendMsg(m)
+	if err == io.EOF && s.replay {
+		s.broken = true
+		return nil
+	}
+	return err
+}
+
+func (s *retryStream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.broken {
+		return nil
+	}
+	return s.cs.CloseSend()
+}
+
+// current returns the stream in use.
+func (s *retryStream) current() grpc.ClientStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cs
+}
+
+func (s *retryStream) Header() (metadata.MD, error) { return s.current().Header() }
+
+func (s *retryStream) Trailer() metadata.MD { return s.current().Trailer() }
+
+func (s *retryStream) Context() context.Context { return s.current().Context() }
+
+func (s *retryStream) RecvMsg(m interface{}) error {
+	for {
+		err := s.current().RecvMsg(m)
+		s.mu.Lock()
+		if err == nil {
+			s.replay = false
+			s.sent = nil
+			s.mu.Unlock()
+			return nil
+		}
+		delay, ok := RetryDelay(err)
+		if !ok || !s.replay || !s.closed || s.retries >= s.retriesMax {
+			s.mu.Unlock()
+			return err
+		}
+		s.retries++
+		sent := s.sent
+		s.mu.Unlock()
+
+		logging.Infof("%s rate limited, retrying stream in %v", s.method, delay)
+		if !wait(s.ctx, delay) {
+			return err
+		}
+		cs, oerr := s.open()
+		if oerr != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.cs = cs
+		s.broken = false
+		for _, msg := range sent {
+			if serr := cs.SendMsg(msg); serr != nil {
+				break
+			}
+		}
+		_ = cs.CloseSend()
+		s.mu.Unlock()
+	}
+}
+
+// DialOptions returns the options installing both client interceptors.
+func DialOptions(maxRetries int) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(maxRetries)),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(maxRetries)),
+	}
+}
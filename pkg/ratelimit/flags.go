@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// ServerFlags are the command line flags enabling rate limiting on a server.
+type ServerFlags struct {
+	ConfigFile string
+
+	limiters []*Limiter
+	key      string
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.ConfigFile, "rate_limit_config", "", "JSON file with the per-method token bucket rules; rate limiting is off when empty")
+}
+
+// ServerOptions returns the options installing the configured limiter, or
+// none when rate limiting is disabled. Every call returns a limiter of its
+// own; all of them are kept for Reload.
+func (f *ServerFlags) ServerOptions() ([]grpc.ServerOption, error) {
+	if f.ConfigFile == "" {
+		return nil, nil
+	}
+	c, err := LoadConfig(f.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLimiter(c)
+	if err != nil {
+		return nil, err
+	}
+	f.limiters, f.key = append(f.limiters, l), c.Key
+	return l.ServerOptions(), nil
+}
+
+// Reload reads the configuration file again and replaces the rules of the
+// limiters. An empty file name lifts all limits. Rate limiting cannot be
+// turned on or its key changed without a restart.
+func (f *ServerFlags) Reload() error {
+	if len(f.limiters) == 0 {
+		if f.ConfigFile != "" {
+			return fmt.Errorf("ratelimit: rate limiting was off at startup, restart to turn it on")
+		}
+		return nil
+	}
+	var rules []Rule
+	if f.ConfigFile != "" {
+		c, err := LoadConfig(f.ConfigFile)
+		if err != nil {
+			return err
+		}
+		if c.Key != f.key {
+			return fmt.Errorf("ratelimit: key changed from %q to %q, restart to apply", f.key, c.Key)
+		}
+		rules = c.Rules
+	}
+	for _, l := range f.limiters {
+		l.SetRules(rules)
+	}
+	return nil
+}
+
+// ClientFlags are the command line flags controlling how a client honours
+// RetryInfo from rate limited servers.
+type ClientFlags struct {
+	MaxRetries int
+}
+
+// Register adds the flags to fs.
+func (f *ClientFlags) Register(fs *flag.FlagSet) {
+	fs.IntVar(&f.MaxRetries, "rate_limit_retries", 3, "how often a call rejected with RetryInfo is retried after the requested delay")
+}
+
+// DialOptions returns the options installing the client interceptors.
+func (f *ClientFlags) DialOptions() []grpc.DialOption {
+	if f.MaxRetries <= 0 {
+		return nil
+	}
+	return DialOptions(f.MaxRetries)
+}
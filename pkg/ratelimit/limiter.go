@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/methodmatch"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Rule limits the calls of every caller to the methods it matches. Method is
+// a full method name, a "/package.Service/*" wildcard or "*".
+type Rule struct {
+	Method string  `json:"method"`
+	Rate   float64 `json:"rate"`  // tokens per second
+	Burst  int     `json:"burst"` // bucket size
+}
+
+// Config is the JSON rate limit configuration, e.g.
+//
+//	{
+//	  "key": "identity",
+//	  "rules": [
+//	    {"method": "/ecommerce.OrderManagement/addOrder", "rate": 5, "burst": 10},
+//	    {"method": "*", "rate": 50, "burst": 100}
+//	  ]
+//	}
+//
+// Key selects who owns a bucket: "identity" (the authenticated subject,
+// falling back to the peer address), "peer" (the peer IP address) or
+// "metadata:<name>" (the value of a request metadata key). The first
+// matching rule applies; methods without a rule are not limited.
+type Config struct {
+	Key   string `json:"key"`
+	Rules []Rule `json:"rules"`
+}
+
+// LoadConfig reads a JSON configuration file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: read config: %v", err)
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("ratelimit: parse config %s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("ratelimit: config %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the key kind and the rules.
+func (c *Config) Validate() error {
+	if _, err := keyFunc(c.Key); err != nil {
+		return err
+	}
+	for _, r := range c.Rules {
+		if r.Rate < 0 || r.Burst < 1 {
+			return fmt.Errorf("invalid rule %+v: need a rate >= 0 and a burst >= 1", r)
+		}
+		if err := methodmatch.Validate(r.Method); err != nil {
+			return fmt.Errorf("invalid rule %+v: %v", r, err)
+		}
+	}
+	return nil
+}
+
+// KeyFunc derives the bucket owner from the context of an incoming RPC.
+type KeyFunc func(ctx context.Context) string
+
+// PeerKey identifies callers by their IP address.
+func PeerKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "peer:unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return "peer:" + host
+}
+
+// IdentityKey identifies callers by the subject of their verified token and
+// falls back to PeerKey for unauthenticated calls.
+func IdentityKey(ctx context.Context) string {
+	if c, ok := auth.ClaimsFromContext(ctx); ok && c.Subject != "" {
+		return "identity:" + c.Subject
+	}
+	return PeerKey(ctx)
+}
+
+// MetadataKey identifies callers by the value of a request metadata key.
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(name); len(v) > 0 {
+			return name + ":" + v[0]
+		}
+		return name + ":"
+	}
+}
+
+func keyFunc(kind string) (KeyFunc, error) {
+	switch {
+	case kind == "" || kind == "identity":
+		return IdentityKey, nil
+	case kind == "peer":
+		return PeerKey, nil
+	case strings.HasPrefix(kind, "metadata:") && len(kind) > len("metadata:"):
+		return MetadataKey(strings.ToLower(strings.TrimPrefix(kind, "metadata:"))), nil
+	}
+	return nil, fmt.Errorf("unknown key %q, want identity, peer or metadata:<name>", kind)
+}
+
+type bucketKey struct {
+	rule   int
+	caller string
+}
+
+// Limiter holds the buckets of every caller.
+type Limiter struct {
+	key KeyFunc
+	now func() time.Time
+
+	mu        sync.Mutex
+	rules     []Rule
+	buckets   map[bucketKey]*Bucket
+	lastSweep time.Time
+}
+
+// NewLimiter returns a limiter for c.
+func NewLimiter(c *Config) (*Limiter, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	key, _ := keyFunc(c.Key)
+	return &Limiter{key: key, rules: c.Rules, buckets: make(map[bucketKey]*Bucket), now: time.Now}, nil
+}
+
+// SetRules replaces the rules, e.g. after a configuration reload. Existing
+// buckets are dropped, so every caller starts with a full bucket.
+func (l *Limiter) SetRules(rules []Rule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = rules
+	l.buckets = make(map[bucketKey]*Bucket)
+}
+
+const sweepInterval = time.Minute
+
+// Allow takes a token for the caller of fullMethod. It returns nil or a
+// ResourceExhausted status error carrying RetryInfo and QuotaFailure details.
+func (l *Limiter) Allow(ctx context.Context, fullMethod string) error {
+	now := l.now()
+	caller := l.key(ctx)
+
+	l.mu.Lock()
+	idx := -1
+	for i := range l.rules {
+		if methodmatch.Match(l.rules[i].Method, fullMethod) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.lastSweep = now
+		for k, b := range l.buckets {
+			if b.idle(now) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	rule := l.rules[idx]
+	k := bucketKey{rule: idx, caller: caller}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = NewBucket(rule.Rate, rule.Burst, now)
+		l.buckets[k] = b
+	}
+	ok, wait := b.Take(now)
+	l.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+	return rejection(caller, fullMethod, rule, wait)
+}
+
+func rejection(caller, fullMethod string, rule Rule, wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded for %s, retry in %v", fullMethod, wait.Round(time.Millisecond)))
+	ds, err := st.WithDetails(
+		&epb.RetryInfo{RetryDelay: durationpb.New(wait)},
+		&epb.QuotaFailure{Violations: []*epb.QuotaFailure_Violation{{
+			Subject:     caller,
+			Description: fmt.Sprintf("%s allows %g requests per second with a burst of %d", rule.Method, rule.Rate, rule.Burst),
+		}}},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return ds.Err()
+}
+
+// UnaryServerInterceptor rejects unary calls over the limit.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := l.Allow(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams over the limit before the handler
+// runs. A stream costs one token however many messages it carries.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := l.Allow(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// ServerOptions returns the options installing both interceptors.
+func (l *Limiter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(l.StreamServerInterceptor()),
+	}
+}
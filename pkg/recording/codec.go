@@ -0,0 +1,55 @@
+package recording
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// Frame is a message in its wire format.
+type Frame []byte
+
+// codec passes frames through untouched and handles any other message as
+// protobuf, like the default codec, so that a server forwarding unknown
+// methods can serve its own services as well. It keeps the name of the
+// default codec: the peers see plain protobuf.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *Frame:
+		return *m, nil
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("recording: cannot marshal %T", v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *Frame:
+		*m = append((*m)[:0], data...)
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return fmt.Errorf("recording: cannot unmarshal into %T", v)
+}
+
+func (codec) Name() string   { return "proto" }
+func (codec) String() string { return "proto" }
+
+// callOption makes a client call send and receive frames.
+func callOption() grpc.CallOption {
+	return grpc.ForceCodec(codec{})
+}
+
+// serverOptions make a server hand the calls of the methods it does not
+// know to handler as frames.
+func serverOptions(handler grpc.StreamHandler) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.CustomCodec(codec{}),
+		grpc.UnknownServiceHandler(handler),
+	}
+}
@@ -0,0 +1,154 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Proxy forwards every call it receives to a backend and records it. The
+// metadata, messages, header, trailer and status pass through unchanged.
+type Proxy struct {
+	backend grpc.ClientConnInterface
+	rec     *Writer
+	redact  map[string]bool
+}
+
+// NewProxy returns a proxy to backend recording to rec. The values of the
+// authorization metadata key and of the keys in redact are recorded as
+// Redacted; the backend and the client still get them.
+func NewProxy(backend grpc.ClientConnInterface, rec *Writer, redact ...string) *Proxy {
+	p := &Proxy{backend: backend, rec: rec, redact: map[string]bool{"authorization": true}}
+	for _, k := range redact {
+		p.redact[strings.ToLower(k)] = true
+	}
+	return p
+}
+
+// redacted returns a copy of md with the values of the redacted keys
+// replaced.
+func (p *Proxy) redacted(md metadata.MD) metadata.MD {
+	md = md.Copy()
+	for k := range md {
+		if p.redact[k] {
+			md[k] = []string{Redacted}
+		}
+	}
+	return md
+}
+
+// ServerOptions make a server forward the calls of every method it does
+// not serve itself to the backend.
+func (p *Proxy) ServerOptions() []grpc.ServerOption {
+	return serverOptions(p.handle)
+}
+
+// recorder collects the messages of a call from both directions.
+type recorder struct {
+	mu   sync.Mutex
+	call *Call
+}
+
+func (r *recorder) add(from string, f Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.call.Messages = append(r.call.Messages, Message{
+		From:   from,
+		Offset: time.Since(r.call.Start),
+		Data:   append([]byte(nil), f...),
+	})
+}
+
+func (p *Proxy) handle(_ interface{}, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "recording: no method in the stream")
+	}
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	md, _ := metadata.FromIncomingContext(ctx)
+	rec := &recorder{call: &Call{Method: method, Start: time.Now(), Metadata: p.redacted(md)}}
+	err := p.forward(ctx, cancel, method, md, ss, rec)
+	p.record(rec, err)
+	return status.Convert(err).Err()
+}
+
+// forward proxies the call and returns the status of the backend.
+func (p *Proxy) forward(ctx context.Context, cancel func(), method string, md metadata.MD, ss grpc.ServerStream, rec *recorder) error {
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	cs, err := p.backend.NewStream(metadata.NewOutgoingContext(ctx, md), desc, method, callOption())
+	if err != nil {
+		return err
+	}
+
+	// Client to backend. The call ends when the backend is done, so errors
+	// sending to it are reported by RecvMsg below.
+	go func() {
+		for {
+			var f Frame
+			if err := ss.RecvMsg(&f); err != nil {
+				if err == io.EOF {
+					cs.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			}
+			rec.add(FromClient, f)
+			if err := cs.SendMsg(&f); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Backend to client.
+	for first := true; ; first = false {
+		var f Frame
+		err = cs.RecvMsg(&f)
+		if first {
+			if h, herr := cs.Header(); herr == nil && h.Len() > 0 {
+				rec.mu.Lock()
+				rec.call.Header = p.redacted(h)
+				rec.mu.Unlock()
+				if err := ss.SendHeader(h); err != nil {
+					cancel()
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+		rec.add(FromServer, f)
+		if err := ss.SendMsg(&f); err != nil {
+			// The client is gone; RecvMsg fails with the cancellation.
+			cancel()
+		}
+	}
+	trailer := cs.Trailer()
+	rec.mu.Lock()
+	rec.call.Trailer = p.redacted(trailer)
+	rec.mu.Unlock()
+	ss.SetTrailer(trailer)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (p *Proxy) record(rec *recorder, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.call.setStatus(err)
+	rec.call.Duration = time.Since(rec.call.Start)
+	if err := p.rec.Write(rec.call); err != nil {
+		logging.Errorf("recording: failed to record %s: %v", rec.call.Method, err)
+	}
+}
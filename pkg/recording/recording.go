@@ -0,0 +1,147 @@
+// Package recording captures the gRPC calls between clients and a server
+// and plays them back, to reproduce problems seen in production locally.
+//
+// A Proxy sits between the clients and the server and forwards any method
+// without knowing its messages, writing every call to a recording: the
+// method, the metadata with credentials redacted, each message in order with
+// the time it passed and the status and trailers the call ended with.
+// Replay makes the recorded calls again against a server and compares the
+// answers; a Server answers calls from a recording in place of the real
+// server.
+//
+// Recordings are files of JSON lines, one call per line, with the messages
+// in their wire format.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The senders of a message.
+const (
+	FromClient = "client"
+	FromServer = "server"
+)
+
+// Redacted is recorded in place of the values of the metadata keys a Proxy
+// redacts.
+const Redacted = "REDACTED"
+
+// Call is a recorded call.
+type Call struct {
+	// Method is the path of the method, /pkg.Service/Method.
+	Method   string      `json:"method"`
+	Start    time.Time   `json:"start"`
+	Metadata metadata.MD `json:"metadata,omitempty"`
+	Header   metadata.MD `json:"header,omitempty"`
+	// Messages are the messages of both sides in the order they passed.
+	Messages []Message   `json:"messages"`
+	Trailer  metadata.MD `json:"trailer,omitempty"`
+	// Code is the name of the status code, for reading; Status holds the
+	// whole status, with its details, as a google.rpc.Status unless the
+	// call succeeded.
+	Code     string        `json:"code"`
+	Status   []byte        `json:"status,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Message is a message of a call in its wire format.
+type Message struct {
+	From string `json:"from"`
+	// Offset is the time from the start of the call.
+	Offset time.Duration `json:"offset_ns"`
+	Data   []byte        `json:"data"`
+}
+
+// Err returns the status error the call ended with, nil if it succeeded.
+func (c *Call) Err() error {
+	if len(c.Status) == 0 {
+		return nil
+	}
+	p := new(spb.Status)
+	if err := proto.Unmarshal(c.Status, p); err != nil {
+		return fmt.Errorf("recording: status of %s: %v", c.Method, err)
+	}
+	return status.FromProto(p).Err()
+}
+
+// setStatus records the status of err.
+func (c *Call) setStatus(err error) {
+	st := status.Convert(err)
+	c.Code = st.Code().String()
+	c.Status = nil
+	if err != nil {
+		c.Status, _ = proto.Marshal(st.Proto())
+	}
+}
+
+// messages returns the data of the messages from sender.
+func (c *Call) messages(from string) [][]byte {
+	var data [][]byte
+	for _, m := range c.Messages {
+		if m.From == from {
+			data = append(data, m.Data)
+		}
+	}
+	return data
+}
+
+// Writer appends calls to a recording.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter returns a writer of a recording to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write appends c.
+func (w *Writer) Write(c *Call) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(c)
+}
+
+// Read reads the calls of a recording.
+func Read(r io.Reader) ([]*Call, error) {
+	var calls []*Call
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		c := new(Call)
+		if err := json.Unmarshal(sc.Bytes(), c); err != nil {
+			return nil, fmt.Errorf("recording: line %d: %v", n, err)
+		}
+		calls = append(calls, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("recording: %v", err)
+	}
+	return calls, nil
+}
+
+// ReadFile reads the calls of the recording at path.
+func ReadFile(path string) ([]*Call, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("recording: %v", err)
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,248 @@
+package recording_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/eadydb/grpc-samples/pkg/recording"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// rules script the backend the tests record.
+var rules = []fakeserver.Rule{
+	{
+		Method:    "getOrder",
+		Match:     fakeserver.Message(&wrappers.StringValue{Value: "202"}),
+		Header:    map[string]string{"location": "guangzhou"},
+		Trailer:   map[string]string{"served-by": "backend"},
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.Order{Id: "202", Destination: "Paris"})}},
+	},
+	{
+		Method: "getOrder",
+		Error: &fakeserver.Error{
+			Code:    codes.NotFound,
+			Message: "no such order",
+			Details: []json.RawMessage{json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.ResourceInfo", "resourceName": "999"}`)},
+		},
+	},
+	{
+		Method: "searchOrders",
+		Responses: []fakeserver.Response{
+			{Message: fakeserver.Message(&opb.Order{Id: "202"})},
+			{Message: fakeserver.Message(&opb.Order{Id: "204"})},
+		},
+	},
+	{
+		Method:    "processOrders",
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.CombinedShipment{Id: "cmb"})}},
+	},
+}
+
+func startBackend(t *testing.T, rules ...fakeserver.Rule) *grpctest.Server {
+	fake := fakeserver.New()
+	if err := fake.Add(rules...); err != nil {
+		t.Fatal(err)
+	}
+	return grpctest.NewServer(t, fake.Register)
+}
+
+// noServices registers nothing: every call goes to the unknown service
+// handler.
+func noServices(*grpc.Server) {}
+
+// exercise makes the calls the backend answers and returns what the client
+// saw of them.
+func exercise(t *testing.T, conn *grpc.ClientConn) []string {
+	client := opb.NewOrderManagementClient(conn)
+	var seen []string
+	note := func(format string, args ...interface{}) {
+		b, _ := json.Marshal(args)
+		seen = append(seen, format+string(b))
+	}
+
+	ctx := metadata.AppendToOutgoingContext(grpctest.Context(t), "kn", "vn")
+	var header, trailer metadata.MD
+	order, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "202"}, grpc.Header(&header), grpc.Trailer(&trailer))
+	note("getOrder 202", order.GetDestination(), err, header.Get("location"), trailer.Get("served-by"))
+
+	_, err = client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "999"})
+	st := status.Convert(err)
+	var resource string
+	for _, d := range st.Details() {
+		if info, ok := d.(*epb.ResourceInfo); ok {
+			resource = info.ResourceName
+		}
+	}
+	note("getOrder 999", st.Code(), st.Message(), resource)
+
+	search, err := client.SearchOrders(grpctest.Context(t), &wrappers.StringValue{Value: "Kindle"})
+	if err != nil {
+		t.Fatalf("SearchOrders: %v", err)
+	}
+	for {
+		o, err := search.Recv()
+		if err != nil {
+			note("searchOrders end", status.Code(err))
+			break
+		}
+		note("searchOrders", o.Id)
+	}
+
+	process, err := client.ProcessOrders(grpctest.Context(t))
+	if err != nil {
+		t.Fatalf("ProcessOrders: %v", err)
+	}
+	for _, id := range []string{"201", "202"} {
+		if err := process.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		s, err := process.Recv()
+		note("processOrders", id, s.GetId(), err)
+	}
+	process.CloseSend()
+	_, err = process.Recv()
+	note("processOrders end", err == io.EOF)
+	return seen
+}
+
+// record exercises the backend through a proxy and returns the recording
+// and what the client saw.
+func record(t *testing.T, backend *grpctest.Server) ([]*recording.Call, []string) {
+	var buf bytes.Buffer
+	proxy := recording.NewProxy(backend.Dial(t), recording.NewWriter(&buf))
+	seen := exercise(t, grpctest.Start(t, noServices, proxy.ServerOptions()...))
+	calls, err := recording.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return calls, seen
+}
+
+func TestProxyIsTransparent(t *testing.T) {
+	backend := startBackend(t, rules...)
+	direct := exercise(t, backend.Dial(t))
+	_, proxied := record(t, backend)
+	if !reflect.DeepEqual(proxied, direct) {
+		t.Errorf("through the proxy the client saw\n%q\nwant\n%q", proxied, direct)
+	}
+}
+
+func TestProxyRecordsCalls(t *testing.T) {
+	calls, _ := record(t, startBackend(t, rules...))
+	if len(calls) != 4 {
+		t.Fatalf("recorded %d calls, want 4", len(calls))
+	}
+
+	get := calls[0]
+	if get.Method != "/ecommerce.OrderManagement/getOrder" || get.Code != "OK" || get.Err() != nil {
+		t.Errorf("recorded %s ending with %s", get.Method, get.Code)
+	}
+	if got := get.Metadata.Get("kn"); !reflect.DeepEqual(got, []string{"vn"}) {
+		t.Errorf("recorded kn %q, want vn", got)
+	}
+	if got := get.Header.Get("location"); !reflect.DeepEqual(got, []string{"guangzhou"}) {
+		t.Errorf("recorded header location %q", got)
+	}
+	if got := get.Trailer.Get("served-by"); !reflect.DeepEqual(got, []string{"backend"}) {
+		t.Errorf("recorded trailer served-by %q", got)
+	}
+	if len(get.Messages) != 2 || get.Messages[0].From != recording.FromClient || get.Messages[1].From != recording.FromServer {
+		t.Fatalf("recorded messages %+v, want a request and a response", get.Messages)
+	}
+	req := new(wrappers.StringValue)
+	if err := proto.Unmarshal(get.Messages[0].Data, req); err != nil || req.Value != "202" {
+		t.Errorf("recorded request %v, %v", req, err)
+	}
+	if get.Messages[1].Offset < get.Messages[0].Offset {
+		t.Errorf("the response is recorded before the request")
+	}
+
+	if st := status.Convert(calls[1].Err()); st.Code() != codes.NotFound || len(st.Details()) != 1 {
+		t.Errorf("recorded status %v with details %v", st.Code(), st.Details())
+	}
+
+	var from []string
+	for _, m := range calls[3].Messages {
+		from = append(from, m.From)
+	}
+	if want := []string{"client", "server", "client", "server"}; !reflect.DeepEqual(from, want) {
+		t.Errorf("recorded processOrders messages from %q, want %q", from, want)
+	}
+}
+
+func TestProxyRedactsMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	proxy := recording.NewProxy(startBackend(t, rules...).Dial(t), recording.NewWriter(&buf), "X-Api-Key")
+	client := opb.NewOrderManagementClient(grpctest.Start(t, noServices, proxy.ServerOptions()...))
+	ctx := metadata.AppendToOutgoingContext(grpctest.Context(t), "authorization", "Bearer secret", "x-api-key", "secret", "kn", "vn")
+	if _, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "202"}); err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	calls, err := recording.Read(&buf)
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("recorded %d calls, %v", len(calls), err)
+	}
+	md := calls[0].Metadata
+	for _, k := range []string{"authorization", "x-api-key"} {
+		if got := md.Get(k); !reflect.DeepEqual(got, []string{recording.Redacted}) {
+			t.Errorf("recorded %s %q, want it redacted", k, got)
+		}
+	}
+	if got := md.Get("kn"); !reflect.DeepEqual(got, []string{"vn"}) {
+		t.Errorf("recorded kn %q, want vn", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	backend := startBackend(t, rules...)
+	calls, _ := record(t, backend)
+
+	conn := backend.Dial(t)
+	for _, c := range calls {
+		if res := recording.Replay(grpctest.Context(t), conn, c, false); len(res.Diffs) > 0 {
+			t.Errorf("replaying %s: %q", c.Method, res.Diffs)
+		}
+	}
+
+	changed := startBackend(t, fakeserver.Rule{
+		Method:    "getOrder",
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.Order{Id: "202", Destination: "Rome"})}},
+	})
+	res := recording.Replay(grpctest.Context(t), changed.Dial(t), calls[0], false)
+	if want := []string{"message 1: differs (recorded 12 bytes, got 11)"}; !reflect.DeepEqual(res.Diffs, want) {
+		t.Errorf("replaying against a changed server: %q, want %q", res.Diffs, want)
+	}
+	res = recording.Replay(grpctest.Context(t), changed.Dial(t), calls[2], false)
+	if len(res.Diffs) != 2 || status.Code(res.Got.Err()) != codes.Unimplemented {
+		t.Errorf("replaying against a server without the method: %q", res.Diffs)
+	}
+}
+
+func TestServerAnswersFromRecording(t *testing.T) {
+	backend := startBackend(t, rules...)
+	calls, direct := record(t, backend)
+
+	replayer := recording.NewServer(calls, false)
+	replayed := exercise(t, grpctest.Start(t, noServices, replayer.ServerOptions()...))
+	if !reflect.DeepEqual(replayed, direct) {
+		t.Errorf("from the recording the client saw\n%q\nwant\n%q", replayed, direct)
+	}
+
+	client := opb.NewOrderManagementClient(grpctest.Start(t, noServices, replayer.ServerOptions()...))
+	_, err := client.GetOrder(grpctest.Context(t), &wrappers.StringValue{Value: "203"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("GetOrder of an unrecorded order returned %v, want Unimplemented", err)
+	}
+}
@@ -0,0 +1,267 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Result is the outcome of replaying a call.
+type Result struct {
+	// Recorded is the call replayed, Got the call as made again.
+	Recorded *Call
+	Got      *Call
+	// Diffs describe how the answers differ, if they do. Header and
+	// trailer metadata are not compared.
+	Diffs []string
+}
+
+// unredacted returns md without the values recorded as Redacted.
+func unredacted(md metadata.MD) metadata.MD {
+	out := metadata.MD{}
+	for k, vs := range md {
+		for _, v := range vs {
+			if v != Redacted {
+				out.Append(k, v)
+			}
+		}
+	}
+	return out
+}
+
+// Replay makes the recorded call again on conn, with the recorded metadata
+// and client messages, and compares the answers. Redacted metadata is left
+// out; the outgoing metadata of ctx and the call credentials of conn, if
+// any, are sent instead. A client message is sent
+// once the server messages recorded before it have arrived, which keeps the
+// order of streams in both directions; with timing it also waits for its
+// recorded offset.
+func Replay(ctx context.Context, conn grpc.ClientConnInterface, rc *Call, timing bool) *Result {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	got := &Call{Method: rc.Method, Start: time.Now(), Metadata: rc.Metadata}
+	rec := &recorder{call: got}
+	err := replay(ctx, conn, rc, timing, rec)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	got.setStatus(err)
+	got.Duration = time.Since(got.Start)
+	return &Result{Recorded: rc, Got: got, Diffs: compare(rc, got)}
+}
+
+func replay(ctx context.Context, conn grpc.ClientConnInterface, rc *Call, timing bool, rec *recorder) error {
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	var header, trailer metadata.MD
+	md, _ := metadata.FromOutgoingContext(ctx)
+	cs, err := conn.NewStream(metadata.NewOutgoingContext(ctx, metadata.Join(md, unredacted(rc.Metadata))), desc, rc.Method,
+		callOption(), grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		return err
+	}
+
+	// received counts the server messages so far; the sender waits on it.
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	received, done := 0, false
+	go func() {
+		before := 0
+		for _, m := range rc.Messages {
+			if m.From == FromServer {
+				before++
+				continue
+			}
+			mu.Lock()
+			for received < before && !done {
+				cond.Wait()
+			}
+			stop := done
+			mu.Unlock()
+			if stop {
+				return
+			}
+			if timing {
+				if !sleepUntil(ctx, rec.call.Start.Add(m.Offset)) {
+					return
+				}
+			}
+			f := Frame(m.Data)
+			rec.add(FromClient, f)
+			if err := cs.SendMsg(&f); err != nil {
+				return
+			}
+		}
+		cs.CloseSend()
+	}()
+
+	for {
+		var f Frame
+		if err = cs.RecvMsg(&f); err != nil {
+			break
+		}
+		rec.add(FromServer, f)
+		mu.Lock()
+		received++
+		cond.Broadcast()
+		mu.Unlock()
+	}
+	mu.Lock()
+	done = true
+	cond.Broadcast()
+	mu.Unlock()
+
+	rec.mu.Lock()
+	rec.call.Header, rec.call.Trailer = header, trailer
+	rec.mu.Unlock()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// sleepUntil waits for t and reports whether ctx is still live.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// compare describes the differences between the answers of two calls.
+func compare(want, got *Call) []string {
+	var diffs []string
+	ws, gs := status.Convert(want.Err()), status.Convert(got.Err())
+	if ws.Code() != gs.Code() || ws.Message() != gs.Message() {
+		diffs = append(diffs, fmt.Sprintf("status: recorded %s %q, got %s %q", ws.Code(), ws.Message(), gs.Code(), gs.Message()))
+	} else if !bytes.Equal(want.Status, got.Status) {
+		diffs = append(diffs, "status: the details differ")
+	}
+	wm, gm := want.messages(FromServer), got.messages(FromServer)
+	if len(wm) != len(gm) {
+		diffs = append(diffs, fmt.Sprintf("messages: recorded %d, got %d", len(wm), len(gm)))
+	}
+	for i := 0; i < len(wm) && i < len(gm); i++ {
+		if !bytes.Equal(wm[i], gm[i]) {
+			diffs = append(diffs, fmt.Sprintf("message %d: differs (recorded %d bytes, got %d)", i+1, len(wm[i]), len(gm[i])))
+		}
+	}
+	return diffs
+}
+
+// Server answers calls from a recording in place of the server recorded.
+// The first client message of a call picks the recorded call of the same
+// method and request, preferring those not replayed yet. The recorded
+// server messages follow the client messages in their recorded order,
+// with the recorded delays when timing is set.
+type Server struct {
+	timing bool
+
+	mu    sync.Mutex
+	calls []*Call
+	used  map[*Call]bool
+}
+
+// NewServer returns a server answering from calls.
+func NewServer(calls []*Call, timing bool) *Server {
+	return &Server{timing: timing, calls: calls, used: make(map[*Call]bool)}
+}
+
+// ServerOptions make a server answer the calls of every method it does not
+// serve itself from the recording.
+func (s *Server) ServerOptions() []grpc.ServerOption {
+	return serverOptions(s.handle)
+}
+
+func (s *Server) handle(_ interface{}, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "recording: no method in the stream")
+	}
+	var first Frame
+	err := ss.RecvMsg(&first)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	clientDone := err == io.EOF
+	rc := s.find(method, first, !clientDone)
+	if rc == nil {
+		return status.Errorf(codes.Unimplemented, "recording: no recorded call of %s has this request", method)
+	}
+
+	start := time.Now()
+	if rc.Header.Len() > 0 {
+		ss.SetHeader(rc.Header)
+	}
+	skip := !clientDone
+	for _, m := range rc.Messages {
+		if m.From == FromClient {
+			if skip {
+				skip = false
+				continue
+			}
+			if !clientDone {
+				var f Frame
+				if err := ss.RecvMsg(&f); err == io.EOF {
+					clientDone = true
+				} else if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if s.timing && !sleepUntil(ss.Context(), start.Add(m.Offset)) {
+			return status.FromContextError(ss.Context().Err()).Err()
+		}
+		f := Frame(m.Data)
+		if err := ss.SendMsg(&f); err != nil {
+			return err
+		}
+	}
+	if rc.Trailer.Len() > 0 {
+		ss.SetTrailer(rc.Trailer)
+	}
+	if s.timing {
+		sleepUntil(ss.Context(), start.Add(rc.Duration))
+	}
+	return rc.Err()
+}
+
+// find returns the recorded call of method whose first client message is
+// first, or which had none.
+func (s *Server) find(method string, first Frame, hasFirst bool) *Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var match *Call
+	for _, c := range s.calls {
+		if c.Method != method {
+			continue
+		}
+		msgs := c.messages(FromClient)
+		if hasFirst != (len(msgs) > 0) || (hasFirst && !bytes.Equal(msgs[0], first)) {
+			continue
+		}
+		if !s.used[c] {
+			s.used[c] = true
+			return c
+		}
+		if match == nil {
+			match = c
+		}
+	}
+	return match
+}
@@ -0,0 +1,193 @@
+// Package retry retries and hedges client calls following the retry and
+// hedging policies of a gRPC service config, e.g.
+//
+//	{
+//	  "methodConfig": [{
+//	    "name": [{"service": "ecommerce.OrderManagement", "method": "addOrder"}],
+//	    "timeout": "10s",
+//	    "retryPolicy": {
+//	      "maxAttempts": 4,
+//	      "initialBackoff": "0.1s",
+//	      "maxBackoff": "1s",
+//	      "backoffMultiplier": 2,
+//	      "retryableStatusCodes": ["UNAVAILABLE"]
+//	    }
+//	  }, {
+//	    "name": [{"service": "ecommerce.OrderManagement", "method": "getOrder"},
+//	             {"service": "ecommerce.ProductInfo", "method": "getProduct"}],
+//	    "hedgingPolicy": {
+//	      "maxAttempts": 3,
+//	      "hedgingDelay": "0.2s",
+//	      "nonFatalStatusCodes": ["UNAVAILABLE", "DEADLINE_EXCEEDED"]
+//	    }
+//	  }],
+//	  "retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+//	}
+//
+// gRPC-Go only retries when GRPC_GO_RETRY=on is set before the process
+// starts and does not hedge at all, so the policies are applied by client
+// interceptors instead. Attempts share the deadline of the call: no attempt
+// starts after it and no backoff sleeps past it. Only unary calls are
+// retried; streams are passed through.
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Duration is a service config duration such as "0.5s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string in seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(s, "s") {
+		return fmt.Errorf("duration %q must end in s", s)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Name selects the methods a MethodConfig applies to. An empty method
+// selects the whole service, an empty name every method.
+type Name struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+// RetryPolicy retries a call that failed with a retryable status code
+// after an exponential backoff with full jitter.
+type RetryPolicy struct {
+	MaxAttempts          int          `json:"maxAttempts"`
+	InitialBackoff       Duration     `json:"initialBackoff"`
+	MaxBackoff           Duration     `json:"maxBackoff"`
+	BackoffMultiplier    float64      `json:"backoffMultiplier"`
+	RetryableStatusCodes []codes.Code `json:"retryableStatusCodes"`
+}
+
+// HedgingPolicy sends up to MaxAttempts copies of a call, HedgingDelay
+// apart, and keeps the first successful response. A copy failing with a
+// non-fatal status code starts the next one right away; any other failure
+// ends the call.
+type HedgingPolicy struct {
+	MaxAttempts         int          `json:"maxAttempts"`
+	HedgingDelay        Duration     `json:"hedgingDelay"`
+	NonFatalStatusCodes []codes.Code `json:"nonFatalStatusCodes"`
+}
+
+// MethodConfig is the configuration of the methods it names.
+type MethodConfig struct {
+	Name          []Name         `json:"name"`
+	Timeout       *Duration      `json:"timeout"`
+	RetryPolicy   *RetryPolicy   `json:"retryPolicy"`
+	HedgingPolicy *HedgingPolicy `json:"hedgingPolicy"`
+}
+
+// Throttling stops retries and hedges while most calls fail. Every failure
+// takes a token, every success returns TokenRatio tokens, and no additional
+// attempt is made while at most half of MaxTokens are left.
+type Throttling struct {
+	MaxTokens  float64 `json:"maxTokens"`
+	TokenRatio float64 `json:"tokenRatio"`
+}
+
+// ServiceConfig is the part of a gRPC service config this package applies.
+type ServiceConfig struct {
+	MethodConfig    []MethodConfig `json:"methodConfig"`
+	RetryThrottling *Throttling    `json:"retryThrottling"`
+}
+
+// maxAttempts caps every policy as gRPC does.
+const maxAttempts = 5
+
+// ParseServiceConfig parses and validates a JSON service config.
+func ParseServiceConfig(b []byte) (*ServiceConfig, error) {
+	var sc ServiceConfig
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("retry: parse service config: %v", err)
+	}
+	for i := range sc.MethodConfig {
+		mc := &sc.MethodConfig[i]
+		if mc.RetryPolicy != nil && mc.HedgingPolicy != nil {
+			return nil, fmt.Errorf("retry: method config %d has both a retry and a hedging policy", i)
+		}
+		if p := mc.RetryPolicy; p != nil {
+			if p.MaxAttempts < 2 || p.InitialBackoff <= 0 || p.MaxBackoff <= 0 || p.BackoffMultiplier <= 0 || len(p.RetryableStatusCodes) == 0 {
+				return nil, fmt.Errorf("retry: method config %d: invalid retry policy", i)
+			}
+			if p.MaxAttempts > maxAttempts {
+				p.MaxAttempts = maxAttempts
+			}
+		}
+		if p := mc.HedgingPolicy; p != nil {
+			if p.MaxAttempts < 2 || p.HedgingDelay < 0 {
+				return nil, fmt.Errorf("retry: method config %d: invalid hedging policy", i)
+			}
+			if p.MaxAttempts > maxAttempts {
+				p.MaxAttempts = maxAttempts
+			}
+		}
+	}
+	if t := sc.RetryThrottling; t != nil && (t.MaxTokens <= 0 || t.TokenRatio <= 0) {
+		return nil, fmt.Errorf("retry: invalid retry throttling")
+	}
+	return &sc, nil
+}
+
+// LoadServiceConfig reads a JSON service config file.
+func LoadServiceConfig(path string) (*ServiceConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("retry: read service config: %v", err)
+	}
+	return ParseServiceConfig(b)
+}
+
+// lookup returns the config of a full method name, preferring an exact
+// method match over a service match over the default.
+func (sc *ServiceConfig) lookup(fullMethod string) *MethodConfig {
+	svc, method := fullMethod, ""
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		svc, method = strings.TrimPrefix(fullMethod[:i], "/"), fullMethod[i+1:]
+	}
+	var bySvc, byDefault *MethodConfig
+	for i := range sc.MethodConfig {
+		mc := &sc.MethodConfig[i]
+		for _, n := range mc.Name {
+			switch {
+			case n.Service == svc && n.Method == method:
+				return mc
+			case n.Service == svc && n.Method == "" && bySvc == nil:
+				bySvc = mc
+			case n.Service == "" && n.Method == "" && byDefault == nil:
+				byDefault = mc
+			}
+		}
+	}
+	if bySvc != nil {
+		return bySvc
+	}
+	return byDefault
+}
+
+func hasCode(cs []codes.Code, c codes.Code) bool {
+	for _, x := range cs {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package retry
+
+import (
+	"flag"
+
+	"google.golang.org/grpc"
+)
+
+// ClientFlags are the command line flags enabling retries and hedging on a
+// client.
+type ClientFlags struct {
+	ServiceConfigFile string
+}
+
+// Register adds the flags to fs.
+func (f *ClientFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.ServiceConfigFile, "service_config", "", "JSON service config with the per-method retry and hedging policies")
+}
+
+// DialOptions returns the options applying the configured policies, or none
+// when no service config is given.
+func (f *ClientFlags) DialOptions() ([]grpc.DialOption, error) {
+	if f.ServiceConfigFile == "" {
+		return nil, nil
+	}
+	sc, err := LoadServiceConfig(f.ServiceConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewRetrier(sc).DialOptions(), nil
+}
@@ -0,0 +1,311 @@
+package retry
+
+import (
+	"context"
+	"expvar"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// previousAttemptsKey tells the server how many attempts came before, as
+// gRPC's own retry support does.
+const previousAttemptsKey = "grpc-previous-rpc-attempts"
+
+// metrics counts per method the attempts made, the retries and hedges among
+// them, the calls given up with a retryable error and the attempts skipped
+// because of throttling.
+var metrics = expvar.NewMap("retry")
+
+type methodMetrics struct {
+	attempts, retries, hedges, exhausted, throttled expvar.Int
+}
+
+var (
+	methodMetricsMu sync.Mutex
+	methodMetricsBy = make(map[string]*methodMetrics)
+)
+
+func metricsFor(method string) *methodMetrics {
+	methodMetricsMu.Lock()
+	defer methodMetricsMu.Unlock()
+	m, ok := methodMetricsBy[method]
+	if !ok {
+		m = &methodMetrics{}
+		methodMetricsBy[method] = m
+		mm := new(expvar.Map).Init()
+		mm.Set("attempts", &m.attempts)
+		mm.Set("retries", &m.retries)
+		mm.Set("hedges", &m.hedges)
+		mm.Set("exhausted", &m.exhausted)
+		mm.Set("throttled", &m.throttled)
+		metrics.Set(method, mm)
+	}
+	return m
+}
+
+type throttle struct {
+	cfg *Throttling
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func (t *throttle) success() {
+	if t.cfg == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens += t.cfg.TokenRatio; t.tokens > t.cfg.MaxTokens {
+		t.tokens = t.cfg.MaxTokens
+	}
+}
+
+func (t *throttle) failure() {
+	if t.cfg == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens--; t.tokens < 0 {
+		t.tokens = 0
+	}
+}
+
+func (t *throttle) allow() bool {
+	if t.cfg == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens > t.cfg.MaxTokens/2
+}
+
+// Retrier applies the policies of a service config.
+type Retrier struct {
+	sc       *ServiceConfig
+	throttle throttle
+}
+
+// NewRetrier returns a retrier for sc.
+func NewRetrier(sc *ServiceConfig) *Retrier {
+	r := &Retrier{sc: sc, throttle: throttle{cfg: sc.RetryThrottling}}
+	if sc.RetryThrottling != nil {
+		r.throttle.tokens = sc.RetryThrottling.MaxTokens
+	}
+	return r
+}
+
+// UnaryClientInterceptor applies the timeout and the retry or hedging
+// policy configured for each method.
+func (r *Retrier) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mc := r.sc.lookup(method)
+		if mc == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if mc.Timeout != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(*mc.Timeout))
+			defer cancel()
+		}
+		m := metricsFor(method)
+		switch {
+		case mc.RetryPolicy != nil:
+			return r.retry(ctx, mc.RetryPolicy, m, method, req, reply, cc, invoker, opts)
+		case mc.HedgingPolicy != nil:
+			if msg, ok := reply.(proto.Message); ok {
+				return r.hedge(ctx, mc.HedgingPolicy, m, method, req, msg, cc, invoker, opts)
+			}
+		}
+		m.attempts.Add(1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// attemptContext tags the outgoing metadata of every attempt but the first.
+func attemptContext(ctx context.Context, attempt int) context.Context {
+	if attempt == 1 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, previousAttemptsKey, strconv.Itoa(attempt-1))
+}
+
+// sleep waits for d unless ctx ends first or its deadline would pass before
+// another attempt could start.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *Retrier) retry(ctx context.Context, p *RetryPolicy, m *methodMetrics, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	backoff := float64(p.InitialBackoff)
+	for attempt := 1; ; attempt++ {
+		m.attempts.Add(1)
+		err := invoker(attemptContext(ctx, attempt), method, req, reply, cc, opts...)
+		if err == nil {
+			r.throttle.success()
+			return nil
+		}
+		if !hasCode(p.RetryableStatusCodes, status.Code(err)) {
+			return err
+		}
+		r.throttle.failure()
+		if attempt >= p.MaxAttempts {
+			m.exhausted.Add(1)
+			return err
+		}
+		if !r.throttle.allow() {
+			m.throttled.Add(1)
+			return err
+		}
+		if !sleep(ctx, time.Duration(rand.Int63n(int64(backoff)+1))) {
+			m.exhausted.Add(1)
+			return err
+		}
+		if backoff *= p.BackoffMultiplier; backoff > float64(p.MaxBackoff) {
+			backoff = float64(p.MaxBackoff)
+		}
+		m.retries.Add(1)
+	}
+}
+
+// attemptOptions gives a hedged attempt its own header, trailer and peer
+// destinations, since attempts run concurrently. The returned function
+// copies them to the caller's once the attempt has won.
+func attemptOptions(opts []grpc.CallOption) ([]grpc.CallOption, func()) {
+	out := make([]grpc.CallOption, len(opts))
+	var commits []func()
+	for i, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			md := new(metadata.MD)
+			out[i] = grpc.Header(md)
+			commits = append(commits, func() { *o.HeaderAddr = *md })
+		case grpc.TrailerCallOption:
+			md := new(metadata.MD)
+			out[i] = grpc.Trailer(md)
+			commits = append(commits, func() { *o.TrailerAddr = *md })
+		case grpc.PeerCallOption:
+			p := new(peer.Peer)
+			out[i] = grpc.Peer(p)
+			commits = append(commits, func() { *o.PeerAddr = *p })
+		default:
+			out[i] = o
+		}
+	}
+	return out, func() {
+		for _, c := range commits {
+			c()
+		}
+	}
+}
+
+func (r *Retrier) hedge(ctx context.Context, p *HedgingPolicy, m *methodMetrics, method string, req interface{}, reply proto.Message, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	// The losing attempts are cancelled once the call returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply  proto.Message
+		err    error
+		commit func()
+	}
+	results := make(chan result, p.MaxAttempts)
+	launched := 0
+	launch := func() {
+		launched++
+		actx := attemptContext(ctx, launched)
+		out := reply.ProtoReflect().New().Interface()
+		aopts, commit := attemptOptions(opts)
+		m.attempts.Add(1)
+		if launched > 1 {
+			m.hedges.Add(1)
+		}
+		go func() {
+			err := invoker(actx, method, req, out, cc, aopts...)
+			results <- result{out, err, commit}
+		}()
+	}
+	// canLaunch reports whether another attempt may start.
+	canLaunch := func() bool {
+		if launched >= p.MaxAttempts || ctx.Err() != nil {
+			return false
+		}
+		if !r.throttle.allow() {
+			m.throttled.Add(1)
+			return false
+		}
+		return true
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(time.Duration(p.HedgingDelay))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if canLaunch() {
+				launch()
+				pending++
+				timer.Reset(time.Duration(p.HedgingDelay))
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				r.throttle.success()
+				res.commit()
+				proto.Reset(reply)
+				proto.Merge(reply, res.reply)
+				return nil
+			}
+			if !hasCode(p.NonFatalStatusCodes, status.Code(res.err)) {
+				res.commit()
+				return res.err
+			}
+			r.throttle.failure()
+			// A non-fatal failure starts the next attempt without waiting
+			// for the hedging delay.
+			if canLaunch() {
+				launch()
+				pending++
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(time.Duration(p.HedgingDelay))
+			} else if pending == 0 {
+				if launched >= p.MaxAttempts {
+					m.exhausted.Add(1)
+				}
+				res.commit()
+				return res.err
+			}
+		}
+	}
+}
+
+// DialOptions returns the option installing the interceptor.
+func (r *Retrier) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor())}
+}
@@ -0,0 +1,23 @@
+package runner
+
+import (
+	"flag"
+	"time"
+)
+
+// ServerFlags are the command line flags controlling the shutdown.
+type ServerFlags struct {
+	GracePeriod time.Duration
+	StopTimeout time.Duration
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.DurationVar(&f.GracePeriod, "shutdown_grace", 2*time.Second, "how long the server keeps serving after it reported itself not ready")
+	fs.DurationVar(&f.StopTimeout, "shutdown_timeout", 10*time.Second, "how long calls may take to finish before the server is stopped forcefully")
+}
+
+// Runner returns a runner with the configured timings.
+func (f *ServerFlags) Runner() *Runner {
+	return New(f.GracePeriod, f.StopTimeout)
+}
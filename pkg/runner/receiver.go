@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDraining is returned by Receiver.Recv once the server shuts down.
+// Handlers flush what they hold and return Drained, so that their clients
+// retry the rest of the work elsewhere.
+var ErrDraining = status.Error(codes.Unavailable, "server is shutting down")
+
+// Drained returns the status ending a call that stopped on ErrDraining after
+// finishing done of the messages it received: ErrDraining if there were
+// none, otherwise an Unavailable status saying how many were finished, so
+// that the client only resends the others.
+func Drained(done int) error {
+	if done == 0 {
+		return ErrDraining
+	}
+	return status.Errorf(codes.Unavailable, "server is shutting down after finishing %d messages; resend the others", done)
+}
+
+type received struct {
+	msg interface{}
+	err error
+}
+
+// Receiver reads the messages of a stream without blocking the shutdown: a
+// handler waiting for the next message is woken up when the server starts
+// draining. Handlers call Stop before they return.
+type Receiver struct {
+	recv     func() (interface{}, error)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	draining <-chan struct{}
+	next     chan received // the outstanding read, if any
+	dropped  func(msg interface{})
+}
+
+// NewReceiver returns a receiver calling recv, normally the stream's Recv,
+// for the stream of ctx.
+func NewReceiver(ctx context.Context, recv func() (interface{}, error)) *Receiver {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Receiver{recv: recv, ctx: ctx, cancel: cancel, draining: Draining(ctx), dropped: logDropped}
+}
+
+func logDropped(msg interface{}) {
+	logging.Warnf("dropped a message received after the server started draining: %v", msg)
+}
+
+// Recv returns the next message and error of recv, ErrDraining when the
+// server starts draining first, or the context error once the stream or the
+// receiver is done.
+func (r *Receiver) Recv() (interface{}, error) {
+	if r.next == nil {
+		if err := r.ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		// Only one read runs at a time.
+		r.next = make(chan received, 1)
+		go func(next chan<- received) {
+			m, err := r.recv()
+			next <- received{m, err}
+		}(r.next)
+	}
+	select {
+	case res := <-r.next:
+		r.next = nil
+		return res.msg, res.err
+	case <-r.draining:
+		// A message that has already arrived is still part of the work.
+		select {
+		case res := <-r.next:
+			r.next = nil
+			return res.msg, res.err
+		default:
+		}
+		return nil, ErrDraining
+	case <-r.ctx.Done():
+		return nil, status.FromContextError(r.ctx.Err()).Err()
+	}
+}
+
+// Stop ends the receiver: no read starts afterwards, and Stop waits for the
+// outstanding one, which ends promptly once the stream is done. The one
+// exception is a read left behind by ErrDraining: a read blocked on an idle
+// client only ends with the stream, which gRPC closes once the handler has
+// returned, so Stop leaves it to end then; a message it still receives is
+// logged as dropped.
+func (r *Receiver) Stop() {
+	r.cancel()
+	if r.next == nil {
+		return
+	}
+	select {
+	case <-r.draining:
+		go func(next <-chan received) {
+			if res := <-next; res.err == nil {
+				r.dropped(res.msg)
+			}
+		}(r.next)
+	default:
+		<-r.next
+	}
+	r.next = nil
+}
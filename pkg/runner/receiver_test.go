@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMessageDuringDrain(t *testing.T) {
+	r := New(0, time.Second)
+	ctx := context.WithValue(context.Background(), runnerKey{}, r)
+	msgs := make(chan string, 1)
+	recv := NewReceiver(ctx, func() (interface{}, error) {
+		m, ok := <-msgs
+		if !ok {
+			return nil, io.EOF
+		}
+		return m, nil
+	})
+	dropped := make(chan interface{}, 1)
+	recv.dropped = func(msg interface{}) { dropped <- msg }
+
+	msgs <- "201"
+	if msg, err := recv.Recv(); msg != "201" || err != nil {
+		t.Fatalf("Recv = %v, %v; want 201", msg, err)
+	}
+
+	// The drain starts while the handler waits for the next order; it
+	// finishes the one it has and stops.
+	close(r.draining)
+	if _, err := recv.Recv(); err != ErrDraining {
+		t.Fatalf("Recv while draining returned %v, want ErrDraining", err)
+	}
+	recv.Stop()
+	err := Drained(1)
+	if s := status.Convert(err); s.Code() != codes.Unavailable || s.Message() == status.Convert(ErrDraining).Message() {
+		t.Errorf("Drained(1) = %v, want Unavailable telling that one message was finished", err)
+	}
+
+	// The order the client sent meanwhile is not lost without a trace.
+	msgs <- "202"
+	select {
+	case msg := <-dropped:
+		if msg != "202" {
+			t.Errorf("dropped %v, want 202", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message received after the drain was not reported")
+	}
+}
+
+func TestDrainedWithoutWork(t *testing.T) {
+	if err := Drained(0); err != ErrDraining {
+		t.Errorf("Drained(0) = %v, want ErrDraining", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package runner serves a gRPC server until SIGINT or SIGTERM and then shuts
+// it down in steps: it reports itself not ready so that load balancers stop
+// sending new calls, waits a grace period for them to notice, tells open
+// streams to wrap up, stops gracefully and finally forces the remaining
+// connections closed after a timeout.
+package runner
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	"google.golang.org/grpc"
+)
+
+// Runner runs one gRPC server.
+type Runner struct {
+	// GracePeriod is how long the server keeps serving after it reported
+	// itself not ready.
+	GracePeriod time.Duration
+	// StopTimeout is how long GracefulStop may wait for calls to finish
+	// before the server is stopped forcefully.
+	StopTimeout time.Duration
+
+	ready    int32
+	draining chan struct{}
+	signals  chan os.Signal
+
+	mu         sync.Mutex
+	onNotReady []func()
+	onStopped  []func()
+}
+
+// New returns a runner with the given grace period and stop timeout.
+func New(gracePeriod, stopTimeout time.Duration) *Runner {
+	return &Runner{
+		GracePeriod: gracePeriod,
+		StopTimeout: stopTimeout,
+		draining:    make(chan struct{}),
+		signals:     make(chan os.Signal, 1),
+	}
+}
+
+// Ready reports whether the server is serving and not shutting down.
+func (r *Runner) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// OnNotReady registers f to run when the shutdown starts, e.g. to report
+// the server as not serving to health checks.
+func (r *Runner) OnNotReady(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onNotReady = append(r.onNotReady, f)
+}
+
+// OnStopped registers f to run once the server has stopped, e.g. to flush
+// and close a store. Hooks run in reverse order of registration.
+func (r *Runner) OnStopped(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onStopped = append(r.onStopped, f)
+}
+
+// Draining returns a channel closed when open streams should finish.
+func (r *Runner) Draining() <-chan struct{} {
+	return r.draining
+}
+
+// Shutdown starts the shutdown as if a signal had arrived.
+func (r *Runner) Shutdown() {
+	select {
+	case r.signals <- syscall.SIGTERM:
+	default:
+	}
+}
+
+// Run serves s on lis until a signal arrives and the shutdown completes, or
+// until serving fails.
+func (r *Runner) Run(s *grpc.Server, lis net.Listener) error {
+	signal.Notify(r.signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(r.signals)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(lis)
+	}()
+	atomic.StoreInt32(&r.ready, 1)
+
+	select {
+	case err := <-serveErr:
+		atomic.StoreInt32(&r.ready, 0)
+		r.stopped()
+		return err
+	case sig := <-r.signals:
+		logging.Infof("received %v, shutting down", sig)
+	}
+
+	atomic.StoreInt32(&r.ready, 0)
+	r.mu.Lock()
+	hooks := r.onNotReady
+	r.mu.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+	if r.GracePeriod > 0 {
+		logging.Infof("not ready, serving for another %v", r.GracePeriod)
+		time.Sleep(r.GracePeriod)
+	}
+
+	close(r.draining)
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logging.Infof("all calls finished")
+	case <-time.After(r.StopTimeout):
+		logging.Warnf("calls still running after %v, stopping", r.StopTimeout)
+		s.Stop()
+		<-done
+	}
+	r.stopped()
+	return nil
+}
+
+func (r *Runner) stopped() {
+	r.mu.Lock()
+	hooks := r.onStopped
+	r.onStopped = nil
+	r.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+type runnerKey struct{}
+
+// ServerOptions returns the options making the runner available to
+// handlers through Draining(ctx).
+func (r *Runner) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(context.WithValue(ctx, runnerKey{}, r), req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, &runnerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), runnerKey{}, r)})
+		}),
+	}
+}
+
+type runnerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *runnerStream) Context() context.Context { return s.ctx }
+
+// Draining returns the draining channel of the runner serving the call of
+// ctx, or nil, which never fires, outside of a runner.
+func Draining(ctx context.Context) <-chan struct{} {
+	if r, ok := ctx.Value(runnerKey{}).(*Runner); ok {
+		return r.draining
+	}
+	return nil
+}
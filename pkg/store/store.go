@@ -0,0 +1,124 @@
+// Package store keeps the products and orders of the ecommerce services in
+// memory. A Store is safe for concurrent use; the messages put into it must
+// not be modified afterwards, since readers share them.
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	ppb "github.com/eadydb/grpc-samples/ch02/proto"
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/gofrs/uuid"
+)
+
+// Store holds products by ID and orders by ID.
+type Store struct {
+	mu       sync.RWMutex
+	products map[string]*ppb.Product
+	orders   map[string]*opb.Order
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{
+		products: make(map[string]*ppb.Product),
+		orders:   make(map[string]*opb.Order),
+	}
+}
+
+// AddProduct stores p under a new ID, which it sets in p and returns.
+func (s *Store) AddProduct(p *ppb.Product) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	p.Id = id.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products[p.Id] = p
+	return p.Id, nil
+}
+
+// Product returns the product with the ID.
+func (s *Store) Product(id string) (*ppb.Product, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.products[id]
+	return p, ok
+}
+
+// Products returns the products whose name contains name, ordered by name
+// and ID.
+func (s *Store) Products(name string) []*ppb.Product {
+	s.mu.RLock()
+	var found []*ppb.Product
+	for _, p := range s.products {
+		if strings.Contains(p.Name, name) {
+			found = append(found, p)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].Id < found[j].Id
+	})
+	return found
+}
+
+// PutOrders adds or replaces the orders, all at once.
+func (s *Store) PutOrders(orders ...*opb.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range orders {
+		s.orders[o.Id] = o
+	}
+}
+
+// Order returns the order with the ID.
+func (s *Store) Order(id string) (*opb.Order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orders[id]
+	return o, ok
+}
+
+// SearchOrders returns the orders with an item containing query, ordered by
+// ID.
+func (s *Store) SearchOrders(query string) []*opb.Order {
+	s.mu.RLock()
+	var found []*opb.Order
+	for _, o := range s.orders {
+		for _, item := range o.Items {
+			if strings.Contains(item, query) {
+				found = append(found, o)
+				break
+			}
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	return found
+}
+
+// Stats reports the number of products and orders for the admin endpoint.
+func (s *Store) Stats() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]int{"products": len(s.products), "orders": len(s.orders)}
+}
+
+// LoadSampleOrders adds the sample orders the order clients of the book
+// expect.
+func (s *Store) LoadSampleOrders() {
+	s.PutOrders(
+		&opb.Order{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00},
+		&opb.Order{Id: "103", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00},
+		&opb.Order{Id: "104", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00},
+		&opb.Order{Id: "105", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00},
+		&opb.Order{Id: "106", Items: []string{"Amazon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 300.00},
+	)
+}
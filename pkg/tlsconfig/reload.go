@@ -0,0 +1,177 @@
+// Package tlsconfig builds TLS and mutual TLS transport credentials for the
+// ecommerce servers and clients. Certificates are re-read from disk when the
+// files change, so they can be rotated without restarting a server and
+// without dropping established connections: only new handshakes see the new
+// certificate.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+)
+
+// DefaultReloadInterval is how often the files are checked for changes.
+const DefaultReloadInterval = 10 * time.Second
+
+// watcher tracks the modification times of a set of files and tells when
+// they should be reloaded. Checks are throttled to once per interval and
+// happen lazily on handshakes, so no goroutine is needed.
+type watcher struct {
+	files    []string
+	interval time.Duration
+
+	lastCheck time.Time
+	modTimes  []time.Time
+}
+
+func newWatcher(interval time.Duration, files ...string) *watcher {
+	return &watcher{files: files, interval: interval, modTimes: make([]time.Time, len(files))}
+}
+
+// changed reports whether any file changed since the last call that
+// returned true. The caller must hold the owner's lock.
+func (w *watcher) changed(now time.Time) bool {
+	if w.interval <= 0 || now.Sub(w.lastCheck) < w.interval {
+		return false
+	}
+	w.lastCheck = now
+	changed := false
+	for i, f := range w.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			// A file may briefly disappear while being replaced; keep the old
+			// material and try again later.
+			return false
+		}
+		if !fi.ModTime().Equal(w.modTimes[i]) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (w *watcher) mark() {
+	for i, f := range w.files {
+		if fi, err := os.Stat(f); err == nil {
+			w.modTimes[i] = fi.ModTime()
+		}
+	}
+}
+
+// KeyPairReloader serves a certificate and key pair which is reloaded when
+// either file changes.
+type KeyPairReloader struct {
+	certFile, keyFile string
+
+	mu   sync.Mutex
+	w    *watcher
+	cert *tls.Certificate
+}
+
+// NewKeyPairReloader loads the key pair and checks it for changes at most
+// once per interval. An interval of zero disables reloading.
+func NewKeyPairReloader(certFile, keyFile string, interval time.Duration) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{certFile: certFile, keyFile: keyFile, w: newWatcher(interval, certFile, keyFile)}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.w.lastCheck = time.Now()
+	return r, nil
+}
+
+func (r *KeyPairReloader) reload() error {
+	r.w.mark()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig: load key pair %s: %v", r.certFile, err)
+	}
+	r.cert = &cert
+	return nil
+}
+
+func (r *KeyPairReloader) current() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w.changed(time.Now()) {
+		if err := r.reload(); err != nil {
+			logging.Warnf("keeping previous certificate: %v", err)
+		} else {
+			logging.Infof("reloaded certificate %s", r.certFile)
+		}
+	}
+	return r.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *KeyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *KeyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// CAPoolReloader serves a certificate pool read from a PEM bundle which is
+// reloaded when the file changes.
+type CAPoolReloader struct {
+	file string
+
+	mu   sync.Mutex
+	w    *watcher
+	pool *x509.CertPool
+}
+
+// NewCAPoolReloader loads the CA bundle at file.
+func NewCAPoolReloader(file string, interval time.Duration) (*CAPoolReloader, error) {
+	r := &CAPoolReloader{file: file, w: newWatcher(interval, file)}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.w.lastCheck = time.Now()
+	return r, nil
+}
+
+func (r *CAPoolReloader) reload() error {
+	r.w.mark()
+	pool, err := LoadCertPool(r.file)
+	if err != nil {
+		return err
+	}
+	r.pool = pool
+	return nil
+}
+
+// Pool returns the current pool.
+func (r *CAPoolReloader) Pool() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w.changed(time.Now()) {
+		if err := r.reload(); err != nil {
+			logging.Warnf("keeping previous CA bundle: %v", err)
+		} else {
+			logging.Infof("reloaded CA bundle %s", r.file)
+		}
+	}
+	return r.pool
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: read CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("tlsconfig: no certificates in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,167 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerFlags are the command line flags enabling TLS on a server. The
+// server keeps listening in plaintext unless a certificate is configured.
+type ServerFlags struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ReloadInterval time.Duration
+}
+
+// Register adds the flags to fs.
+func (f *ServerFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.CertFile, "tls_cert", "", "PEM certificate (chain) presented by the server; enables TLS")
+	fs.StringVar(&f.KeyFile, "tls_key", "", "PEM private key of -tls_cert")
+	fs.StringVar(&f.ClientCAFile, "tls_client_ca", "", "PEM bundle of CAs trusted for client certificates; enables mutual TLS")
+	fs.DurationVar(&f.ReloadInterval, "tls_reload_interval", DefaultReloadInterval, "how often certificate files are checked for rotation (0 disables reloading)")
+}
+
+// Enabled reports whether TLS was requested.
+func (f *ServerFlags) Enabled() bool {
+	return f.CertFile != ""
+}
+
+// Config builds the server TLS configuration. Client certificates are
+// required and verified when a client CA bundle is configured.
+func (f *ServerFlags) Config() (*tls.Config, error) {
+	if f.KeyFile == "" {
+		return nil, fmt.Errorf("tlsconfig: -tls_key is required with -tls_cert")
+	}
+	keyPair, err := NewKeyPairReloader(f.CertFile, f.KeyFile, f.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: keyPair.GetCertificate,
+	}
+	if f.ClientCAFile == "" {
+		return base, nil
+	}
+
+	cas, err := NewCAPoolReloader(f.ClientCAFile, f.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	base.ClientCAs = cas.Pool()
+	// Hand out a fresh config per handshake so a rotated CA bundle is picked
+	// up without touching connections that are already established.
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cas.Pool()
+		return c, nil
+	}
+	return base, nil
+}
+
+// ServerOptions returns the grpc.Creds option for the configured
+// certificates, or none when TLS is disabled.
+func (f *ServerFlags) ServerOptions() ([]grpc.ServerOption, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	c, err := f.Config()
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(c))}, nil
+}
+
+// DialOption returns the transport security of the connections the server
+// opens itself, such as the ones checking its downstream servers: TLS when
+// the server uses TLS, presenting the server certificate and trusting
+// -tls_client_ca or, without it, the system roots; plaintext otherwise.
+func (f *ServerFlags) DialOption() (grpc.DialOption, error) {
+	if !f.Enabled() {
+		return grpc.WithInsecure(), nil
+	}
+	c := ClientFlags{CAFile: f.ClientCAFile, CertFile: f.CertFile, KeyFile: f.KeyFile, ReloadInterval: f.ReloadInterval}
+	return c.DialOption()
+}
+
+// ClientFlags are the command line flags enabling TLS on a client.
+type ClientFlags struct {
+	TLS            bool
+	CAFile         string
+	CertFile       string
+	KeyFile        string
+	ServerName     string
+	ReloadInterval time.Duration
+}
+
+// Register adds the flags to fs.
+func (f *ClientFlags) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.TLS, "tls", false, "connect with TLS, verifying the server against the system roots or -tls_ca")
+	fs.StringVar(&f.CAFile, "tls_ca", "", "PEM bundle of CAs trusted for the server certificate; implies -tls")
+	fs.StringVar(&f.CertFile, "tls_cert", "", "PEM client certificate for mutual TLS; implies -tls")
+	fs.StringVar(&f.KeyFile, "tls_key", "", "PEM private key of -tls_cert")
+	fs.StringVar(&f.ServerName, "tls_server_name", "", "override the server name used to verify the server certificate")
+	fs.DurationVar(&f.ReloadInterval, "tls_reload_interval", DefaultReloadInterval, "how often the client certificate files are checked for rotation")
+}
+
+// Enabled reports whether TLS was requested.
+func (f *ClientFlags) Enabled() bool {
+	return f.TLS || f.CAFile != "" || f.CertFile != ""
+}
+
+// Config builds the client TLS configuration.
+func (f *ClientFlags) Config() (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: f.ServerName}
+	if f.CAFile != "" {
+		pool, err := LoadCertPool(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if f.CertFile != "" {
+		if f.KeyFile == "" {
+			return nil, fmt.Errorf("tlsconfig: -tls_key is required with -tls_cert")
+		}
+		keyPair, err := NewKeyPairReloader(f.CertFile, f.KeyFile, f.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		c.GetClientCertificate = keyPair.GetClientCertificate
+	}
+	return c, nil
+}
+
+// TransportCredentials returns the TLS credentials, or nil when TLS is
+// disabled.
+func (f *ClientFlags) TransportCredentials() (credentials.TransportCredentials, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	c, err := f.Config()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(c), nil
+}
+
+// DialOption returns the transport security dial option: TLS when enabled,
+// plaintext otherwise.
+func (f *ClientFlags) DialOption() (grpc.DialOption, error) {
+	creds, err := f.TransportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return grpc.WithInsecure(), nil
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
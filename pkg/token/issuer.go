@@ -0,0 +1,155 @@
+// Package token implements a local TokenService which hands out short-lived
+// signed access tokens to registered clients, so that authenticated flows can
+// be exercised without an external identity provider.
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/auth"
+	"github.com/eadydb/grpc-samples/pkg/logging"
+	pb "github.com/eadydb/grpc-samples/pkg/token/proto"
+	"github.com/gofrs/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const grantClientCredentials = "client_credentials"
+
+// Client is a registered client of the token service.
+type Client struct {
+	ID string `json:"client_id"`
+	// Secret is the plain client secret. SecretSHA256 may be used instead to
+	// keep only the hex encoded SHA-256 of the secret on disk.
+	Secret       string   `json:"client_secret,omitempty"`
+	SecretSHA256 string   `json:"client_secret_sha256,omitempty"`
+	Scopes       []string `json:"scopes"`
+	Roles        []string `json:"roles,omitempty"`
+	// Audiences are the audiences the client may request tokens for
+	// besides the default audience of the issuer.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+func (c *Client) checkSecret(secret string) bool {
+	if c.SecretSHA256 != "" {
+		sum := sha256.Sum256([]byte(secret))
+		want, err := hex.DecodeString(c.SecretSHA256)
+		return err == nil && subtle.ConstantTimeCompare(sum[:], want) == 1
+	}
+	return c.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1
+}
+
+// LoadClients reads the client registry, a JSON array of clients.
+func LoadClients(path string) ([]*Client, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("token: read clients: %v", err)
+	}
+	var clients []*Client
+	if err := json.Unmarshal(b, &clients); err != nil {
+		return nil, fmt.Errorf("token: parse clients %s: %v", path, err)
+	}
+	for _, c := range clients {
+		if c.ID == "" || (c.Secret == "" && c.SecretSHA256 == "") {
+			return nil, fmt.Errorf("token: clients %s: every client needs an id and a secret", path)
+		}
+	}
+	return clients, nil
+}
+
+// Issuer implements the TokenService.
+type Issuer struct {
+	signer   *auth.Signer
+	clients  map[string]*Client
+	ttl      time.Duration
+	issuer   string
+	audience string
+	now      func() time.Time
+
+	pb.UnimplementedTokenServiceServer
+}
+
+// NewIssuer returns an issuer signing tokens with signer which stay valid for
+// ttl. issuer and audience are written to the iss and default aud claims.
+func NewIssuer(signer *auth.Signer, clients []*Client, ttl time.Duration, issuer, audience string) *Issuer {
+	m := make(map[string]*Client, len(clients))
+	for _, c := range clients {
+		m[c.ID] = c
+	}
+	return &Issuer{signer: signer, clients: m, ttl: ttl, issuer: issuer, audience: audience, now: time.Now}
+}
+
+func (s *Issuer) IssueToken(ctx context.Context, req *pb.TokenRequest) (*pb.TokenResponse, error) {
+	if req.GrantType != grantClientCredentials {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported grant type %q", req.GrantType)
+	}
+	client, ok := s.clients[req.ClientId]
+	if !ok || !client.checkSecret(req.ClientSecret) {
+		// Do not tell unknown clients apart from wrong secrets.
+		return nil, status.Errorf(codes.Unauthenticated, "invalid client credentials")
+	}
+
+	scopes := client.Scopes
+	if len(req.Scopes) > 0 {
+		for _, sc := range req.Scopes {
+			if !contains(client.Scopes, sc) {
+				return nil, status.Errorf(codes.PermissionDenied, "scope %q is not granted to client %s", sc, client.ID)
+			}
+		}
+		scopes = req.Scopes
+	}
+
+	audience := s.audience
+	if req.Audience != "" && req.Audience != s.audience {
+		if !contains(client.Audiences, req.Audience) {
+			return nil, status.Errorf(codes.PermissionDenied, "audience %q is not granted to client %s", req.Audience, client.ID)
+		}
+		audience = req.Audience
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate token id: %v", err)
+	}
+	now := s.now()
+	claims := &auth.Claims{
+		Subject:   client.ID,
+		Issuer:    s.issuer,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		ID:        id.String(),
+		Roles:     client.Roles,
+		Scope:     strings.Join(scopes, " "),
+	}
+	if audience != "" {
+		claims.Audience = auth.Audience{audience}
+	}
+	token, err := s.signer.Sign(claims)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to sign token: %v", err)
+	}
+	logging.Infof("Issued token %s to %s, scopes %v", claims.ID, client.ID, scopes)
+	return &pb.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.ttl / time.Second),
+		Scopes:      scopes,
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
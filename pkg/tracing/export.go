@@ -0,0 +1,343 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter receives finished spans.
+type Exporter interface {
+	ExportSpans(spans []*SpanData) error
+	Shutdown() error
+}
+
+// FileExporter appends spans to a file, one OTLP/JSON
+// ExportTraceServiceRequest document per line.
+type FileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// NewFileExporter opens (or creates) path for appending.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: open %s: %v", path, err)
+	}
+	return &FileExporter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// ExportSpans writes spans as a single JSON line and flushes it to the file,
+// so that a crashing process does not lose finished spans.
+func (e *FileExporter) ExportSpans(spans []*SpanData) error {
+	b, err := json.Marshal(ToOTLP(spans))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.f == nil {
+		return fmt.Errorf("tracing: exporter is shut down")
+	}
+	if _, err := e.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Shutdown flushes and closes the file.
+func (e *FileExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.f == nil {
+		return nil
+	}
+	err := e.w.Flush()
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	e.f = nil
+	return err
+}
+
+// InMemoryCollector keeps every exported span in memory. It is handy for
+// tests and for in-process inspection.
+type InMemoryCollector struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+// NewInMemoryCollector returns an empty collector.
+func NewInMemoryCollector() *InMemoryCollector {
+	return &InMemoryCollector{}
+}
+
+func (c *InMemoryCollector) ExportSpans(spans []*SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func (c *InMemoryCollector) Shutdown() error { return nil }
+
+// Spans returns a copy of the collected spans.
+func (c *InMemoryCollector) Spans() []*SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*SpanData(nil), c.spans...)
+}
+
+// Trace returns the collected spans of one trace.
+func (c *InMemoryCollector) Trace(id TraceID) []*SpanData {
+	var out []*SpanData
+	for _, s := range c.Spans() {
+		if s.Context.TraceID == id {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Reset drops every collected span.
+func (c *InMemoryCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = nil
+}
+
+// The types below follow the JSON mapping of the OTLP
+// ExportTraceServiceRequest message (opentelemetry/proto/trace/v1).
+
+type OTLPRequest struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPKeyValue `json:"attributes,omitempty"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []OTLPKeyValue `json:"attributes,omitempty"`
+	Events            []OTLPEvent    `json:"events,omitempty"`
+	Status            OTLPStatus     `json:"status"`
+}
+
+type OTLPEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []OTLPKeyValue `json:"attributes,omitempty"`
+}
+
+type OTLPStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type OTLPKeyValue struct {
+	Key   string    `json:"key"`
+	Value OTLPValue `json:"value"`
+}
+
+type OTLPValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+const scopeName = "github.com/eadydb/grpc-samples/pkg/tracing"
+
+// ToOTLP groups spans by service into an OTLP export request.
+func ToOTLP(spans []*SpanData) *OTLPRequest {
+	req := &OTLPRequest{}
+	index := make(map[string]int)
+	for _, s := range spans {
+		i, ok := index[s.Service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[s.Service] = i
+			req.ResourceSpans = append(req.ResourceSpans, OTLPResourceSpans{
+				Resource: OTLPResource{Attributes: []OTLPKeyValue{
+					{Key: "service.name", Value: OTLPValue{StringValue: s.Service}},
+				}},
+				ScopeSpans: []OTLPScopeSpans{{Scope: OTLPScope{Name: scopeName}}},
+			})
+		}
+		ss := &req.ResourceSpans[i].ScopeSpans[0]
+		ss.Spans = append(ss.Spans, toOTLPSpan(s))
+	}
+	return req
+}
+
+func toOTLPSpan(s *SpanData) OTLPSpan {
+	out := OTLPSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		TraceState:        s.Context.TraceState,
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: unixNano(s.Start),
+		EndTimeUnixNano:   unixNano(s.End),
+		Attributes:        toKeyValues(s.Attributes),
+		Status:            OTLPStatus{Code: s.StatusCode, Message: s.StatusMessage},
+	}
+	if s.Parent.IsValid() {
+		out.ParentSpanID = s.Parent.String()
+	}
+	for _, ev := range s.Events {
+		out.Events = append(out.Events, OTLPEvent{
+			TimeUnixNano: unixNano(ev.Time),
+			Name:         ev.Name,
+			Attributes:   toKeyValues(ev.Attributes),
+		})
+	}
+	return out
+}
+
+func toKeyValues(attrs map[string]string) []OTLPKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]OTLPKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, OTLPKeyValue{Key: k, Value: OTLPValue{StringValue: attrs[k]}})
+	}
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// ReadSpans decodes every span from a stream of OTLP/JSON documents as
+// written by FileExporter.
+func ReadSpans(r io.Reader) ([]*SpanData, error) {
+	var spans []*SpanData
+	dec := json.NewDecoder(r)
+	for {
+		var req OTLPRequest
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return spans, fmt.Errorf("tracing: decode spans: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			service := ""
+			for _, kv := range rs.Resource.Attributes {
+				if kv.Key == "service.name" {
+					service = kv.Value.StringValue
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, sp := range ss.Spans {
+					s, err := fromOTLPSpan(service, sp)
+					if err != nil {
+						return spans, err
+					}
+					spans = append(spans, s)
+				}
+			}
+		}
+	}
+}
+
+func fromOTLPSpan(service string, in OTLPSpan) (*SpanData, error) {
+	s := &SpanData{
+		Service:       service,
+		Name:          in.Name,
+		Kind:          in.Kind,
+		Attributes:    fromKeyValues(in.Attributes),
+		StatusCode:    in.Status.Code,
+		StatusMessage: in.Status.Message,
+	}
+	s.Context.TraceState = in.TraceState
+	if err := decodeID(s.Context.TraceID[:], in.TraceID); err != nil {
+		return nil, err
+	}
+	if err := decodeID(s.Context.SpanID[:], in.SpanID); err != nil {
+		return nil, err
+	}
+	if in.ParentSpanID != "" {
+		if err := decodeID(s.Parent[:], in.ParentSpanID); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if s.Start, err = parseUnixNano(in.StartTimeUnixNano); err != nil {
+		return nil, err
+	}
+	if s.End, err = parseUnixNano(in.EndTimeUnixNano); err != nil {
+		return nil, err
+	}
+	for _, ev := range in.Events {
+		t, err := parseUnixNano(ev.TimeUnixNano)
+		if err != nil {
+			return nil, err
+		}
+		s.Events = append(s.Events, Event{Name: ev.Name, Time: t, Attributes: fromKeyValues(ev.Attributes)})
+	}
+	return s, nil
+}
+
+func fromKeyValues(kvs []OTLPKeyValue) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.StringValue
+	}
+	return m
+}
+
+func decodeID(dst []byte, s string) error {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return fmt.Errorf("tracing: bad id %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func parseUnixNano(s string) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("tracing: bad timestamp %q", s)
+	}
+	return time.Unix(0, n), nil
+}
@@ -0,0 +1,277 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// Inject writes the span context of the current span into the outgoing
+// metadata of ctx.
+func Inject(ctx context.Context) context.Context {
+	sc := SpanFromContext(ctx).Context()
+	if !sc.IsValid() {
+		return ctx
+	}
+	// Drop headers left over from an earlier hop so the callee only sees us.
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		md.Set(tracestateHeader, sc.TraceState)
+	} else {
+		delete(md, tracestateHeader)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// Extract reads a remote span context from the incoming metadata of ctx and
+// returns a context whose next span is its child.
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	vals := md.Get(traceparentHeader)
+	if len(vals) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(vals[0])
+	if err != nil {
+		// An invalid traceparent starts a new trace, as the W3C spec demands.
+		return ctx
+	}
+	if ts := md.Get(tracestateHeader); len(ts) > 0 {
+		sc.TraceState = strings.Join(ts, ",")
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// splitMethod splits "/package.Service/method" into service and method.
+func splitMethod(fullMethod string) (string, string) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "unknown", name
+}
+
+func startRPCSpan(ctx context.Context, t *Tracer, fullMethod string, kind SpanKind) (context.Context, *Span) {
+	service, method := splitMethod(fullMethod)
+	ctx, span := t.Start(ctx, strings.TrimPrefix(fullMethod, "/"), kind)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.service", service)
+	span.SetAttribute("rpc.method", method)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		span.SetAttribute("net.peer.name", p.Addr.String())
+	}
+	return ctx, span
+}
+
+func endRPCSpan(span *Span, err error) {
+	st, _ := status.FromError(err)
+	span.SetAttribute("rpc.grpc.status_code", st.Code().String())
+	if err != nil {
+		span.SetStatus(StatusError, st.Message())
+	} else {
+		span.SetStatus(StatusOK, "")
+	}
+	span.End()
+}
+
+// UnaryServerInterceptor starts a server span for every unary RPC, continuing
+// the trace found in the incoming metadata.
+func UnaryServerInterceptor(t *Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startRPCSpan(Extract(ctx), t, info.FullMethod, SpanKindServer)
+		span.AddEvent("message", messageAttrs("received", 1, req))
+		resp, err := handler(ctx, req)
+		if err == nil {
+			span.AddEvent("message", messageAttrs("sent", 1, resp))
+		}
+		endRPCSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor starts a server span for every streaming RPC and a
+// child span for each message sent or received on the stream.
+func StreamServerInterceptor(t *Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startRPCSpan(Extract(ss.Context()), t, info.FullMethod, SpanKindServer)
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx, tracer: t})
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor starts a client span for every unary RPC and sends
+// its context to the server as traceparent/tracestate metadata.
+func UnaryClientInterceptor(t *Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startRPCSpan(ctx, t, method, SpanKindClient)
+		span.SetAttribute("net.peer.name", cc.Target())
+		span.AddEvent("message", messageAttrs("sent", 1, req))
+		err := invoker(Inject(ctx), method, req, reply, cc, opts...)
+		if err == nil {
+			span.AddEvent("message", messageAttrs("received", 1, reply))
+		}
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span for every streaming RPC and a
+// child span for each message sent or received on the stream. The RPC span
+// ends when the stream finishes or its context is done, whichever comes
+// first.
+func StreamClientInterceptor(t *Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startRPCSpan(ctx, t, method, SpanKindClient)
+		span.SetAttribute("net.peer.name", cc.Target())
+		cs, err := streamer(Inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			endRPCSpan(span, err)
+			return nil, err
+		}
+		s := &tracedClientStream{ClientStream: cs, ctx: ctx, tracer: t, span: span, desc: desc, done: make(chan struct{})}
+		go s.watch()
+		return s, nil
+	}
+}
+
+// ServerOptions returns the options installing both server interceptors.
+func ServerOptions(t *Tracer) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(t)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(t)),
+	}
+}
+
+// DialOptions returns the options installing both client interceptors.
+func DialOptions(t *Tracer) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(t)),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(t)),
+	}
+}
+
+func messageAttrs(direction string, seq int64, m interface{}) map[string]string {
+	return map[string]string{
+		"message.type": direction,
+		"message.id":   fmt.Sprint(seq),
+		"message.name": fmt.Sprintf("%T", m),
+	}
+}
+
+// messageSpan records one stream message as a child span of ctx's span.
+func messageSpan(ctx context.Context, t *Tracer, direction string, seq int64, m interface{}, err error) {
+	_, span := t.Start(ctx, direction+" "+strings.TrimPrefix(fmt.Sprintf("%T", m), "*"), SpanKindInternal)
+	for k, v := range messageAttrs(direction, seq, m) {
+		span.SetAttribute(k, v)
+	}
+	if err != nil && err != io.EOF {
+		span.SetStatus(StatusError, err.Error())
+	}
+	span.End()
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	tracer *Tracer
+	sent   int64
+	recv   int64
+}
+
+func (s *tracedServerStream) Context() context.Context { return s.ctx }
+
+func (s *tracedServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	messageSpan(s.ctx, s.tracer, "sent", atomic.AddInt64(&s.sent, 1), m, err)
+	return err
+}
+
+func (s *tracedServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == io.EOF {
+		return err
+	}
+	messageSpan(s.ctx, s.tracer, "received", atomic.AddInt64(&s.recv, 1), m, err)
+	return err
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	tracer *Tracer
+	span   *Span
+	desc   *grpc.StreamDesc
+	sent   int64
+	recv   int64
+
+	endOnce sync.Once
+	done    chan struct{}
+}
+
+// end ends the RPC span once.
+func (s *tracedClientStream) end(err error) {
+	s.endOnce.Do(func() {
+		close(s.done)
+		endRPCSpan(s.span, err)
+	})
+}
+
+// watch ends the RPC span when the caller's context is done first: a
+// cancelled stream is often never read again.
+func (s *tracedClientStream) watch() {
+	select {
+	case <-s.ctx.Done():
+		code := codes.Canceled
+		if s.ctx.Err() == context.DeadlineExceeded {
+			code = codes.DeadlineExceeded
+		}
+		s.end(status.Error(code, s.ctx.Err().Error()))
+	case <-s.done:
+	}
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	messageSpan(s.ctx, s.tracer, "sent", atomic.AddInt64(&s.sent, 1), m, err)
+	if err != nil && err != io.EOF {
+		s.end(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	default:
+		messageSpan(s.ctx, s.tracer, "received", atomic.AddInt64(&s.recv, 1), m, nil)
+		// A stream without server streaming is finished after one response.
+		if !s.desc.ServerStreams {
+			s.end(nil)
+		}
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	opb "github.com/eadydb/grpc-samples/ch04/cancellation/proto"
+	"github.com/eadydb/grpc-samples/pkg/fakeserver"
+	"github.com/eadydb/grpc-samples/pkg/grpctest"
+	"github.com/eadydb/grpc-samples/pkg/tracing"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+func clientSpan(c *tracing.InMemoryCollector) *tracing.SpanData {
+	for _, s := range c.Spans() {
+		if s.Kind == tracing.SpanKindClient {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestCancelledClientStreamEndsSpan(t *testing.T) {
+	fake := fakeserver.New()
+	err := fake.Add(fakeserver.Rule{
+		Method:    "searchOrders",
+		Responses: []fakeserver.Response{{Message: fakeserver.Message(&opb.Order{Id: "202"}), Delay: fakeserver.Duration(time.Minute)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := tracing.NewInMemoryCollector()
+	conn := grpctest.NewServer(t, fake.Register).Dial(t, tracing.DialOptions(tracing.NewTracer("client", spans))...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := opb.NewOrderManagementClient(conn).SearchOrders(ctx, &wrappers.StringValue{Value: "Kindle"}); err != nil {
+		t.Fatal(err)
+	}
+	// The stream is never read: cancelling it alone has to end the span.
+	cancel()
+	grpctest.Wait(t, "the client span of the cancelled stream", func() bool { return clientSpan(spans) != nil })
+	if span := clientSpan(spans); span.StatusCode != tracing.StatusError || span.Attributes["rpc.grpc.status_code"] != "Canceled" {
+		t.Errorf("span ended with %v %q", span.StatusCode, span.Attributes["rpc.grpc.status_code"])
+	}
+}
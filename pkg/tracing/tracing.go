@@ -0,0 +1,340 @@
+// Package tracing implements a small distributed tracer for the ecommerce
+// samples. Trace context is propagated between processes with the W3C
+// `traceparent` and `tracestate` headers carried in gRPC metadata, and
+// finished spans are handed to an Exporter which writes them out as
+// OTLP-compatible JSON.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eadydb/grpc-samples/pkg/logging"
+)
+
+const (
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// TraceID is the 16 byte identifier shared by every span of a trace.
+type TraceID [16]byte
+
+// SpanID is the 8 byte identifier of a single span.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the trace id is not all zeroes.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the span id is not all zeroes.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	Remote     bool
+}
+
+// IsValid reports whether both ids of the span context are set.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// IsSampled reports whether the sampled flag is set.
+func (sc SpanContext) IsSampled() bool { return sc.Flags&flagSampled != 0 }
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(v string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" {
+		return sc, fmt.Errorf("tracing: unsupported traceparent version %q", version)
+	}
+	// Version 00 has exactly four fields, later versions may append more.
+	if version == traceparentVersion && len(parts) != 4 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return sc, fmt.Errorf("tracing: bad trace id in %q: %v", v, err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return sc, fmt.Errorf("tracing: bad span id in %q: %v", v, err)
+	}
+	var f [1]byte
+	if _, err := hex.Decode(f[:], []byte(flags)); err != nil {
+		return sc, fmt.Errorf("tracing: bad trace flags in %q: %v", v, err)
+	}
+	sc.Flags = f[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("tracing: all-zero ids in traceparent %q", v)
+	}
+	sc.Remote = true
+	return sc, nil
+}
+
+// SpanKind mirrors the OTLP span kinds.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// StatusCode mirrors the OTLP span status codes.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Event is a timestamped annotation on a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]string
+}
+
+// SpanData is the immutable snapshot of a finished span handed to exporters.
+type SpanData struct {
+	Service       string
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]string
+	Events        []Event
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Duration returns the wall time covered by the span.
+func (d *SpanData) Duration() time.Duration { return d.End.Sub(d.Start) }
+
+// Span is a single timed operation within a trace. A nil *Span is valid and
+// ignores every call, which keeps call sites free of nil checks.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span context used to propagate this span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute records a key/value attribute on the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// AddEvent records a timestamped event on the span.
+func (s *Span) AddEvent(name string, attrs map[string]string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+// SetStatus sets the final status of the span.
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = msg
+}
+
+// End finishes the span and exports it if it is sampled. Calling End more
+// than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.IsSampled() {
+		s.tracer.export(&data)
+	}
+}
+
+// Tracer creates spans for one service and sends them to an exporter.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a tracer which exports finished spans of service to
+// exporter. A nil exporter drops every span but still propagates context.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// NewFileTracer returns a tracer which appends its spans to path. An empty
+// path yields a tracer that only propagates context.
+func NewFileTracer(service, path string) (*Tracer, error) {
+	if path == "" {
+		return NewTracer(service, nil), nil
+	}
+	exp, err := NewFileExporter(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTracer(service, exp), nil
+}
+
+// Service returns the service name recorded on every span.
+func (t *Tracer) Service() string { return t.service }
+
+// Close shuts down the exporter.
+func (t *Tracer) Close() error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown()
+}
+
+func (t *Tracer) export(d *SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	if err := t.exporter.ExportSpans([]*SpanData{d}); err != nil {
+		logging.Warnf("failed to export span %s: %v", d.Name, err)
+	}
+}
+
+// Start creates a span as a child of the span or remote span context stored
+// in ctx, or a new root span if there is none. The returned context carries
+// the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx).Context()
+	if !parent.IsValid() {
+		parent = RemoteSpanContextFromContext(ctx)
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Flags = flagSampled
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Service: t.service,
+			Name:    name,
+			Kind:    kind,
+			Context: sc,
+			Parent:  parent.SpanID,
+			Start:   time.Now(),
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx whose next span will be
+// a child of the remote span context sc.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// RemoteSpanContextFromContext returns the remote parent stored in ctx.
+func RemoteSpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}